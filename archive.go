@@ -0,0 +1,194 @@
+package main
+
+import (
+    "os"
+    "io"
+    "io/fs"
+    "path"
+    "strings"
+    "sync/atomic"
+    "archive/zip"
+    "archive/tar"
+    "compress/gzip"
+)
+
+//Archive entries are named like archive.zip!/entry/path
+const archiveSeparator = "!/"
+
+type archiveEntry struct {
+    name string
+    fi fs.FileInfo
+}
+
+func isArchive(name string) bool {
+    return isZipArchive(name) || isTarArchive(name)
+}
+
+func isZipArchive(name string) bool {
+    return strings.HasSuffix(strings.ToLower(name), ".zip")
+}
+
+func isTarArchive(name string) bool {
+    name = strings.ToLower(name)
+    return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+func openArchive(file string) (fs.FS, io.Closer, []archiveEntry, error) {
+    //Open archive, list regular files in it
+    //Closer is nil if nothing needs to be closed
+    if isZipArchive(file) {
+        zr, err := zip.OpenReader(file)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        var entries []archiveEntry
+        for _, f := range zr.File {
+            if !f.Mode().IsRegular() || !fs.ValidPath(f.Name) {
+                continue
+            }
+            entries = append(entries, archiveEntry{f.Name, f.FileInfo()})
+        }
+        zfs := &zipFS{path: file, reader: zr}
+        return zfs, zfs, entries, nil
+    }
+
+    //Tar archive (gzip), no random access
+    tfs := tarFS(file)
+    var entries []archiveEntry
+    err := tfs.walk(func(name string, header *tar.Header) bool {
+        entries = append(entries, archiveEntry{name, header.FileInfo()})
+        return true
+    })
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return tfs, nil, entries, nil
+}
+
+//Zip archive as filesystem, kept open during the scan
+//Reopened for every file that's opened later (copying files for review, ...)
+type zipFS struct {
+    path string
+    reader *zip.ReadCloser
+    closed atomic.Bool
+}
+
+type zipFile struct {
+    fs.File
+    archive io.Closer
+}
+
+func (zfs *zipFS) Open(name string) (fs.File, error) {
+    if !zfs.closed.Load() {
+        return zfs.reader.Open(name)
+    }
+    zr, err := zip.OpenReader(zfs.path)
+    if err != nil {
+        return nil, err
+    }
+    f, err := zr.Open(name)
+    if err != nil {
+        zr.Close()
+        return nil, err
+    }
+    return &zipFile{f, zr}, nil
+}
+
+func (zfs *zipFS) Close() error {
+    zfs.closed.Store(true)
+    return zfs.reader.Close()
+}
+
+func (zf *zipFile) Close() error {
+    err := zf.File.Close()
+    if closeErr := zf.archive.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+//Tar archive (gzip) as filesystem
+//Every file that's opened is searched from the beginning of the archive
+type tarFS string
+
+type tarFile struct {
+    io.Reader
+    fi fs.FileInfo
+    closers []io.Closer
+}
+
+func (tfs tarFS) walk(fn func(name string, header *tar.Header) bool) error {
+    f, err := os.Open(string(tfs))
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    gz, err := gzip.NewReader(f)
+    if err != nil {
+        return err
+    }
+    defer gz.Close()
+
+    //Regular files only, stop if callback returns false
+    tr := tar.NewReader(gz)
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+        if header.Typeflag != tar.TypeReg || !fs.ValidPath(name) {
+            continue
+        }
+        if !fn(name, header) {
+            return nil
+        }
+    }
+}
+
+func (tfs tarFS) Open(name string) (fs.File, error) {
+    f, err := os.Open(string(tfs))
+    if err != nil {
+        return nil, err
+    }
+    gz, err := gzip.NewReader(f)
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+
+    //Find entry, file remains open while entry is read
+    tr := tar.NewReader(gz)
+    for {
+        header, err := tr.Next()
+        if err != nil {
+            gz.Close()
+            f.Close()
+            if err == io.EOF {
+                err = fs.ErrNotExist
+            }
+            return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+        }
+        entryName := path.Clean(strings.TrimPrefix(header.Name, "./"))
+        if header.Typeflag == tar.TypeReg && entryName == name {
+            return &tarFile{tr, header.FileInfo(), []io.Closer{gz, f}}, nil
+        }
+    }
+}
+
+func (tf *tarFile) Stat() (fs.FileInfo, error) {
+    return tf.fi, nil
+}
+
+func (tf *tarFile) Close() error {
+    var firstErr error
+    for _, closer := range tf.closers {
+        if err := closer.Close(); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
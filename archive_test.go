@@ -0,0 +1,98 @@
+package main
+
+import (
+    "os"
+    "testing"
+    "archive/zip"
+    "path/filepath"
+)
+
+func writeTestZip(t testing.TB, path string, files map[string]string) {
+    //Create zip archive (entry name -> content)
+    t.Helper()
+    f, err := os.Create(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    w := zip.NewWriter(f)
+    for name, content := range files {
+        entry, err := w.Create(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := entry.Write([]byte(content)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestActionsKeepFileOnDisk(t *testing.T) {
+    //A copy in an archive must never be the only one left
+    tests := []struct {
+        name string
+        files map[string]string
+        archive map[string]string
+        keepLast bool
+        remaining []string //files on disk after deleting duplicates
+    }{
+        {"one file on disk", map[string]string{
+            "a": "same",
+        }, map[string]string{"x": "same"}, false, []string{"a"}},
+        {"one file on disk, keep last", map[string]string{
+            "a": "same",
+        }, map[string]string{"x": "same", "y": "same"}, true, []string{"a"}},
+        {"two files on disk", map[string]string{
+            "a": "same", "b": "same",
+        }, map[string]string{"x": "same"}, false, []string{"a"}},
+        {"two files on disk, keep last", map[string]string{
+            "a": "same", "b": "same",
+        }, map[string]string{"x": "same"}, true, []string{"b"}},
+        {"archive only", map[string]string{
+            "a": "other",
+        }, map[string]string{"x": "same", "y": "same"}, false, []string{"a"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, test.files)
+            writeTestZip(t, filepath.Join(dir, "archive.zip"), test.archive)
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.ScanArchives = true
+            scan.KeepLast = test.keepLast
+            runScan(t, scan)
+            if len(scan.DuplicatesMap()) != 1 {
+                t.Fatalf("Found %d duplicate groups, expected 1", len(scan.DuplicatesMap()))
+            }
+
+            scan.DeleteDuplicates(func(file *File) string { return file.Path })
+            for _, name := range test.remaining {
+                if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+                    t.Errorf("File deleted: %s", name)
+                }
+            }
+            for name := range test.files {
+                path := filepath.Join(dir, name)
+                if _, err := os.Stat(path); err == nil && !contains(test.remaining, name) {
+                    t.Errorf("File not deleted: %s", name)
+                }
+            }
+            if _, err := os.Stat(filepath.Join(dir, "archive.zip")); err != nil {
+                t.Errorf("Archive deleted")
+            }
+        })
+    }
+}
+
+func contains(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,15 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+func getCreationTime(fi os.FileInfo) int64 {
+    if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+        return int64(stat.Birthtimespec.Sec)
+    }
+    return 0
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !freebsd && !netbsd
+
+package main
+
+import (
+    "os"
+)
+
+func getCreationTime(fi os.FileInfo) int64 {
+    //Creation time not available
+    return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+func (scan *Scan) BucketAnalysis(prefixLen int) map[string]int {
+    //Count files by hash prefix (first hex characters), unhashed files are skipped
+    buckets := make(map[string]int)
+    for _, file := range scan.Files {
+        hash := file.HashValueFor(scan.hashAlgorithm())
+        if hash == "" {
+            continue
+        }
+        if i := strings.Index(hash, ":"); i >= 0 {
+            hash = hash[i + 1:] //without algorithm prefix
+        }
+        if len(hash) > prefixLen {
+            hash = hash[:prefixLen]
+        }
+        buckets[hash]++
+    }
+
+    return buckets
+}
+
+func PrintBucketHistogram(w io.Writer, buckets map[string]int) {
+    //One line per bucket, bar scaled to largest bucket
+    const barWidth = 50
+    prefixes := make([]string, 0, len(buckets))
+    var max int
+    for prefix, count := range buckets {
+        prefixes = append(prefixes, prefix)
+        if count > max {
+            max = count
+        }
+    }
+    sort.Strings(prefixes)
+    for _, prefix := range prefixes {
+        count := buckets[prefix]
+        bar := strings.Repeat("#", (count * barWidth + max - 1) / max)
+        fmt.Fprintf(w, "%s\t%d\t%s\n", prefix, count, bar)
+    }
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestBucketAnalysis(t *testing.T) {
+    //Counts add up to the number of hashed files, no hash files map needed
+    scan := NewScan()
+    for i := 0; i < 100; i++ {
+        path := fmt.Sprint(i)
+        file := &File{Path: path, Name: path, Size: 1}
+        if err := file.HashReader(strings.NewReader(path)); err != nil {
+            t.Fatal(err)
+        }
+        scan.Files[path] = file
+    }
+    scan.Files["unhashed"] = &File{Path: "unhashed", Name: "unhashed", Size: 1}
+
+    for _, prefixLen := range []int{1, 2, 3} {
+        t.Run(fmt.Sprint(prefixLen), func(t *testing.T) {
+            var total int
+            for prefix, count := range scan.BucketAnalysis(prefixLen) {
+                if len(prefix) != prefixLen {
+                    t.Errorf("Bucket %q, expected %d characters", prefix, prefixLen)
+                }
+                total += count
+            }
+            if total != 100 {
+                t.Errorf("%d files in buckets, expected 100", total)
+            }
+        })
+    }
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "sort"
+)
+
+func (scan *Scan) FindChains() [][]string {
+    //Files connected by any hash (all registered algorithms), transitively
+    //With a single hash algorithm, chains are the same as duplicate groups
+    //Only chains spanning more than one group of the scan's hash are returned
+    paths := scan.Files.Keys()
+    parent := make([]int, len(paths))
+    for i := range parent {
+        parent[i] = i
+    }
+    var find func(i int) int
+    find = func(i int) int {
+        if parent[i] != i {
+            parent[i] = find(parent[i]) //path compression
+        }
+        return parent[i]
+    }
+    union := func(i, j int) {
+        parent[find(i)] = find(j)
+    }
+
+    //Union files with the same hash value, for each algorithm
+    firstByHash := make(map[string]int)
+    for i, path := range paths {
+        file := scan.Files[path]
+        if file.Size == 0 {
+            continue //empty files are never duplicates
+        }
+        for _, algorithm := range HashAlgorithms() {
+            hash := file.HashOf(algorithm)
+            if hash == "" {
+                continue
+            }
+            key := algorithm + ":" + hash
+            if first, found := firstByHash[key]; found {
+                union(i, first)
+            } else {
+                firstByHash[key] = i
+            }
+        }
+    }
+
+    //Collect components, count groups (scan's hash) in each one
+    components := make(map[int][]string)
+    groups := make(map[int]map[string]bool)
+    algorithm := scan.hashAlgorithm()
+    for i, path := range paths {
+        root := find(i)
+        components[root] = append(components[root], path)
+        if groups[root] == nil {
+            groups[root] = make(map[string]bool)
+        }
+        groups[root][scan.Files[path].HashOf(algorithm)] = true
+    }
+    var chains [][]string
+    for root, component := range components {
+        if len(component) < 2 || len(groups[root]) < 2 {
+            continue
+        }
+        chains = append(chains, component) //paths already sorted
+    }
+    sort.Slice(chains, func(i, j int) bool {
+        return chains[i][0] < chains[j][0]
+    })
+
+    return chains
+}
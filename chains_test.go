@@ -0,0 +1,34 @@
+package main
+
+import (
+    "testing"
+    "reflect"
+)
+
+func TestFindChains(t *testing.T) {
+    //Files linked by hashes of different algorithms, chains span several duplicate groups
+    scan := NewScan()
+    for _, file := range []*File{
+        {Path: "a", MD5: "1", SHA1: "x"},
+        {Path: "b", MD5: "1", SHA1: "y"}, //a-b by MD5
+        {Path: "c", MD5: "2", SHA1: "y"}, //b-c by SHA-1
+        {Path: "d", MD5: "3", SHA1: "z"},
+        {Path: "e", MD5: "3"}, //one group only, not a chain
+        {Path: "f", MD5: "4", SHA1: "w"},
+        {Path: "g", MD5: "5", SHA256: "s"},
+        {Path: "h", MD5: "6", SHA256: "s"}, //g-h by SHA-256
+        {Path: "i", MD5: "6"}, //h-i by MD5
+        {Path: "empty", MD5: "1", SHA1: "y"}, //empty files never linked
+    } {
+        file.Name = file.Path
+        if file.Path != "empty" {
+            file.Size = 10
+        }
+        scan.Files[file.Path] = file
+    }
+    scan.BuildHashFilesMap()
+    expected := [][]string{{"a", "b", "c"}, {"g", "h", "i"}}
+    if chains := scan.FindChains(); !reflect.DeepEqual(chains, expected) {
+        t.Errorf("Chains %v, expected %v", chains, expected)
+    }
+}
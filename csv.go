@@ -0,0 +1,140 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "strconv"
+    "encoding/csv"
+)
+
+//Columns of exported CSV file, header line
+var csvColumns = []string{"path", "full_path", "name", "size", "mtime", "md5", "sha1", "inum"}
+
+func (scan *Scan) ExportCSV(file string) error {
+    //Export file list to CSV file
+    fmt.Fprintf(verboseIO, "Exporting CSV file: %s\n", file)
+    f, err := os.Create(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    if err := scan.ExportCSVTo(f); err != nil {
+        return err
+    }
+    return f.Sync()
+}
+
+func (scan *Scan) ExportCSVTo(w io.Writer) error {
+    //One file per line, sorted by path
+    //Paths with commas, quotes or newlines are quoted
+    cw := csv.NewWriter(w)
+    if err := cw.Write(csvColumns); err != nil {
+        return err
+    }
+    for _, path := range scan.Files.Keys() {
+        file := scan.Files[path]
+        record := []string{
+            file.Path,
+            file.FullPath,
+            file.Name,
+            strconv.FormatInt(file.Size, 10),
+            strconv.FormatInt(file.ModificationTime, 10),
+            file.MD5,
+            file.SHA1,
+            strconv.FormatUint(file.Inum, 10),
+        }
+        if err := cw.Write(record); err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        return err
+    }
+    fmt.Fprintf(verboseIO, "Done exporting CSV file (%d files)\n", len(scan.Files))
+
+    return nil
+}
+
+func (scan *Scan) ImportCSV(file string) error {
+    //Import file list from CSV file (exported by ExportCSV)
+    fmt.Fprintf(verboseIO, "Importing CSV file: %s\n", file)
+    f, err := os.Open(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    return scan.importCSV(f, file)
+}
+
+func (scan *Scan) importCSV(r io.Reader, file string) error {
+    scan.importedMaps++
+
+    //Working directory, imported paths are resolved relative to it
+    workingDir, err := os.Getwd()
+    if err != nil {
+        return err
+    }
+
+    //Header line, columns may be in any order
+    cr := csv.NewReader(r)
+    header, err := cr.Read()
+    if err != nil {
+        return err
+    }
+    columns := make(map[string]int)
+    for i, name := range header {
+        columns[name] = i
+    }
+    for _, name := range []string{"path", "full_path", "name", "size", "mtime"} {
+        if _, found := columns[name]; !found {
+            return fmt.Errorf("CSV column missing: %s (%s)", name, file)
+        }
+    }
+    field := func(record []string, name string) string {
+        if i, found := columns[name]; found {
+            return record[i]
+        }
+        return ""
+    }
+
+    //One file per line
+    for {
+        record, err := cr.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        importedFile := &File{
+            Path: field(record, "path"),
+            FullPath: field(record, "full_path"),
+            Name: field(record, "name"),
+            MD5: field(record, "md5"),
+            SHA1: field(record, "sha1"),
+        }
+        if importedFile.Size, err = strconv.ParseInt(field(record, "size"), 10, 64); err != nil {
+            return fmt.Errorf("Invalid size in CSV file: %s (%s)", importedFile.Path, file)
+        }
+        if importedFile.ModificationTime, err = strconv.ParseInt(field(record, "mtime"), 10, 64); err != nil {
+            return fmt.Errorf("Invalid mtime in CSV file: %s (%s)", importedFile.Path, file)
+        }
+        if inum := field(record, "inum"); inum != "" {
+            if importedFile.Inum, err = strconv.ParseUint(inum, 10, 64); err != nil {
+                return fmt.Errorf("Invalid inum in CSV file: %s (%s)", importedFile.Path, file)
+            }
+        }
+        if err := scan.addImportedFile(importedFile, file, workingDir); err != nil {
+            return err
+        }
+    }
+
+    //Build hash files map
+    scan.BuildHashFilesMap()
+
+    return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "bytes"
+    "runtime"
+    "testing"
+    "reflect"
+    "path/filepath"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+    //Names with commas, quotes and newlines quoted, all exported fields preserved
+    if runtime.GOOS == "windows" {
+        t.Skip("Quotes and newlines not allowed in file names")
+    }
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "plain": "same", "a,b": "same", `say "hi"`: "other", "new\nline": "other", "sub/x, \"y\"": "unique",
+    })
+    scan := scanTestDir(t, dir)
+    for _, file := range scan.Files {
+        file.SHA1 = "sha1-" + file.Name //exported, not set by scan
+    }
+    var buf bytes.Buffer
+    if err := scan.ExportCSVTo(&buf); err != nil {
+        t.Fatal(err)
+    }
+
+    imported := NewScan()
+    if err := imported.importCSV(&buf, "input"); err != nil {
+        t.Fatal(err)
+    }
+    if len(imported.Files) != len(scan.Files) {
+        t.Fatalf("%d files imported, expected %d", len(imported.Files), len(scan.Files))
+    }
+    for path, file := range scan.Files {
+        expected := File{
+            Path: file.Path, FullPath: file.FullPath, Name: file.Name, Size: file.Size,
+            ModificationTime: file.ModificationTime, MD5: file.MD5, SHA1: file.SHA1, Inum: file.Inum,
+        }
+        importedFile := imported.Files[path]
+        if importedFile == nil {
+            t.Errorf("File missing: %q", path)
+            continue
+        }
+        if !reflect.DeepEqual(*importedFile, expected) {
+            t.Errorf("File %+v, expected %+v", *importedFile, expected)
+        }
+    }
+    expectedGroups := [][]string{
+        {filepath.Join(dir, "a,b"), filepath.Join(dir, "plain")},
+        {filepath.Join(dir, "new\nline"), filepath.Join(dir, `say "hi"`)},
+    }
+    if groups := groupPaths(imported.DuplicatesMap()); !reflect.DeepEqual(groups, expectedGroups) {
+        t.Errorf("Groups %q, expected %q", groups, expectedGroups)
+    }
+}
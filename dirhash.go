@@ -0,0 +1,130 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "sort"
+    "strings"
+    "path/filepath"
+    "encoding/hex"
+    "crypto/md5"
+)
+
+func (scan *Scan) BuildDirHashMap() map[string]string {
+    //Collect hashes of all files in each directory (including subdirectories)
+    dirHashes := make(map[string][]string)
+    incomplete := make(map[string]bool) //directories with unhashed files
+    for _, file := range scan.Files {
+        for dir := filepath.Dir(file.Path); scan.inScanPath(dir); dir = filepath.Dir(dir) {
+            if !file.IsHashed() {
+                incomplete[dir] = true
+            } else {
+                dirHashes[dir] = append(dirHashes[dir], file.HashValueFor(scan.hashAlgorithm()))
+            }
+            if parent := filepath.Dir(dir); parent == dir {
+                break //root
+            }
+        }
+    }
+
+    //Directory hash is hash of sorted file hashes (file names don't matter)
+    dirHashMap := make(map[string]string)
+    for dir, hashes := range dirHashes {
+        if incomplete[dir] {
+            continue
+        }
+        sort.Strings(hashes)
+        h := md5.New()
+        io.WriteString(h, strings.Join(hashes, "\n"))
+        dirHashMap[dir] = hex.EncodeToString(h.Sum(nil))
+    }
+
+    return dirHashMap
+}
+
+func (scan *Scan) inScanPath(dir string) bool {
+    //Directory within one of the search paths, any directory if none
+    if len(scan.Paths) == 0 {
+        return true
+    }
+    for _, path := range scan.Paths {
+        path = filepath.Clean(path)
+        if dir == path || strings.HasPrefix(dir, path + string(filepath.Separator)) {
+            return true
+        }
+        if path == "." && !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "..") {
+            return true
+        }
+    }
+    return false
+}
+
+func (scan *Scan) DuplicateDirs() map[string][]string {
+    //Group directories by directory hash
+    hashDirs := make(map[string][]string)
+    for dir, hash := range scan.BuildDirHashMap() {
+        hashDirs[hash] = append(hashDirs[hash], dir)
+    }
+
+    //Keep groups with more than one directory
+    duplicateDirs := make(map[string][]string)
+    for hash, dirs := range hashDirs {
+        if len(dirs) < 2 {
+            continue
+        }
+        sort.Strings(dirs)
+        duplicateDirs[hash] = dirs
+    }
+
+    return duplicateDirs
+}
+
+func PrintDuplicateDirs(w io.Writer, duplicateDirs map[string][]string) {
+    //Groups ordered by first directory
+    groups := make([][]string, 0, len(duplicateDirs))
+    for _, dirs := range duplicateDirs {
+        groups = append(groups, dirs)
+    }
+    sort.Slice(groups, func(i, j int) bool {
+        return groups[i][0] < groups[j][0]
+    })
+    for _, dirs := range groups {
+        for _, dir := range dirs {
+            fmt.Fprintf(w, "%s\n", dir)
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
+
+func (scan *Scan) UniqueDirectories() []string {
+    //Directories without additional files (duplicates that would be removed)
+    //Only files directly in a directory count, not files in subdirectories
+    dirs := make(map[string]bool)
+    for _, file := range scan.Files {
+        if file.ArchivePath != "" {
+            continue
+        }
+        for dir := filepath.Dir(file.Path); scan.inScanPath(dir); dir = filepath.Dir(dir) {
+            if dirs[dir] {
+                break //parents already added
+            }
+            dirs[dir] = true
+            if parent := filepath.Dir(dir); parent == dir {
+                break //root
+            }
+        }
+    }
+    for _, files := range scan.AdditionalFilesMap() {
+        for _, file := range files {
+            delete(dirs, filepath.Dir(file.Path))
+        }
+    }
+
+    uniqueDirs := make([]string, 0, len(dirs))
+    for dir := range dirs {
+        uniqueDirs = append(uniqueDirs, dir)
+    }
+    sort.Strings(uniqueDirs)
+
+    return uniqueDirs
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "sort"
+    "bytes"
+    "testing"
+    "reflect"
+    "path/filepath"
+)
+
+func TestDuplicateDirs(t *testing.T) {
+    //Identical trees, subdirectories grouped as well, file names don't matter
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "backup1/x": "x", "backup1/sub/y": "y",
+        "backup2/x": "x", "backup2/sub/renamed": "y",
+        "other/x": "x", "other/z": "z",
+    })
+    scan := scanTestDir(t, dir)
+    var groups [][]string
+    for _, dirs := range scan.DuplicateDirs() {
+        groups = append(groups, dirs)
+    }
+    sort.Slice(groups, func(i, j int) bool {
+        return groups[i][0] < groups[j][0]
+    })
+    expected := [][]string{
+        {filepath.Join(dir, "backup1"), filepath.Join(dir, "backup2")},
+        {filepath.Join(dir, "backup1", "sub"), filepath.Join(dir, "backup2", "sub")},
+    }
+    if !reflect.DeepEqual(groups, expected) {
+        t.Fatalf("Duplicate directories %v, expected %v", groups, expected)
+    }
+
+    var buf bytes.Buffer
+    PrintDuplicateDirs(&buf, scan.DuplicateDirs())
+    output := expected[0][0] + "\n" + expected[0][1] + "\n\n" + expected[1][0] + "\n" + expected[1][1] + "\n\n"
+    if buf.String() != output {
+        t.Errorf("Output %q, expected %q", buf.String(), output)
+    }
+}
+
+func TestUniqueDirectories(t *testing.T) {
+    //Directories without files that would be removed, files in subdirectories don't count
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a_originals/x": "x", "a_originals/y": "y", //kept (first by path)
+        "copies/x": "x", "copies/y": "y", //duplicates only
+        "copies/sub/z": "z",
+        "clean/u1": "u1", "clean/u2": "u2", //unique files only
+    })
+    scan := scanTestDir(t, dir)
+    expected := []string{
+        dir, filepath.Join(dir, "a_originals"), filepath.Join(dir, "clean"), filepath.Join(dir, "copies", "sub"),
+    }
+    if dirs := scan.UniqueDirectories(); !reflect.DeepEqual(dirs, expected) {
+        t.Errorf("Unique directories %v, expected %v", dirs, expected)
+    }
+}
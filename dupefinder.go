@@ -3,18 +3,52 @@ package main
 import (
     "fmt"
     "os"
+    "os/signal"
+    "context"
     "flag"
     "sync"
-    "path/filepath"
     "io"
-    "io/ioutil"
     "bytes"
     "runtime"
+    "strconv"
+    "errors"
+    "io/fs"
+    "strings"
+    "time"
+    "regexp"
 
     "github.com/dustin/go-humanize"
 )
 
 var verboseIO io.Writer
+var outputIO io.Writer = os.Stdout //results (listing, summary)
+
+func displayPath(useFullPath bool, useRelativePath bool) func(*File) string {
+    //File paths should be relative, so that a mounted network share
+    //can be scanned using a map file created on the remote host.
+    return func(file *File) string {
+        var path string
+        if useFullPath {
+            path = file.FullPath
+        } else if useRelativePath && file.RelativePath != "" {
+            path = file.RelativePath
+        } else {
+            path = file.Path
+        }
+        return path
+    }
+}
+
+func accessPath(useFullPath bool) func(*File) string {
+    //Path used to access files (delete, link, server, ...)
+    //Relative path is for display only, it depends on the search path
+    return func(file *File) string {
+        if useFullPath {
+            return file.FullPath
+        }
+        return file.Path
+    }
+}
 
 func main() {
     //Usage
@@ -29,7 +63,7 @@ func main() {
         fmt.Printf("If you're running DupeFinder on the same exact directory again (using the same path argument as before), you have the option to skip the scan that would do a sanity check on the imported map data (-skip-scan). This will make the second run take even less time. It will immediately start removing duplicates if you've told it to do so. All the paths must be identical. If you're using a different directory path when specifying this option than you did when exporting the map, the program might delete the wrong files. If you're using this option to skip the scan, you should not have the program remove duplicate files unless you know what you are doing.\n")
         fmt.Printf("If you've not specified an action, it will immediately print the summary. If you've specified a hash file to be created, it will merely copy the contents of the imported map.\n")
         fmt.Printf("\n")
-        fmt.Printf("After the scan has completed, DupeFinder has a map in memory, representing the contents of the scanned directory. It will list all the duplicate groups, i.e., identical files (same hash) grouped together (-list-duplicate-groups). These groups are sorted by path (-sort-path), file name (-sort-name) or modification time, newest first (-sort-time). If the program is told to get rid of the duplicates, it will keep the first file of each group.\n")
+        fmt.Printf("After the scan has completed, DupeFinder has a map in memory, representing the contents of the scanned directory. It will list all the duplicate groups, i.e., identical files (same hash) grouped together (-list-duplicate-groups). These groups are sorted by path (-sort-path), file name (-sort-name), modification time, newest first (-sort-time), inode number (-sort-inode), path length, shortest first (-sort-path-length) or creation time, newest first (-sort-creation-time, macOS/BSD only). If more than one of these options is given, the last one wins. If the program is told to get rid of the duplicates, it will keep the first file of each group (or the last one with -keep-last).\n")
         fmt.Printf("\n")
         fmt.Printf("To delete duplicate files, use -delete-duplicates. Be careful. You should first run the program without this option and make sure that all additional files (all files except the first one in each group) can be deleted. Then run the program again, with -delete-duplicates. You should also export a map file the first time you run it and import it the second time to prevent it from scanning everything again.\n")
         fmt.Printf("\n")
@@ -38,57 +72,359 @@ func main() {
     }
 
     //Define arguments
-    var mapFileImport string
-    flag.StringVar(&mapFileImport, "import-map-file", "", "map file to import, imported files won't be hashed (superficial scan)")
+    var mapFileImport stringList
+    flag.Var(&mapFileImport, "import-map-file",
+        "map file to import, imported files won't be hashed (superficial scan), may be repeated (last one wins for the same path)")
+    var requireHash bool
+    flag.BoolVar(&requireHash, "require-hash", false,
+        "fail if an imported map file contains files without hash")
+    var seedSumFiles stringList
+    flag.Var(&seedSumFiles, "seed-sum-file", "use hashes from existing sum file (like MD5SUMS, must match -hash-algorithm) for unmodified files, may be repeated")
+    var rebaseImportedPaths string
+    flag.StringVar(&rebaseImportedPaths, "rebase-imported-paths", "",
+        "move imported files to this directory (like a different mount point), keeping their path below the original search path")
     var mapFileExport string
     flag.StringVar(&mapFileExport, "export-map-file", "", "map file to export")
+    var exportMapWithMetadata bool
+    flag.BoolVar(&exportMapWithMetadata, "export-map-with-metadata", false,
+        "include metadata (time, hostname, scan paths) in exported map file")
+    var duplicatesMapFileExport string
+    flag.StringVar(&duplicatesMapFileExport, "export-duplicates-map-file", "",
+        "compact map file to export, containing only duplicate files")
+    var csvFileExport string
+    flag.StringVar(&csvFileExport, "export-csv-file", "",
+        "export file list as CSV file (path, full_path, name, size, mtime, md5, sha1, inum)")
+    var outputFile string
+    flag.StringVar(&outputFile, "output-file", "",
+        "write results (listing, summary) to file instead of stdout")
     var exportFileReplace bool
     flag.BoolVar(&exportFileReplace, "file-replace", false,
         "replace file when exporting file")
     var hashMD5FileExport string
     flag.StringVar(&hashMD5FileExport, "export-md5sums-file", "", "export MD5SUMS file")
+    var hashSHA1FileExport string
+    flag.StringVar(&hashSHA1FileExport, "export-sha1sums-file", "", "export SHA1SUMS file (requires -compute-sha1 or -hash-algorithm sha1)")
+    var hashBLAKE2bFileExport string
+    flag.StringVar(&hashBLAKE2bFileExport, "export-blake2bsums-file", "", "export BLAKE2BSUMS file (requires -hash-algorithm blake2b)")
+    var hashAlgorithm string
+    flag.StringVar(&hashAlgorithm, "hash-algorithm", "md5",
+        "hash algorithm used to compare files (md5, sha1, sha256, sha512, blake2b, blake3)")
+    var computeSHA1 bool
+    flag.BoolVar(&computeSHA1, "compute-sha1", false,
+        "also compute SHA1 hashes (stored in map file), in the same pass")
     var skipScan bool
     flag.BoolVar(&skipScan, "skip-scan", false,
         "skip scan when map is provided instead of doing superficial scan")
     var listDuplicateGroups bool
     flag.BoolVar(&listDuplicateGroups, "list-duplicate-groups", true,
         "list duplicate groups")
+    var listNonDuplicates bool
+    flag.BoolVar(&listNonDuplicates, "list-non-duplicate-groups", false,
+        "list unique files (files without any identical copy), sorted like duplicate groups")
+    var showGroupNumber bool
+    flag.BoolVar(&showGroupNumber, "show-group-number", false,
+        "print a numbered header line before each duplicate group")
+    var showFileNumber bool
+    flag.BoolVar(&showFileNumber, "show-file-number", false,
+        "prefix each listed file with its position in the group, like [1/3]")
+    var showMtime bool
+    flag.BoolVar(&showMtime, "show-mtime", false,
+        "append modification time to each listed file, like [2024-01-15 10:30:00]")
+    var timeFormat string
+    flag.StringVar(&timeFormat, "time-format", "2006-01-02 15:04:05",
+        "format of modification time (-show-mtime), Go time layout")
+    var showSize bool
+    flag.BoolVar(&showSize, "show-size", false,
+        "append file size to each listed file")
+    var fdupesOutput bool
+    flag.BoolVar(&fdupesOutput, "fdupes-output", false,
+        "list duplicate groups in fdupes format (groups separated by empty lines, no summary)")
+    var reportFormat string
+    flag.StringVar(&reportFormat, "report-format", "",
+        "format of duplicate listing and summary (text, json, ndjson, csv, html, fdupes)")
+    var compactOutput bool
+    flag.BoolVar(&compactOutput, "compact-output", false,
+        "list one line per duplicate group: hash, file count and first file, separated by tabs")
+    var nullSeparated bool
+    flag.BoolVar(&nullSeparated, "null-separated", false,
+        "terminate lines with NUL instead of newline (-compact-output)")
+    var listHashesOnly bool
+    flag.BoolVar(&listHashesOnly, "list-hashes-only", false,
+        "list only the hash of each duplicate group")
+    var listFirstOnly bool
+    flag.BoolVar(&listFirstOnly, "list-first-only", false,
+        "list only the file that would be kept in each duplicate group")
+    var sameNameOnly bool
+    flag.BoolVar(&sameNameOnly, "same-name-only", false,
+        "only list duplicates if all files in the group have the same name")
+    var differentNameOnly bool
+    flag.BoolVar(&differentNameOnly, "different-name-only", false,
+        "only list duplicates if files in the group have different names")
+    var includeHardlinks bool
+    flag.BoolVar(&includeHardlinks, "include-hardlinks", false,
+        "list all paths of hardlinked files (marked [hardlink]), even if they waste no space")
+    var dedupeWithinDir bool
+    flag.BoolVar(&dedupeWithinDir, "dedupe-within-dir", false,
+        "only list duplicates in the same directory (like IMG_001.jpg and IMG_001_copy.jpg)")
+    var minCopies int
+    flag.IntVar(&minCopies, "min-copies", 2,
+        "only list duplicate groups with at least this many identical files")
+    var maxCopies int
+    flag.IntVar(&maxCopies, "max-copies", 0,
+        "only list duplicate groups with at most this many identical files (0 = no limit)")
+    var topN int
+    flag.IntVar(&topN, "top-n", 0,
+        "list only the N duplicate groups with the most wasted space")
+    var listPathDuplicates bool
+    flag.BoolVar(&listPathDuplicates, "list-path-duplicates", false,
+        "list files with the same name in different places, annotated with [SAME CONTENT] or [DIFFERENT CONTENT]")
+    var listChains bool
+    flag.BoolVar(&listChains, "list-chains", false,
+        "list files connected through different hash algorithms (like imported maps), for debugging")
+    var listCleanDirs bool
+    flag.BoolVar(&listCleanDirs, "list-clean-dirs", false,
+        "list directories that contain no duplicate files (files that would be deleted)")
+    var listDuplicateDirs bool
+    flag.BoolVar(&listDuplicateDirs, "list-duplicate-dirs", false,
+        "list groups of directories with identical contents")
+    var listByExtension bool
+    flag.BoolVar(&listByExtension, "list-by-extension", false,
+        "print table of duplicate groups, files and wasted space by file extension")
+    var topWasted int
+    flag.IntVar(&topWasted, "top-wasted", 0,
+        "print the N duplicate groups with the most wasted space (wasted space, file count, first file)")
+    var listLargeUniqueFiles int
+    flag.IntVar(&listLargeUniqueFiles, "list-large-unique-files", 0,
+        "print the N largest files without duplicates (size, path)")
+    var showExtBreakdown bool
+    flag.BoolVar(&showExtBreakdown, "show-ext-breakdown", false,
+        "add wasted space by file extension (top 10) to the summary")
+    var listSizeDistribution bool
+    flag.BoolVar(&listSizeDistribution, "list-size-distribution", false,
+        "print table of duplicate groups, files and wasted space by file size")
+    var bucketAnalysis int
+    flag.IntVar(&bucketAnalysis, "bucket-analysis", 0,
+        "print histogram of files by hash prefix of given length (like 2)")
+    var validateMap bool
+    flag.BoolVar(&validateMap, "validate-map", false,
+        "check file map for inconsistent entries (map key does not match path, missing full path)")
+    var printFingerprint bool
+    flag.BoolVar(&printFingerprint, "print-fingerprint", false,
+        "print fingerprint of scanned files (changes if any file changes)")
+    var statsOnly bool
+    flag.BoolVar(&statsOnly, "stats-only", false,
+        "only show summary, don't list duplicates, write review directories, serve results or run any action")
     var showSummary bool
     flag.BoolVar(&showSummary, "show-summary", true,
         "show summary of found duplicates")
     var deleteDuplicates bool
     flag.BoolVar(&deleteDuplicates, "delete-duplicates", false,
-        "delete duplicates (keep first file per group)")
+        "delete duplicates (keep first file per group, see -keep-last)")
+    var writeGroupsToDir string
+    flag.StringVar(&writeGroupsToDir, "write-groups-to-dir", "",
+        "hardlink each listed duplicate group into a subdirectory of this directory for review (files in archives are copied)")
+    var noHardlink bool
+    flag.BoolVar(&noHardlink, "no-hardlink", false,
+        "copy files instead of hardlinking them (-write-groups-to-dir)")
     var linkDuplicates bool
     flag.BoolVar(&linkDuplicates, "link-duplicates", false,
         "replace duplicates with hardlinks")
+    var symlinkDuplicates bool
+    flag.BoolVar(&symlinkDuplicates, "symlink-duplicates", false,
+        "replace duplicates with symlinks to the kept file")
+    var symlinkAbsolute bool
+    flag.BoolVar(&symlinkAbsolute, "symlink-absolute", false,
+        "use absolute symlink targets (default, -symlink-duplicates)")
+    var symlinkRelative bool
+    flag.BoolVar(&symlinkRelative, "symlink-relative", false,
+        "use symlink targets relative to the duplicate (-symlink-duplicates)")
+    var renameDuplicates string
+    flag.StringVar(&renameDuplicates, "rename-duplicates", "",
+        "rename duplicates by appending this suffix (like .dup) instead of deleting them")
+    var renameDryRun bool
+    flag.BoolVar(&renameDryRun, "rename-dry-run", false,
+        "only print how duplicates would be renamed (-rename-duplicates)")
+    var linkFullPaths bool
+    flag.BoolVar(&linkFullPaths, "link-full-paths", false,
+        "link using full paths, independent of working directory (-link-duplicates)")
+    var keepLast bool
+    flag.BoolVar(&keepLast, "keep-last", false,
+        "keep last file per group instead of first one")
+    var keepLongestPath bool
+    flag.BoolVar(&keepLongestPath, "keep-largest-path", false,
+        "keep file with the longest path per group (deepest, most organized location)")
     var sortReversed bool
     flag.BoolVar(&sortReversed, "sort-reversed", false,
         "show duplicate groups in reversed order")
-    var sortPath bool
-    flag.BoolVar(&sortPath, "sort-path", true,
+    //Sort options, last one wins
+    sortOrder := SortByPath
+    flag.Var(&sortFlag{&sortOrder, SortByPath}, "sort-path",
         "sort duplicate groups by file path")
-    var sortName bool
-    flag.BoolVar(&sortName, "sort-name", false,
+    flag.Var(&sortFlag{&sortOrder, SortByName}, "sort-name",
         "sort duplicate groups by file name")
-    var sortTime bool
-    flag.BoolVar(&sortTime, "sort-time", false,
+    flag.Var(&sortFlag{&sortOrder, SortByTime}, "sort-time",
         "sort duplicate groups by file time")
+    flag.Var(&sortFlag{&sortOrder, SortByInode}, "sort-inode",
+        "sort duplicate groups by inode number")
+    flag.Var(&sortFlag{&sortOrder, SortByPathLength}, "sort-path-length",
+        "sort duplicate groups by path length, shortest first")
+    flag.Var(&sortFlag{&sortOrder, SortByCreationTime}, "sort-creation-time",
+        "sort duplicate groups by creation time, newest first (macOS/BSD only)")
+    var sortGroupsBy string
+    flag.StringVar(&sortGroupsBy, "sort-groups-by", "path",
+        "order of duplicate groups: path (first file), size (wasted space), count (files), hash")
+    var sortGroupsReversed bool
+    flag.BoolVar(&sortGroupsReversed, "sort-groups-reversed", false,
+        "reverse order of duplicate groups (see -sort-groups-by)")
     var useFullPath bool
     flag.BoolVar(&useFullPath, "use-full-path", false,
         "use absolute instead of relative path for scanned files")
+    var useRelativePath bool
+    flag.BoolVar(&useRelativePath, "use-relative-path", false,
+        "use path relative to the search path for scanned files (like photos/a.jpg for search path /mnt/share)")
+    var fastMode bool
+    flag.BoolVar(&fastMode, "fast-mode", false,
+        "compare file samples first, fully hash only files with matching samples")
+    var verifyGroupSizes bool
+    flag.BoolVar(&verifyGroupSizes, "verify-group-sizes", false,
+        "check that all files with the same hash have the same size")
+    var useIgnoreFiles bool
+    flag.BoolVar(&useIgnoreFiles, "use-ignore-files", false,
+        "exclude files matching patterns in .dupeignore files (like .gitignore), including ~/.dupeignore")
+    var pathsFromStdin bool
+    flag.BoolVar(&pathsFromStdin, "paths-from-stdin", false,
+        "read additional files or directories from stdin, one per line")
+    var fileList string
+    flag.StringVar(&fileList, "file-list", "",
+        "read files to scan from text file, one path per line (not walked, missing files are skipped)")
+    var nulSeparated bool
+    flag.BoolVar(&nulSeparated, "0", false,
+        "paths read from stdin are separated by NUL characters (find -print0)")
+    var skipLockedFiles bool
+    flag.BoolVar(&skipLockedFiles, "skip-locked", false,
+        "skip files locked by another process (always on Windows)")
+    var dedupeByInode bool
+    flag.BoolVar(&dedupeByInode, "dedupe-by-inode", true,
+        "hash hardlinks (same inode) only once")
+    var retryModified bool
+    flag.BoolVar(&retryModified, "retry-modified", false,
+        "hash file again if it was modified while it was hashed")
+    var warnZeroMtime bool
+    flag.BoolVar(&warnZeroMtime, "warn-zero-mtime", false,
+        "warn about files with a modification time of 0 (1970-01-01), which are always hashed")
     var verboseMode bool
     flag.BoolVar(&verboseMode, "verbose", false,
         "verbose output")
     var workerCount int
     flag.IntVar(&workerCount, "worker-count", runtime.NumCPU(),
         "number of scan workers, how many files to process in parallel")
+    var serveAddr string
+    flag.StringVar(&serveAddr, "serve", "",
+        "serve results via HTTP JSON API on given address (like :8080, local only without host) until interrupted")
+    var serveAuth string
+    flag.StringVar(&serveAuth, "serve-auth", "",
+        "basic auth credentials (user:pass) for -serve, required for non-local addresses")
+    var serveAllowDelete bool
+    flag.BoolVar(&serveAllowDelete, "serve-allow-delete", false,
+        "allow deleting duplicates via HTTP (DELETE /file)")
+    var excludeDirNames stringList
+    flag.Var(&excludeDirNames, "exclude-dir-name",
+        "exclude directories with this name at any depth (like node_modules), may be repeated")
+    var includePathRegex stringList
+    flag.Var(&includePathRegex, "include-path-regex",
+        "only scan files with a path matching this regular expression (like '/(raw|original)/'), may be repeated")
+    var excludePathRegex stringList
+    flag.Var(&excludePathRegex, "exclude-path-regex",
+        "don't scan files with a path matching this regular expression, may be repeated, applied after -include-path-regex")
+    var scanArchives bool
+    flag.BoolVar(&scanArchives, "scan-archives", false,
+        "scan files in zip and tar.gz archives (archive.zip!/path)")
+    var oneFilesystem bool
+    flag.BoolVar(&oneFilesystem, "one-filesystem", false,
+        "don't descend into directories on other filesystems (like find -xdev)")
+    var noRecurse bool
+    flag.BoolVar(&noRecurse, "no-recurse", false,
+        "only scan files directly in the given directories, not in subdirectories")
+    var excludeNewerThan, excludeOlderThan ageFlag
+    flag.Var(&excludeNewerThan, "exclude-newer-than",
+        "don't scan files modified within this duration (like 12h or 7d)")
+    flag.Var(&excludeOlderThan, "exclude-older-than",
+        "don't scan files modified before this duration (like 365d)")
+    var failOnPermissionDenied bool
+    flag.BoolVar(&failOnPermissionDenied, "fail-on-permission-denied", false,
+        "exit with error if a file or directory could not be read (permission denied)")
+    var parallelWalk bool
+    flag.BoolVar(&parallelWalk, "parallel-walk", false,
+        "read directories concurrently (fast SSDs)")
+    var walkParallelism int
+    flag.IntVar(&walkParallelism, "walk-parallelism", 4,
+        "number of directories read at the same time with -parallel-walk")
+    var hashSizeLimit int64
+    flag.Int64Var(&hashSizeLimit, "size-limit-per-file", 0,
+        "only hash beginning and end of files larger than this (bytes), total of this many bytes (risky, 0 = hash complete files)")
+    var mmapThreshold int64
+    flag.Int64Var(&mmapThreshold, "hash-mmap-threshold", 1 << 30,
+        "hash files of this size (bytes) or larger using mmap, 0 to disable")
+    var ownedBy string
+    flag.StringVar(&ownedBy, "owned-by", "",
+        "only scan files owned by this user (Unix only)")
+    var ownedByGroup string
+    flag.StringVar(&ownedByGroup, "owned-by-group", "",
+        "only scan files owned by this group (Unix only)")
+    var scanTimeout time.Duration
+    flag.DurationVar(&scanTimeout, "scan-timeout", 0,
+        "stop scan after this time (like 30m), continue with the files scanned so far")
+    var maxFiles int
+    flag.IntVar(&maxFiles, "max-files", 0,
+        "stop scanning after this many files (0 = no limit)")
+    var pidFile string
+    flag.StringVar(&pidFile, "pid-file", "",
+        "write PID to file while running, refuse to start if another instance is running")
+    var printVersion bool
+    flag.BoolVar(&printVersion, "version", false,
+        "print version information and exit")
 
     //Parse arguments
     flag.Parse()
-    if flag.NArg() == 0 {
+    if printVersion {
+        fmt.Println(versionInfo())
+        exit(0)
+    }
+    if flag.NArg() == 0 && !pathsFromStdin && fileList == "" {
         flag.Usage()
-        os.Exit(0)
+        exit(0)
+    }
+
+    //Summary only, no listing and no actions
+    if statsOnly {
+        listDuplicateGroups = false
+        listHashesOnly = false
+        compactOutput = false
+        fdupesOutput = false
+        listNonDuplicates = false
+        listFirstOnly = false
+        listPathDuplicates = false
+        listChains = false
+        listCleanDirs = false
+        listDuplicateDirs = false
+        listByExtension = false
+        topWasted = 0
+        listLargeUniqueFiles = 0
+        listSizeDistribution = false
+        bucketAnalysis = 0
+        printFingerprint = false
+        showSummary = true
+        writeGroupsToDir = ""
+        serveAddr = ""
+        deleteDuplicates = false
+        linkDuplicates = false
+        symlinkDuplicates = false
+        renameDuplicates = ""
+    }
+
+    //Format of fdupes, nothing else
+    if fdupesOutput {
+        showSummary = false
     }
 
     //Verbose output
@@ -98,16 +434,49 @@ func main() {
     }
 
     //Helper for file path
-    //File paths should be relative, so that a mounted network share
-    //can be scanned using a map file created on the remote host.
-    filePath := func(file *File) string {
-        var path string
-        if useFullPath {
-            path = file.FullPath
-        } else {
-            path = file.Path
+    filePath := displayPath(useFullPath, useRelativePath)
+    actionPath := accessPath(useFullPath)
+
+    //Report format replaces default listing and summary
+    var reporter ReportWriter
+    if reportFormat != "" {
+        var found bool
+        if reporter, found = ReportWriters(filePath)[reportFormat]; !found {
+            fmt.Fprintf(os.Stderr, "Unknown report format: %s\n", reportFormat)
+            exit(1)
         }
-        return path
+    }
+
+    //Check group sort order
+    if _, err := SortDuplicateGroups(nil, sortGroupsBy, false); err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err)
+        exit(1)
+    }
+
+    //Imported map can only be replaced if there's just one
+    if exportFileReplace && mapFileExport == "" && len(mapFileImport) > 1 {
+        fmt.Fprintf(os.Stderr, "Multiple map files imported, specify map file to export\n")
+        exit(1)
+    }
+
+    //Name filters exclude each other
+    if sameNameOnly && differentNameOnly {
+        fmt.Fprintf(os.Stderr, "Options -same-name-only and -different-name-only can't be combined\n")
+        exit(1)
+    }
+
+    //Check server credentials
+    if !validServerAuth(serveAuth) {
+        fmt.Fprintf(os.Stderr, "Invalid credentials, expected user:pass\n")
+        exit(1)
+    }
+    if serveAddr != "" {
+        addr, err := serverAddr(serveAddr, serveAuth)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s\n", err)
+            exit(1)
+        }
+        serveAddr = addr
     }
 
     //Wait lock
@@ -115,42 +484,178 @@ func main() {
 
     //Scan object
     scan := NewScan()
-    if sortPath {
-        scan.SortOrder = 0
-    }
-    if sortName {
-        scan.SortOrder = 1
+    scan.SortOrder = sortOrder
+    scan.SortReversed = sortReversed
+    scan.KeepLast = keepLast
+    scan.KeepLongestPath = keepLongestPath
+    scan.LinkFullPaths = linkFullPaths
+    if symlinkAbsolute && symlinkRelative {
+        fmt.Fprintf(os.Stderr, "Options -symlink-absolute and -symlink-relative can't be combined\n")
+        exit(1)
     }
-    if sortTime {
-        scan.SortOrder = 3
+    scan.SymlinkRelative = symlinkRelative
+    if fastMode && (hashMD5FileExport != "" || hashSHA1FileExport != "" || hashBLAKE2bFileExport != "") {
+        //Files with a unique size or sample are never fully hashed
+        fmt.Fprintf(os.Stderr, "Hash files can't be exported in fast mode, not all files are hashed\n")
+        exit(1)
     }
-    scan.SortReversed = sortReversed
+    scan.FastMode = fastMode
+    scan.VerifyGroupSizes = verifyGroupSizes
+    scan.SameNameOnly = sameNameOnly
+    scan.DifferentNameOnly = differentNameOnly
+    scan.SameDirOnly = dedupeWithinDir
+    scan.MinCopies = minCopies
+    scan.MaxCopies = maxCopies
+    scan.SkipLockedFiles = skipLockedFiles
+    scan.WarnZeroMtime = warnZeroMtime
+    scan.RetryModifiedFiles = retryModified
+    scan.DedupeByInode = dedupeByInode
+    scan.UseIgnoreFiles = useIgnoreFiles
+    scan.ExportMetadata = exportMapWithMetadata
+    scan.RequireHash = requireHash
     scan.WorkerCount = workerCount
+    scan.MaxFiles = maxFiles
+    if noRecurse {
+        scan.MaxDepth = 1
+    }
+    scan.MmapThreshold = mmapThreshold
+    scan.HashSizeLimit = hashSizeLimit
+    scan.ScanArchives = scanArchives
+    scan.OneFilesystem = oneFilesystem
+    scan.ExcludeNewerThan = time.Duration(excludeNewerThan)
+    scan.ExcludeOlderThan = time.Duration(excludeOlderThan)
+    if ownedBy != "" {
+        uid, err := LookupUID(ownedBy)
+        if errors.Is(err, ErrOwnerUnsupported) {
+            fmt.Fprintf(os.Stderr, "Warning: ignoring -owned-by: %s\n", err.Error())
+        } else if err != nil {
+            fmt.Fprintf(os.Stderr, "Unknown user %s: %s\n", ownedBy, err.Error())
+            exit(1)
+        } else {
+            scan.FilterByOwner = true
+            scan.OwnerUID = uid
+        }
+    }
+    if ownedByGroup != "" {
+        gid, err := LookupGID(ownedByGroup)
+        if errors.Is(err, ErrOwnerUnsupported) {
+            fmt.Fprintf(os.Stderr, "Warning: ignoring -owned-by-group: %s\n", err.Error())
+        } else if err != nil {
+            fmt.Fprintf(os.Stderr, "Unknown group %s: %s\n", ownedByGroup, err.Error())
+            exit(1)
+        } else {
+            scan.FilterByGroup = true
+            scan.OwnerGID = gid
+        }
+    }
+    scan.ParallelWalk = parallelWalk
+    scan.WalkParallelism = walkParallelism
+    scan.ExcludeDirNames = excludeDirNames
+    for _, pattern := range includePathRegex {
+        re, err := regexp.Compile(pattern)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid include pattern %s: %s\n", pattern, err)
+            exit(1)
+        }
+        scan.IncludePathRegex = append(scan.IncludePathRegex, re)
+    }
+    for _, pattern := range excludePathRegex {
+        re, err := regexp.Compile(pattern)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid exclude pattern %s: %s\n", pattern, err)
+            exit(1)
+        }
+        scan.ExcludePathRegex = append(scan.ExcludePathRegex, re)
+    }
+    if _, found := HashRegistry[hashAlgorithm]; !found {
+        fmt.Fprintf(os.Stderr, "Unsupported hash algorithm: %s\n", hashAlgorithm)
+        exit(1)
+    }
+    scan.HashAlgorithm = hashAlgorithm
+    scan.ComputeSHA1 = computeSHA1
 
-    //Search path
-    for _, path := range flag.Args() {
+    //Search path, wildcards expanded
+    args, err := ExpandPaths(flag.Args())
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err)
+        exit(1)
+    }
+    for _, path := range args {
         //Check if path exists
         var stat os.FileInfo
         stat, err := os.Stat(path)
         if err != nil {
             fmt.Fprintf(os.Stderr, "%s\n", err)
-            os.Exit(1)
+            exit(1)
         }
 
         //Check if path is a directory
         if !stat.IsDir() {
             fmt.Fprintf(os.Stderr, "Not a directory: %s\n", path)
-            os.Exit(1)
+            exit(1)
         }
 
         //Add path to list
         scan.Paths = append(scan.Paths, path)
     }
 
+    //Warn about network drives, hardlinks can't be detected without inodes
+    for _, path := range scan.Paths {
+        if networkDriveCheck(path) {
+            fmt.Fprintf(os.Stderr,
+                "Warning: %s is a network drive, hardlinked files may be listed as duplicates\n", path)
+        }
+    }
+
+    //Additional paths from stdin (files or directories)
+    if pathsFromStdin {
+        paths, err := ReadPaths(os.Stdin, nulSeparated)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading paths from stdin: %s\n", err)
+            exit(1)
+        }
+        for _, path := range paths {
+            //Check if path exists
+            stat, err := os.Stat(path)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "%s\n", err)
+                exit(1)
+            }
+
+            //Directories are walked, files are scanned directly
+            if stat.IsDir() {
+                scan.Paths = append(scan.Paths, path)
+            } else {
+                scan.DirectFiles = append(scan.DirectFiles, path)
+            }
+        }
+    }
+
+    //Files from file list, missing files are skipped
+    if fileList != "" {
+        paths, err := ReadFileList(fileList)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading file list: %s\n", err)
+            exit(1)
+        }
+        for _, path := range paths {
+            stat, err := os.Stat(path)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Skipping file from list: %s\n", err)
+                continue
+            }
+            if stat.IsDir() {
+                fmt.Fprintf(os.Stderr, "Skipping directory from list: %s\n", path)
+                continue
+            }
+            scan.DirectFiles = append(scan.DirectFiles, path)
+        }
+    }
+
     //Search path must be defined
-    if len(scan.Paths) == 0 {
+    if len(scan.Paths) == 0 && len(scan.DirectFiles) == 0 {
         fmt.Fprintf(os.Stderr, "No search path defined\n")
-        os.Exit(1)
+        exit(1)
     }
 
     //Check for file conflict (map file)
@@ -163,7 +668,20 @@ func main() {
                 fmt.Fprintf(os.Stderr,
                     "Not exporting map file, file exists, use -file-replace to override: %s\n", mapFileExport)
                 mapFileExport = ""
-                os.Exit(1)
+                exit(1)
+            }
+        }
+    }
+    if duplicatesMapFileExport != "" {
+        //User wants to create a compact map file
+        if _, err := os.Stat(duplicatesMapFileExport); err == nil {
+            //Specified file already exists
+            if !exportFileReplace {
+                //User didn't confirm that file should be replaced
+                fmt.Fprintf(os.Stderr,
+                    "Not exporting duplicates map file, file exists, use -file-replace to override: %s\n", duplicatesMapFileExport)
+                duplicatesMapFileExport = ""
+                exit(1)
             }
         }
     }
@@ -176,45 +694,229 @@ func main() {
                 fmt.Fprintf(os.Stderr,
                     "Not exporting hash file, file exists, use -file-replace to override: %s\n", hashMD5FileExport)
                 hashMD5FileExport = ""
-                os.Exit(1)
+                exit(1)
             }
         }
     }
 
-    //Import file map
-    if mapFileImport != "" {
-        if _, err := os.Stat(mapFileImport); err != nil {
-            fmt.Fprintf(os.Stderr, "Map file not found: %s\n", mapFileImport)
-            os.Exit(1)
+    if hashSHA1FileExport != "" {
+        //User wants to export a hash file
+        if _, err := os.Stat(hashSHA1FileExport); err == nil {
+            //Specified file already exists
+            if !exportFileReplace {
+                //User didn't confirm that file should be replaced
+                fmt.Fprintf(os.Stderr,
+                    "Not exporting hash file, file exists, use -file-replace to override: %s\n", hashSHA1FileExport)
+                hashSHA1FileExport = ""
+                exit(1)
+            }
         }
-        if err := scan.ImportMap(mapFileImport); err != nil {
+    }
+
+    if hashBLAKE2bFileExport != "" {
+        //User wants to export a hash file
+        if _, err := os.Stat(hashBLAKE2bFileExport); err == nil {
+            //Specified file already exists
+            if !exportFileReplace {
+                //User didn't confirm that file should be replaced
+                fmt.Fprintf(os.Stderr,
+                    "Not exporting hash file, file exists, use -file-replace to override: %s\n", hashBLAKE2bFileExport)
+                hashBLAKE2bFileExport = ""
+                exit(1)
+            }
+        }
+    }
+
+    if csvFileExport != "" {
+        //User wants to export a CSV file
+        if _, err := os.Stat(csvFileExport); err == nil {
+            //Specified file already exists
+            if !exportFileReplace {
+                //User didn't confirm that file should be replaced
+                fmt.Fprintf(os.Stderr,
+                    "Not exporting CSV file, file exists, use -file-replace to override: %s\n", csvFileExport)
+                csvFileExport = ""
+                exit(1)
+            }
+        }
+    }
+
+    if outputFile != "" {
+        //User wants to write results to a file
+        if _, err := os.Stat(outputFile); err == nil {
+            //Specified file already exists
+            if !exportFileReplace {
+                //User didn't confirm that file should be replaced
+                fmt.Fprintf(os.Stderr,
+                    "Not writing output file, file exists, use -file-replace to override: %s\n", outputFile)
+                outputFile = ""
+                exit(1)
+            }
+        }
+    }
+
+    //Warn about export files within scan paths, don't scan them
+    for _, exportFile := range []string{mapFileExport, duplicatesMapFileExport,
+        hashMD5FileExport, hashSHA1FileExport, hashBLAKE2bFileExport, csvFileExport, outputFile,
+        writeGroupsToDir} {
+        if exportFile == "" {
+            continue
+        }
+        for _, path := range scan.Paths {
+            if PathContains(path, exportFile) {
+                fmt.Fprintf(os.Stderr,
+                    "Warning: export file is inside scan path %s, excluding it from scan: %s\n",
+                    path, exportFile)
+                scan.ExcludePaths = append(scan.ExcludePaths, resolvePath(exportFile))
+                break
+            }
+        }
+    }
+
+    //PID file, prevents concurrent runs
+    if pidFile != "" {
+        if err := CreatePIDFile(pidFile); err != nil {
+            fmt.Fprintf(os.Stderr, "Error creating PID file: %s\n", err.Error())
+            exit(1)
+        }
+        atExit = append(atExit, func() { os.Remove(pidFile) })
+    }
+
+    //Output file
+    if outputFile != "" {
+        f, err := os.Create(outputFile)
+        if err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error creating output file: %s\n", err.Error())
+            exit(1)
+        }
+        defer f.Close()
+        outputIO = f
+    }
+
+    //Import file maps, in order
+    //A file found in more than one map is taken from the last one
+    for _, file := range mapFileImport {
+        if _, err := os.Stat(file); err != nil {
+            fmt.Fprintf(os.Stderr, "Map file not found: %s\n", file)
+            exit(1)
+        }
+        if err := scan.ImportMap(file); err != nil {
             fmt.Fprintf(os.Stderr,
                 "Error importing map: %s\n", err.Error())
-            os.Exit(1)
+            exit(1)
         }
+    }
+    if len(mapFileImport) > 0 {
         fmt.Fprintf(os.Stderr, "Imported files: %d\n", len(scan.Files))
     }
+    for _, file := range seedSumFiles {
+        if err := scan.SeedFromSumFile(file); err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading sum file: %s\n", err.Error())
+            exit(1)
+        }
+    }
+    if rebaseImportedPaths != "" {
+        count, err := scan.FixImportedPaths(rebaseImportedPaths)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error rebasing imported files: %s\n", err.Error())
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Rebased imported files: %d\n", count)
+    }
 
     //Start scan
     if (skipScan) {
-        fmt.Println("Skipping scan")
+        fmt.Fprintln(outputIO, "Skipping scan")
     } else {
+        //Stop scan on interrupt, keep files scanned so far
+        //Stop scan after timeout as well, if set
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+        if scanTimeout > 0 {
+            var cancel context.CancelFunc
+            ctx, cancel = context.WithTimeout(ctx, scanTimeout)
+            defer cancel()
+        }
         wait.Add(1)
         fmt.Fprintf(os.Stderr, "Scanning...\n")
         fmt.Fprintf(os.Stderr, "\n")
-        scan.Scan(&wait)
+        scan.ScanContext(ctx, &wait)
+        progressDone := make(chan struct{})
+        var progress ScanProgress
+        go func() {
+            defer close(progressDone)
+            progress = printProgress(os.Stderr, scan.Progress, verboseMode, isTerminal(os.Stderr))
+        }()
         wait.Wait()
+        <-progressDone
+        if ctx.Err() == context.DeadlineExceeded {
+            fmt.Fprintf(os.Stderr, "Scan timeout after %s; processed %d/%d files\n",
+                scanTimeout, progress.ScannedCount, progress.TotalCount)
+        } else if ctx.Err() != nil {
+            fmt.Fprintf(os.Stderr, "Scan interrupted, results are incomplete\n")
+        }
+        stop()
+        for _, scanError := range scan.ScanErrors {
+            if errors.Is(scanError.Err, ErrZeroMtime) || errors.Is(scanError.Err, ErrModifiedDuringScan) {
+                //File has been scanned, warning only
+                fmt.Fprintf(os.Stderr, "Warning: %s: %s\n",
+                    scanError.Path, scanError.Err)
+                continue
+            }
+            fmt.Fprintf(os.Stderr, "Skipped %s: %s\n",
+                scanError.Path, scanError.Err)
+        }
+
+        //Paths that could not be accessed, listed in verbose mode
+        if len(scan.AccessErrors) > 0 {
+            fmt.Fprintf(os.Stderr, "Could not access %d paths\n", len(scan.AccessErrors))
+            var permissionDenied bool
+            for _, accessError := range scan.AccessErrors {
+                fmt.Fprintf(verboseIO, "Could not access %s: %s\n",
+                    accessError.Path, accessError.Err)
+                if errors.Is(accessError.Err, fs.ErrPermission) {
+                    permissionDenied = true
+                }
+            }
+            if failOnPermissionDenied && permissionDenied {
+                fmt.Fprintf(os.Stderr, "Permission denied, aborting\n")
+                exit(1)
+            }
+        }
+    }
+
+    //Files compared by partial hash might not be identical
+    if partialGroups := scan.PartialHashGroups(); partialGroups > 0 {
+        fmt.Fprintf(os.Stderr, "Warning: %d duplicate groups contain files with partial hashes (-size-limit-per-file), verify them manually\n", partialGroups)
+    }
+
+    //Check file map
+    if validateMap {
+        problems := scan.ValidateMap()
+        for _, problem := range problems {
+            fmt.Fprintf(os.Stderr, "Invalid map entry: %s\n", problem)
+        }
+        fmt.Fprintf(os.Stderr, "Map validation: %d problems found\n", len(problems))
     }
 
     //Export file map
-    if exportFileReplace && mapFileExport == "" {
-        mapFileExport = mapFileImport
+    if exportFileReplace && mapFileExport == "" && len(mapFileImport) == 1 {
+        mapFileExport = mapFileImport[0]
     }
     if mapFileExport != "" {
         if err := scan.ExportMap(mapFileExport); err != nil {
             fmt.Fprintf(os.Stderr,
                 "Error exporting map: %s\n", err.Error())
-            os.Exit(1)
+            exit(1)
+        }
+    }
+
+    //Export compact map (duplicates only)
+    if duplicatesMapFileExport != "" {
+        if err := scan.ExportDuplicatesMap(duplicatesMapFileExport); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error exporting duplicates map: %s\n", err.Error())
+            exit(1)
         }
     }
 
@@ -223,101 +925,355 @@ func main() {
         if err := scan.ExportMD5(hashMD5FileExport); err != nil {
             fmt.Fprintf(os.Stderr,
                 "Error exporting hash file: %s\n", err.Error())
-            os.Exit(1)
+            exit(1)
         }
     }
 
-    //List duplicate groups
-    duplicatesMap := scan.DuplicatesMap()
-    if listDuplicateGroups {
-        for _, files := range duplicatesMap {
-            for _, file := range files {
-                fmt.Printf("%s\n", filePath(file))
+    if hashSHA1FileExport != "" {
+        if err := scan.ExportSHA1(hashSHA1FileExport); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error exporting hash file: %s\n", err.Error())
+            exit(1)
+        }
+    }
+
+    if hashBLAKE2bFileExport != "" {
+        if err := scan.ExportBLAKE2b(hashBLAKE2bFileExport); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error exporting hash file: %s\n", err.Error())
+            exit(1)
+        }
+    }
+
+    //Export CSV file
+    if csvFileExport != "" {
+        if err := scan.ExportCSV(csvFileExport); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error exporting CSV file: %s\n", err.Error())
+            exit(1)
+        }
+    }
+
+    //List duplicate groups (only the top N groups by wasted space, if set)
+    //Summary includes all groups
+    groups := scan.DuplicateGroups()
+    if includeHardlinks {
+        groups = scan.DuplicateGroupsWithHardlinks()
+    }
+    if topN > 0 {
+        groups = TopDuplicateGroups(groups, topN)
+    }
+    groups, _ = SortDuplicateGroups(groups, sortGroupsBy, sortGroupsReversed)
+    if reporter != nil {
+        //Written with the summary below, some formats are a single document
+    } else if fdupesOutput {
+        if err := ExportForFdupes(outputIO, groups, filePath); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing fdupes output: %s\n", err.Error())
+            exit(1)
+        }
+    } else if compactOutput {
+        PrintCompact(outputIO, groups, filePath, nullSeparated)
+    } else if listHashesOnly {
+        PrintDuplicateHashes(outputIO, groups)
+    } else if listFirstOnly {
+        scan.PrintKeptFiles(outputIO, groups, filePath)
+    } else if listDuplicateGroups {
+        //Path with modification time and size, if requested
+        listedPath := func(file *File) string {
+            path := filePath(file)
+            if showMtime {
+                path += " [" + time.Unix(file.ModificationTime, 0).Format(timeFormat) + "]"
+            }
+            if showSize {
+                path += " [" + humanize.IBytes(uint64(file.Size)) + "]"
             }
-            fmt.Printf("\n")
+            return path
         }
+        PrintDuplicateGroups(outputIO, groups, listedPath, showGroupNumber, showFileNumber)
+    }
+
+    //List unique files
+    if listNonDuplicates {
+        for _, file := range scan.UniqueFiles() {
+            fmt.Fprintf(outputIO, "%s\n", filePath(file))
+        }
+        fmt.Fprintf(outputIO, "\n")
+    }
+
+    //List duplicate directories
+    if listDuplicateDirs {
+        PrintDuplicateDirs(outputIO, scan.DuplicateDirs())
+    }
+
+    //Duplicates by file extension
+    if listByExtension {
+        PrintExtensionTable(outputIO, scan.DuplicatesByExtension())
+        fmt.Fprintf(outputIO, "\n")
+    }
+
+    //Biggest space hogs
+    if topWasted > 0 {
+        PrintTopWasted(outputIO, scan.TopWastedSpace(topWasted), filePath)
+        fmt.Fprintf(outputIO, "\n")
+    }
+
+    //Biggest files that aren't duplicates
+    if listLargeUniqueFiles > 0 {
+        for _, file := range scan.LargestUniqueFiles(listLargeUniqueFiles) {
+            fmt.Fprintf(outputIO, "%s\t%s\n", humanize.IBytes(uint64(file.Size)), filePath(file))
+        }
+        fmt.Fprintf(outputIO, "\n")
+    }
+
+    //Duplicates by file size
+    if listSizeDistribution {
+        PrintSizeDistribution(outputIO, scan.GroupsBySize())
+        fmt.Fprintf(outputIO, "\n")
+    }
+
+    //List files with the same name
+    if listPathDuplicates {
+        PrintSameNameFiles(outputIO, scan.SameNameFiles(), filePath)
+    }
+
+    //List duplicate chains
+    if listChains {
+        for _, chain := range scan.FindChains() {
+            for _, path := range chain {
+                fmt.Fprintf(outputIO, "%s\n", path)
+            }
+            fmt.Fprintf(outputIO, "\n")
+        }
+    }
+
+    //List directories without duplicates
+    if listCleanDirs {
+        for _, dir := range scan.UniqueDirectories() {
+            fmt.Fprintf(outputIO, "%s\n", dir)
+        }
+        fmt.Fprintf(outputIO, "\n")
+    }
+
+    //Fingerprint
+    if printFingerprint {
+        fmt.Fprintf(outputIO, "%s\n", scan.Fingerprint())
+    }
+
+    //Hash distribution
+    if bucketAnalysis > 0 {
+        PrintBucketHistogram(outputIO, scan.BucketAnalysis(bucketAnalysis))
+        fmt.Fprintf(outputIO, "\n")
     }
 
     //Show summary
-    if showSummary {
-        totalFileCount := len(scan.Files)
-        totalFilesSize := uint64(scan.TotalFilesSize())
-        groupCount := len(duplicatesMap)
-        duplicatesSize := uint64(scan.DuplicatesSize())
-        var duplicateCount int
-        duplicateCount = len(scan.AdditionalFiles())
-        fmt.Printf("Files:\t\t\t%d\n", totalFileCount)
-        fmt.Printf("Total size:\t\t%s (%d B)\n",
-            humanize.IBytes(totalFilesSize), totalFilesSize)
-        fmt.Printf("Duplicate groups:\t%d\n", groupCount)
-        fmt.Printf("Duplicate count:\t%d\n", duplicateCount)
-        fmt.Printf("Size of duplicates:\t%s (%d B)\n",
-            humanize.IBytes(duplicatesSize), duplicatesSize)
-        fmt.Printf("\n")
+    if reporter != nil {
+        var stats *SummaryStats
+        if showSummary {
+            summaryStats := scan.SummaryStats()
+            stats = &summaryStats
+        }
+        reportGroups := groups
+        if !listDuplicateGroups {
+            reportGroups = nil
+        }
+        WriteReport(outputIO, reporter, reportGroups, stats)
+    } else if showSummary {
+        summary := scan.Summary()
+        if showExtBreakdown {
+            summary += fmt.Sprintf("Wasted by extension:\t%s\n",
+                FormatWastedByExtension(scan.WastedByExtension(), 10))
+        }
+        fmt.Fprintf(outputIO, "%s\n", summary)
+    }
+
+    //Review directory
+    if writeGroupsToDir != "" {
+        if err := WriteGroupsToDir(writeGroupsToDir, groups, !noHardlink, actionPath); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error writing duplicate groups to directory: %s\n", err.Error())
+            exit(1)
+        }
     }
 
     //Action
     if deleteDuplicates {
-        //Delete duplicates (keep first one per group)
-
-        for _, files := range duplicatesMap {
-            duplicates := files[1:] //except first one
-            for _, file := range duplicates {
-                path := filePath(file)
-                err := os.Remove(path)
-                if err != nil {
-                    fmt.Fprintf(os.Stderr,
-                        "Error deleting file %s: %s\n", path, err.Error())
-                    continue
-                }
-                fmt.Printf("Deleted %s\n", path)
-            }
+        report := scan.DeleteDuplicates(actionPath)
+        fmt.Fprintf(outputIO, "\n")
+        fmt.Fprintf(outputIO, "Freed: %s (%d B, %d files deleted)\n",
+            humanize.IBytes(uint64(report.Freed)), report.Freed, report.Deleted)
+        if report.Failed > 0 {
+            fmt.Fprintf(outputIO, "Failed to delete %d files\n", report.Failed)
         }
     } else if linkDuplicates {
-        //Replace duplicates with links
-        //We assume they're all on the same filesystem
-
-        for _, files := range duplicatesMap {
-            firstFile := files[0]
-            duplicates := files[1:] //except first one
-            for _, file := range duplicates {
-                //Create hardlink in destination directory
-                //Replace duplicate only if hardlink created successfully
-                duplicateFilePath := filePath(file)
-                firstFilePath := filePath(firstFile)
-                dir := filepath.Dir(duplicateFilePath) //hardlink directory
-                prefix := "DUPE"
-                f, err := ioutil.TempFile(dir, prefix)
-                if err != nil {
-                    fmt.Fprintf(os.Stderr,
-                        "Error writing to directory %s: %s\n",
-                        dir, err.Error())
-                    continue
-                }
-                tmpFilePath := f.Name()
-                f.Close()
-                os.Remove(tmpFilePath)
-
-                //Create hardlink using temporary (new) file
-                //Fails if duplicate is on another filesystem
-                if err := os.Link(firstFilePath, tmpFilePath); err != nil {
-                    fmt.Fprintf(os.Stderr,
-                        "Error creating link: %s\n",
-                        err.Error())
-                    continue
-                }
+        report := scan.LinkDuplicates(actionPath)
+        fmt.Fprintf(outputIO, "\n")
+        fmt.Fprintf(outputIO, "Saved: %s (%d B) by creating %d hardlinks\n",
+            humanize.IBytes(uint64(report.Saved)), report.Saved, report.Linked)
+        if report.Failed > 0 {
+            fmt.Fprintf(outputIO, "Failed to link %d files\n", report.Failed)
+        }
+    } else if symlinkDuplicates {
+        report := scan.SymlinkDuplicates(actionPath)
+        fmt.Fprintf(outputIO, "\n")
+        fmt.Fprintf(outputIO, "Saved: %s (%d B) by creating %d symlinks\n",
+            humanize.IBytes(uint64(report.Saved)), report.Saved, report.Linked)
+        if report.Failed > 0 {
+            fmt.Fprintf(outputIO, "Failed to link %d files\n", report.Failed)
+        }
+    } else if renameDuplicates != "" {
+        if err := scan.RenameDuplicates(renameDuplicates, renameDryRun, actionPath); err != nil {
+            fmt.Fprintf(os.Stderr, "%s\n", err)
+            exit(1)
+        }
+    }
 
-                //Replace duplicate with link
-                if err := os.Rename(tmpFilePath, duplicateFilePath); err != nil {
-                    fmt.Fprintf(os.Stderr,
-                        "Error replacing file %s with link: %s\n",
-                        duplicateFilePath, err.Error())
-                    continue
-                }
-                fmt.Printf("Replaced %s\n", duplicateFilePath)
-            }
+    //Serve results until interrupted
+    if serveAddr != "" {
+        server := NewServer(scan, actionPath)
+        server.Auth = serveAuth
+        server.AllowDelete = serveAllowDelete
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+        fmt.Fprintf(os.Stderr, "Serving results on %s\n", serveAddr)
+        err := server.ListenAndServe(ctx, serveAddr)
+        stop()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error running server: %s\n", err.Error())
+            exit(1)
+        }
+    }
+
+    exit(0)
+}
+
+//Cleanup functions (like removing the PID file), called by exit()
+var atExit []func()
+
+func exit(code int) {
+    //Run cleanup first, os.Exit() does not run deferred functions
+    for i := len(atExit) - 1; i >= 0; i-- {
+        atExit[i]()
+    }
+    os.Exit(code)
+}
+
+
+func printProgress(w io.Writer, progress <-chan ScanProgress, quiet bool, terminal bool) ScanProgress {
+    //Print progress, at most every 200ms, return final state
+    //Line is overwritten on a terminal, only final state is printed otherwise (log file)
+    var last time.Time
+    var p ScanProgress
+    var printed bool
+    rate := NewRollingRate(10 * time.Second) //hashing speed, for ETA
+    var lastBytesHashed int64
+    var lineLength int
+    for p = range progress {
+        if quiet {
+            continue
         }
+        printed = true
+        if !terminal || time.Since(last) < 200 * time.Millisecond {
+            continue
+        }
+        last = time.Now()
+        rate.Add(p.BytesHashed - lastBytesHashed)
+        lastBytesHashed = p.BytesHashed
+
+        //ETA, assuming remaining files must be hashed
+        var eta string
+        if remaining := p.TotalBytes - p.ScannedBytes; remaining > 0 && rate.Rate() > 0 {
+            seconds := float64(remaining) / rate.Rate()
+            eta = ", ETA " + formatETA(time.Duration(seconds * float64(time.Second)))
+        }
+        line := fmt.Sprintf("Scanned %d/%d files, %s hashed%s",
+            p.ScannedCount, p.TotalCount, humanize.IBytes(uint64(p.BytesHashed)), eta)
+        fmt.Fprintf(w, "\r%-*s", lineLength, line) //overwrite longer line
+        lineLength = len(line)
     }
+    if printed {
+        //Final state
+        line := fmt.Sprintf("Scanned %d/%d files, %s hashed",
+            p.ScannedCount, p.TotalCount, humanize.IBytes(uint64(p.BytesHashed)))
+        if terminal {
+            fmt.Fprintf(w, "\r%-*s\n", lineLength, line)
+        } else {
+            fmt.Fprintf(w, "%s\n", line)
+        }
+    }
+    return p
+}
+
+func isTerminal(f *os.File) bool {
+    //Character device (console), not a file or pipe
+    fi, err := f.Stat()
+    return err == nil && fi.Mode() & os.ModeCharDevice != 0
+}
 
+//Boolean flag selecting a sort order
+type sortFlag struct {
+    order *SortMode
+    mode SortMode
 }
 
+func (f *sortFlag) String() string {
+    if f.order == nil {
+        return "false"
+    }
+    return strconv.FormatBool(*f.order == f.mode)
+}
+
+func (f *sortFlag) Set(value string) error {
+    enabled, err := strconv.ParseBool(value)
+    if err != nil {
+        return err
+    }
+    if enabled {
+        *f.order = f.mode
+    }
+    return nil
+}
+
+func (f *sortFlag) IsBoolFlag() bool {
+    return true
+}
+
+//Duration flag, also accepts days (like 7d)
+type ageFlag time.Duration
+
+func (f *ageFlag) String() string {
+    if f == nil {
+        return "0s"
+    }
+    return time.Duration(*f).String()
+}
+
+func (f *ageFlag) Set(value string) error {
+    if days, found := strings.CutSuffix(value, "d"); found {
+        n, err := strconv.ParseFloat(days, 64)
+        if err != nil {
+            return err
+        }
+        *f = ageFlag(time.Duration(n * float64(24 * time.Hour)))
+        return nil
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil {
+        return err
+    }
+    *f = ageFlag(d)
+    return nil
+}
+
+//String flag that may be specified more than once
+type stringList []string
+
+func (l *stringList) String() string {
+    if l == nil {
+        return ""
+    }
+    return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+    *l = append(*l, value)
+    return nil
+}
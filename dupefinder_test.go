@@ -0,0 +1,207 @@
+package main
+
+import (
+    "os"
+    "bytes"
+    "os/exec"
+    "strings"
+    "time"
+    "testing"
+    "path/filepath"
+)
+
+func runMain(t *testing.T, args ...string) (string, string) {
+    //Run dupefinder with arguments, return stdout and stderr
+    cmd := exec.Command(os.Args[0], args...)
+    cmd.Env = append(os.Environ(), "DUPEFINDER_TEST_MAIN=1")
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+    if err := cmd.Run(); err != nil {
+        t.Fatalf("dupefinder %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+    }
+    return stdout.String(), stderr.String()
+}
+
+func TestStatsOnly(t *testing.T) {
+    //Summary only, no paths listed and no review directory
+    dir := t.TempDir()
+    searchPath := filepath.Join(dir, "files")
+    reviewDir := filepath.Join(dir, "review")
+    writeTestFiles(t, searchPath, map[string]string{"a": "same", "b": "same", "unique": "unique"})
+    stdout, _ := runMain(t, "-stats-only", "-list-duplicate-groups", "-compact-output",
+        "-list-non-duplicate-groups", "-list-path-duplicates", "-top-wasted", "5",
+        "-list-large-unique-files", "5", "-write-groups-to-dir", reviewDir, searchPath)
+    if strings.Contains(stdout, searchPath) {
+        t.Errorf("Paths listed:\n%s", stdout)
+    }
+    if !strings.Contains(stdout, "Duplicate groups:") {
+        t.Errorf("Summary missing:\n%s", stdout)
+    }
+    if _, err := os.Stat(reviewDir); err == nil {
+        t.Errorf("Review directory created")
+    }
+}
+
+func TestListOneLinePerGroup(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a1": "a", "a2": "a", "b1": "b", "b2": "b", "b3": "b", "c1": "c", "c2": "c", "unique": "unique",
+    })
+    scan := scanTestDir(t, dir)
+    groups, err := SortDuplicateGroups(scan.DuplicateGroups(), "path", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        flag string
+        line func(group DuplicateGroup) string
+    }{
+        {"-list-hashes-only", func(group DuplicateGroup) string { return group.Hash }},
+        {"-list-first-only", func(group DuplicateGroup) string { return group.Files[0].Path }},
+    }
+    for _, test := range tests {
+        t.Run(test.flag, func(t *testing.T) {
+            stdout, _ := runMain(t, test.flag, "-show-summary=false", dir)
+            lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+            if len(lines) != len(groups) {
+                t.Fatalf("%d lines, expected %d:\n%s", len(lines), len(groups), stdout)
+            }
+            for i, group := range groups {
+                if lines[i] != test.line(group) {
+                    t.Errorf("Line %d: %s, expected %s", i + 1, lines[i], test.line(group))
+                }
+            }
+        })
+    }
+}
+
+func TestPrintProgress(t *testing.T) {
+    //Line overwritten on a terminal, only the final state otherwise (log file)
+    tests := []struct {
+        name string
+        quiet bool
+        terminal bool
+        expected string
+    }{
+        {"terminal", false, true, "\rScanned 1/2 files, 0 B hashed\rScanned 2/2 files, 4 B hashed\n"},
+        {"not a terminal", false, false, "Scanned 2/2 files, 4 B hashed\n"},
+        {"quiet", true, true, ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            progress := make(chan ScanProgress, 2)
+            progress <- ScanProgress{ScannedCount: 1, TotalCount: 2}
+            progress <- ScanProgress{ScannedCount: 2, TotalCount: 2, BytesHashed: 4}
+            close(progress)
+            var buf bytes.Buffer
+            p := printProgress(&buf, progress, test.quiet, test.terminal)
+            if p.ScannedCount != 2 {
+                t.Errorf("Final state %+v, expected 2 files scanned", p)
+            }
+            if buf.String() != test.expected {
+                t.Errorf("Output %q, expected %q", buf.String(), test.expected)
+            }
+        })
+    }
+}
+
+func TestOutputFile(t *testing.T) {
+    //Results written to file, nothing on stdout
+    dir := t.TempDir()
+    searchPath := filepath.Join(dir, "files")
+    outputFile := filepath.Join(dir, "output.txt")
+    writeTestFiles(t, searchPath, map[string]string{"a": "same", "b": "same", "unique": "unique"})
+    stdout, _ := runMain(t, "-list-duplicate-groups", "-output-file", outputFile, searchPath)
+    if stdout != "" {
+        t.Errorf("Output on stdout:\n%s", stdout)
+    }
+    data, err := os.ReadFile(outputFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    output := string(data)
+    for _, expected := range []string{filepath.Join(searchPath, "a"), filepath.Join(searchPath, "b"), "Duplicate groups:"} {
+        if !strings.Contains(output, expected) {
+            t.Errorf("Output file missing %q:\n%s", expected, output)
+        }
+    }
+    if strings.Contains(output, filepath.Join(searchPath, "unique")) {
+        t.Errorf("Unique file listed:\n%s", output)
+    }
+}
+
+func TestNoRecurse(t *testing.T) {
+    //Only files directly in the search path
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "sub/c": "same"})
+    stdout, _ := runMain(t, "-no-recurse", "-list-duplicate-groups", dir)
+    for _, name := range []string{"a", "b"} {
+        if !strings.Contains(stdout, filepath.Join(dir, name)) {
+            t.Errorf("File %s not listed:\n%s", name, stdout)
+        }
+    }
+    if strings.Contains(stdout, filepath.Join(dir, "sub")) {
+        t.Errorf("Subdirectory scanned:\n%s", stdout)
+    }
+}
+
+func TestFileList(t *testing.T) {
+    //Existing files from list scanned with directory arguments, missing files skipped
+    dir := t.TempDir()
+    listed, other := filepath.Join(dir, "listed"), filepath.Join(dir, "other")
+    writeTestFiles(t, listed, map[string]string{"a": "same", "b": "same", "unlisted": "same"})
+    writeTestFiles(t, other, map[string]string{"c": "same"})
+    missing := filepath.Join(listed, "missing")
+    list := filepath.Join(dir, "list.txt")
+    writeTestFiles(t, dir, map[string]string{
+        "list.txt": filepath.Join(listed, "a") + " \n" + missing + "\n" + filepath.Join(listed, "b") + "\n",
+    })
+    stdout, stderr := runMain(t, "-file-list", list, "-list-duplicate-groups", other)
+    for _, path := range []string{filepath.Join(listed, "a"), filepath.Join(listed, "b"), filepath.Join(other, "c")} {
+        if !strings.Contains(stdout, path) {
+            t.Errorf("File %s not listed:\n%s", path, stdout)
+        }
+    }
+    if strings.Contains(stdout, "unlisted") || strings.Contains(stdout, missing) {
+        t.Errorf("Unexpected file listed:\n%s", stdout)
+    }
+    if !strings.Contains(stderr, missing) {
+        t.Errorf("Missing file not reported:\n%s", stderr)
+    }
+}
+
+func TestShowMtimeAndSize(t *testing.T) {
+    //Modification time in the given layout and size appended to the path
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same"})
+    mtime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.Local)
+    for _, name := range []string{"a", "b"} {
+        if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+            t.Fatal(err)
+        }
+    }
+    path := filepath.Join(dir, "a")
+    tests := []struct {
+        name string
+        args []string
+        line string
+    }{
+        {"path only", nil, path},
+        {"mtime", []string{"-show-mtime"}, path + " [2024-01-15 10:30:00]"},
+        {"time format", []string{"-show-mtime", "-time-format", "02.01.2006 15h04"}, path + " [15.01.2024 10h30]"},
+        {"size", []string{"-show-size"}, path + " [4 B]"},
+        {"mtime and size", []string{"-show-mtime", "-show-size"}, path + " [2024-01-15 10:30:00] [4 B]"},
+        {"time format without mtime", []string{"-time-format", "2006"}, path},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            args := append(test.args, "-list-duplicate-groups", "-show-summary=false", dir)
+            stdout, _ := runMain(t, args...)
+            lines := strings.Split(stdout, "\n")
+            if len(lines) < 2 || lines[0] != test.line {
+                t.Errorf("Listing:\n%s\nexpected first line %q", stdout, test.line)
+            }
+        })
+    }
+}
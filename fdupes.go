@@ -0,0 +1,58 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "bufio"
+    "strconv"
+)
+
+func ExportForFdupes(w io.Writer, groups []DuplicateGroup, filePath func(*File) string) error {
+    //Format of fdupes: one file per line, groups separated by an empty line
+    //No hash, groups in given order (as listed)
+    for _, group := range groups {
+        for _, file := range group.Files {
+            if _, err := fmt.Fprintf(w, "%s\n", filePath(file)); err != nil {
+                return err
+            }
+        }
+        if _, err := fmt.Fprintf(w, "\n"); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (scan *Scan) ImportFdupes(r io.Reader) (map[string]FileList, error) {
+    //Parse fdupes output, groups are numbered (1, 2, ...) because there's no hash
+    //Files already in the scan are reused, others only have a path
+    groups := make(map[string]FileList)
+    var group FileList
+    addGroup := func() {
+        if len(group) > 0 {
+            groups[strconv.Itoa(len(groups) + 1)] = group
+            group = nil
+        }
+    }
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+    for scanner.Scan() {
+        path := scanner.Text()
+        if path == "" {
+            addGroup()
+            continue
+        }
+        file, found := scan.Files[path]
+        if !found {
+            file = &File{Path: path}
+        }
+        group = append(group, file)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    addGroup() //last group, if not terminated by empty line
+
+    return groups, nil
+}
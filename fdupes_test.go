@@ -0,0 +1,54 @@
+package main
+
+import (
+    "fmt"
+    "bytes"
+    "reflect"
+    "testing"
+    "path/filepath"
+)
+
+func TestExportForFdupes(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a": "same", "sub/b": "same", "c": "other", "d": "other", "e": "other", "unique": "unique",
+    })
+    scan := scanTestDir(t, dir)
+    groups, err := SortDuplicateGroups(scan.DuplicateGroups(), "path", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    relativePath := func(file *File) string {
+        path, _ := filepath.Rel(dir, file.Path)
+        return filepath.ToSlash(path)
+    }
+
+    //Groups as listed, one path per line, empty line after each group
+    var buf bytes.Buffer
+    if err := ExportForFdupes(&buf, groups, relativePath); err != nil {
+        t.Fatal(err)
+    }
+    expected := "a\nsub/b\n\nc\nd\ne\n\n"
+    if buf.String() != expected {
+        t.Errorf("Output:\n%q\nExpected:\n%q", buf.String(), expected)
+    }
+
+    //Imported groups contain the scanned files
+    buf.Reset()
+    if err := ExportForFdupes(&buf, groups, func(file *File) string { return file.Path }); err != nil {
+        t.Fatal(err)
+    }
+    imported, err := scan.ImportFdupes(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(imported) != len(groups) {
+        t.Fatalf("%d groups imported, expected %d", len(imported), len(groups))
+    }
+    for i, group := range groups {
+        files := imported[fmt.Sprint(i + 1)]
+        if !reflect.DeepEqual(files, group.Files) {
+            t.Errorf("Group %d imported as %v, expected %v", i + 1, files, group.Files)
+        }
+    }
+}
@@ -3,27 +3,108 @@ package main
 import (
     "os"
     "io"
+    "io/fs"
+    "fmt"
+    "bytes"
+    "errors"
+    "hash"
+    "strings"
+    "sort"
+    "runtime/debug"
+    "path/filepath"
     "encoding/hex"
+    "encoding/json"
     "crypto/md5"
-    _ "crypto/sha1"
 )
 
+var ErrFileLocked = errors.New("File locked by another process")
+var ErrHashPanic = errors.New("Panic while hashing file")
+var ErrModifiedDuringScan = errors.New("File modified while it was hashed")
+var ErrSizeMismatch = errors.New("Size differs from other files with the same hash")
+var ErrZeroMtime = errors.New("Modification time is zero (1970-01-01), file will always be hashed")
+
 type File struct {
-    Path string
-    FullPath string
-    Name string
-    Size int64
-    ModificationTime int64
-    MD5 string
-    SHA1 string
-    Inum uint64
+    Path string `json:"path"`
+    FullPath string `json:"full_path"`
+    Name string `json:"name"`
+    Size int64 `json:"size"`
+    ModificationTime int64 `json:"mtime"`
+    CreationTime int64 `json:"creation_time,omitempty"` //birth time (macOS/BSD), not the Unix ctime
+    MD5 string `json:"md5,omitempty"`
+    SHA1 string `json:"sha1,omitempty"`
+    SHA256 string `json:"sha256,omitempty"`
+    BLAKE2b string `json:"blake2b,omitempty"`
+    BLAKE3 string `json:"blake3,omitempty"`
+    Hashes map[string]string `json:"hashes,omitempty"` //other algorithms (HashRegistry)
+    SampleHash string `json:"sample_hash,omitempty"`
+    Inum uint64 `json:"inum,omitempty"`
+    ArchivePath string `json:"archive_path,omitempty"` //archive containing this file, if any
+    RelativePath string `json:"relative_path,omitempty"` //path relative to search path
+    ScanError string `json:"scan_error,omitempty"` //file could not be hashed
+    ModifiedDuringScan bool `json:"modified_during_scan,omitempty"` //hash may not match size
+    HashSizeLimit bool `json:"hash_size_limit,omitempty"` //partial hash (beginning and end) only
+    fsys fs.FS //virtual filesystem, nil for regular files
+}
+
+func (file *File) UnmarshalJSON(data []byte) error {
+    //Current format (snake_case keys)
+    type fileFields File //without this method
+    if err := json.Unmarshal(data, (*fileFields)(file)); err != nil {
+        return err
+    }
+
+    //Old format (field names), keys that differ from the current ones
+    //Other keys match anyway (case-insensitive)
+    var legacy struct {
+        FullPath string
+        ModificationTime int64
+        CreationTime int64
+        Ctime int64 `json:"ctime"` //creation time, renamed to avoid confusion with the change time
+        SampleHash string
+        ArchivePath string
+    }
+    if err := json.Unmarshal(data, &legacy); err != nil {
+        return err
+    }
+    if file.FullPath == "" {
+        file.FullPath = legacy.FullPath
+    }
+    if file.ModificationTime == 0 {
+        file.ModificationTime = legacy.ModificationTime
+    }
+    if file.CreationTime == 0 {
+        file.CreationTime = legacy.CreationTime
+    }
+    if file.CreationTime == 0 {
+        file.CreationTime = legacy.Ctime
+    }
+    if file.SampleHash == "" {
+        file.SampleHash = legacy.SampleHash
+    }
+    if file.ArchivePath == "" {
+        file.ArchivePath = legacy.ArchivePath
+    }
+
+    return nil
 }
 
 type FileList []*File
 
+type SortMode int
+
+const (
+    SortByPath SortMode = iota
+    SortByName
+    SortBySize
+    SortByTime
+    SortByInode
+    SortByPathLength
+    SortByCreationTime
+)
+
 type Files struct {
     Files FileList
-    sort int
+    sort SortMode
     reverse bool
 }
 
@@ -37,14 +118,20 @@ func (f Files) Swap(i, j int) {
 
 func (f Files) Less(i, j int) bool {
     var l bool
-    if f.sort == 0 {
+    if f.sort == SortByPath {
         l = f.Files[i].Path < f.Files[j].Path
-    } else if f.sort == 1 {
+    } else if f.sort == SortByName {
         l = f.Files[i].Name < f.Files[j].Name
-    } else if f.sort == 2 {
+    } else if f.sort == SortBySize {
         l = f.Files[i].Size < f.Files[j].Size
-    } else if f.sort == 3 {
+    } else if f.sort == SortByTime {
         l = f.Files[i].ModificationTime > f.Files[j].ModificationTime
+    } else if f.sort == SortByInode {
+        l = f.Files[i].Inum < f.Files[j].Inum
+    } else if f.sort == SortByPathLength {
+        l = len(f.Files[i].Path) < len(f.Files[j].Path)
+    } else if f.sort == SortByCreationTime {
+        l = f.Files[i].CreationTime > f.Files[j].CreationTime
     }
     if f.reverse {
         l = !l
@@ -54,43 +141,532 @@ func (f Files) Less(i, j int) bool {
 
 type FileMap map[string]*File
 
+func (fm FileMap) Len() int {
+    return len(fm)
+}
+
+func (fm FileMap) Keys() []string {
+    keys := make([]string, 0, len(fm))
+    for key := range fm {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func (fm FileMap) Values() FileList {
+    //Files in key order
+    values := make(FileList, 0, len(fm))
+    for _, key := range fm.Keys() {
+        values = append(values, fm[key])
+    }
+    return values
+}
+
+func (fm FileMap) FilterBySize(min, max int64) FileMap {
+    //Files within size range, no upper limit if max is 0
+    filtered := make(FileMap)
+    for key, file := range fm {
+        if file.Size < min || (max > 0 && file.Size > max) {
+            continue
+        }
+        filtered[key] = file
+    }
+    return filtered
+}
+
+func (fm FileMap) FilterByExtension(exts []string) FileMap {
+    //Files with one of the given extensions (case-insensitive)
+    //Extensions may be specified with or without leading dot
+    extMap := make(map[string]bool)
+    for _, ext := range exts {
+        ext = strings.ToLower(ext)
+        if ext != "" && !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        extMap[ext] = true
+    }
+    filtered := make(FileMap)
+    for key, file := range fm {
+        if extMap[strings.ToLower(filepath.Ext(file.Name))] {
+            filtered[key] = file
+        }
+    }
+    return filtered
+}
+
+func (file *File) open() (fs.File, error) {
+    //Open file, in virtual filesystem if any
+    if file.fsys != nil {
+        name := file.Path
+        if file.ArchivePath != "" {
+            name = strings.TrimPrefix(name, file.ArchivePath + archiveSeparator)
+        }
+        return file.fsys.Open(name)
+    }
+    return os.Open(file.Path)
+}
+
+func (file *File) IsVirtual() bool {
+    //File in virtual filesystem (fs.FS) or archive, not on disk
+    return file.fsys != nil || file.ArchivePath != ""
+}
+
 func (file *File) Exists() bool {
+    if file.fsys != nil && file.ArchivePath == "" {
+        fi, err := fs.Stat(file.fsys, file.Path)
+        return err == nil && !fi.IsDir()
+    }
     fi, err := os.Stat(file.Path)
+    if err != nil && file.FullPath != "" {
+        //Relative path not found, might be another working directory
+        fi, err = os.Stat(file.FullPath)
+    }
     exists := err == nil
     return exists && !fi.IsDir()
 }
 
+func (file *File) Resolve(workingDir string) error {
+    //Full path, derived from relative path if missing
+    if file.FullPath == "" {
+        file.FullPath = filepath.Join(workingDir, file.Path)
+    }
+    file.FullPath = filepath.Clean(file.FullPath)
+
+    //Keep relative path if it can be found from working directory
+    path := file.Path
+    if !filepath.IsAbs(path) {
+        path = filepath.Join(workingDir, path)
+    }
+    if _, err := os.Stat(path); err == nil {
+        return nil
+    }
+
+    //Otherwise, use path relative to working directory
+    if _, err := os.Stat(file.FullPath); err != nil {
+        return err
+    }
+    relPath, err := filepath.Rel(workingDir, file.FullPath)
+    if err != nil {
+        return err
+    }
+    file.Path = relPath
+
+    return nil
+}
+
+func (file *File) ComputeRelativePath(base string) error {
+    //Path relative to base directory (search path), from full path
+    absBase, err := filepath.Abs(base)
+    if err != nil {
+        return err
+    }
+    rel, err := filepath.Rel(absBase, file.FullPath)
+    if err != nil {
+        return err
+    }
+    file.RelativePath = rel
+    return nil
+}
+
+func (file *File) rootPath() string {
+    //Directory in which the file has been scanned (FullPath minus Path)
+    if file.FullPath == "" || filepath.IsAbs(file.Path) ||
+        !strings.HasSuffix(file.FullPath, file.Path) {
+        return ""
+    }
+    root := strings.TrimSuffix(file.FullPath, file.Path)
+    return filepath.Clean(root)
+}
+
+func (file *File) HasInode() bool {
+    //Inode number may not be available (network share, Windows)
+    return file.Inum != 0
+}
+
 func (file *File) HashValue() string {
+    //Hash with algorithm prefix (md5:...)
+    //Hashes of different algorithms never match
+    //SHA1 is also computed along with MD5 (-compute-sha1), MD5 is preferred then
+    //See HashValueFor for the configured algorithm
     var firstHash string
 
+    if file.SHA1 != "" {
+        firstHash = "sha1:" + file.SHA1
+    }
     if file.MD5 != "" {
-        firstHash = file.MD5
+        firstHash = "md5:" + file.MD5
     }
-    if file.SHA1 != "" {
-        firstHash = file.SHA1
+    if file.SHA256 != "" {
+        firstHash = "sha256:" + file.SHA256
+    }
+    if file.BLAKE2b != "" {
+        firstHash = "blake2b:" + file.BLAKE2b
+    }
+    if file.BLAKE3 != "" {
+        firstHash = "blake3:" + file.BLAKE3
+    }
+    if firstHash == "" && len(file.Hashes) > 0 {
+        //Other algorithm, sorted by name if there are several
+        var algorithms []string
+        for algorithm := range file.Hashes {
+            algorithms = append(algorithms, algorithm)
+        }
+        sort.Strings(algorithms)
+        firstHash = algorithms[0] + ":" + file.Hashes[algorithms[0]]
     }
 
     return firstHash
 }
 
+func (file *File) HashValueFor(algorithm string) string {
+    //Like HashValue, hash of given (primary) algorithm ranked first
+    if hash := file.HashOf(algorithm); hash != "" {
+        return algorithm + ":" + hash
+    }
+    return file.HashValue()
+}
+
+func trimHashPrefix(hash string, algorithm string) string {
+    //Hash without algorithm prefix, legacy hashes have none
+    return strings.TrimPrefix(hash, algorithm + ":")
+}
+
+func (file *File) HashOf(algorithm string) string {
+    switch algorithm {
+    case "md5":
+        return file.MD5
+    case "sha1":
+        return file.SHA1
+    case "sha256":
+        return file.SHA256
+    case "blake2b":
+        return file.BLAKE2b
+    case "blake3":
+        return file.BLAKE3
+    }
+    return file.Hashes[algorithm]
+}
+
+func (file *File) setHash(algorithm string, hash string) {
+    switch algorithm {
+    case "md5":
+        file.MD5 = hash
+    case "sha1":
+        file.SHA1 = hash
+    case "sha256":
+        file.SHA256 = hash
+    case "blake2b":
+        file.BLAKE2b = hash
+    case "blake3":
+        file.BLAKE3 = hash
+    default:
+        //Copy on write, map may be shared with a copy of this file
+        hashes := make(map[string]string, len(file.Hashes) + 1)
+        for algorithm, hash := range file.Hashes {
+            hashes[algorithm] = hash
+        }
+        hashes[algorithm] = hash
+        file.Hashes = hashes
+    }
+}
+
+func (file *File) CASKey() string {
+    //Key for content-addressable storage, SHA-256 if available
+    if file.SHA256 != "" {
+        return "sha256:" + file.SHA256
+    }
+    if file.MD5 != "" {
+        return "md5:" + file.MD5
+    }
+    return ""
+}
+
+func (file *File) CASPath(baseDir string) string {
+    //Storage path like baseDir/sha/256/ab/cdef... (like git objects)
+    //Empty without SHA-256 hash
+    if len(file.SHA256) < 3 {
+        return ""
+    }
+    return filepath.Join(baseDir, "sha", "256", file.SHA256[:2], file.SHA256[2:])
+}
+
 func (file *File) IsHashed() bool {
     return file.HashValue() != ""
 }
 
+func NewFileFromContent(content []byte) *File {
+    //File object with content hashed in memory (no file on disk)
+    file := &File{}
+    file.Size = int64(len(content))
+    file.HashReader(bytes.NewReader(content))
+
+    return file
+}
+
 func (file *File) Hash() error {
+    return file.HashAll([]string{"md5"})
+}
+
+func (file *File) HashReader(r io.Reader) error {
+    //Hash content from reader (MD5), doesn't have to be a file
+    //Canonical way to hash content in tests
+    return file.hashReader(r, []string{"md5"})
+}
+
+func (file *File) HashAll(algorithms []string) error {
     //Open file
+    f, err := file.open()
+    if err != nil {
+        return openError(err)
+    }
+    defer f.Close()
+
+    if err := file.hashReader(f, algorithms); err != nil {
+        return err
+    }
+    return file.checkSize(f)
+}
+
+func (file *File) checkSize(f fs.File) error {
+    //Size changed between stat and hash (file still being written)
+    //Hash is set anyway, but it may not match the stored size
+    fi, err := f.Stat()
+    if err != nil || fi.Size() == file.Size {
+        return nil
+    }
+    return ErrModifiedDuringScan
+}
+
+func (file *File) HashMmap() error {
+    return file.HashAllMmap([]string{"md5"})
+}
+
+func (file *File) HashAllMmap(algorithms []string) (err error) {
+    //Hash memory-mapped file, for large files
+    //Regular reads if mmap fails (like on network filesystems)
+    //File truncated while it's mapped causes a fault (SIGBUS), turned into an error
+    defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%w: %v", ErrHashPanic, r)
+        }
+    }()
+    if file.IsVirtual() {
+        return file.HashAll(algorithms)
+    }
     f, err := os.Open(file.Path)
+    if err != nil {
+        return openError(err)
+    }
+    defer f.Close()
+    data, err := mmapFile(f, file.Size)
+    if err != nil {
+        if err := file.hashReader(f, algorithms); err != nil {
+            return err
+        }
+        return file.checkSize(f)
+    }
+    defer munmapFile(data)
+
+    if err := file.hashReader(chunkReader{bytes.NewReader(data), data}, algorithms); err != nil {
+        return err
+    }
+    return file.checkSize(f)
+}
+
+//Reader for memory-mapped data, written to hash functions in chunks (no copy)
+type chunkReader struct {
+    *bytes.Reader
+    data []byte
+}
+
+func (r chunkReader) WriteTo(w io.Writer) (int64, error) {
+    const chunkSize = 1024 * 1024
+    var n int64
+    for offset := 0; offset < len(r.data); offset += chunkSize {
+        end := offset + chunkSize
+        if end > len(r.data) {
+            end = len(r.data)
+        }
+        written, err := w.Write(r.data[offset:end])
+        n += int64(written)
+        if err != nil {
+            return n, err
+        }
+    }
+    return n, nil
+}
+
+func (file *File) hashReader(r io.Reader, algorithms []string) error {
+    //Hash functions, all fed in a single pass
+    hashers := make(map[string]hash.Hash)
+    var writers []io.Writer
+    for _, algorithm := range algorithms {
+        factory, found := HashRegistry[algorithm]
+        if !found {
+            return fmt.Errorf("Unsupported hash algorithm: %s", algorithm)
+        }
+        h := factory()
+        hashers[algorithm] = h
+        writers = append(writers, h)
+    }
+
+    //Read content once
+    if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+        return err
+    }
+    for algorithm, h := range hashers {
+        file.setHash(algorithm, hex.EncodeToString(h.Sum(nil)))
+    }
+
+    return nil
+}
+
+func (file *File) HashSample() error {
+    //Open file
+    f, err := file.open()
     if err != nil {
         return err
     }
     defer f.Close()
 
-    //MD5
+    //Sample offsets: beginning, middle, end
+    //Small files are read completely
+    const sampleSize = 64 * 1024
     hashMD5 := md5.New()
-    if _, err := io.Copy(hashMD5, f); err != nil {
+    size := file.Size
+    if size <= 3 * sampleSize {
+        if _, err := io.Copy(hashMD5, f); err != nil {
+            return err
+        }
+    } else {
+        offsets := []int64{0, size / 2 - sampleSize / 2, size - sampleSize}
+        var pos int64 //read position, files without random access
+        for _, offset := range offsets {
+            if ra, ok := f.(io.ReaderAt); ok {
+                r := io.NewSectionReader(ra, offset, sampleSize)
+                if _, err := io.Copy(hashMD5, r); err != nil {
+                    return err
+                }
+                continue
+            }
+            if _, err := io.CopyN(io.Discard, f, offset - pos); err != nil {
+                return err
+            }
+            if _, err := io.CopyN(hashMD5, f, sampleSize); err != nil {
+                return err
+            }
+            pos = offset + sampleSize
+        }
+    }
+    file.SampleHash = hex.EncodeToString(hashMD5.Sum(nil))
+
+    return nil
+}
+
+func (file *File) HashPartial(limit int64, algorithm string) error {
+    //Hash first and last limit/2 bytes only, for very large files
+    //Stored as the file's hash with partial: prefix, never equal to a full hash
+    f, err := file.open()
+    if err != nil {
+        return openError(err)
+    }
+    defer f.Close()
+
+    //Complete hash if file is small enough or has no random access (archive)
+    ra, ok := f.(io.ReaderAt)
+    if !ok || file.Size <= limit {
+        return file.hashReader(f, []string{algorithm})
+    }
+
+    //Beginning and end, middle is skipped
+    head := limit / 2
+    tail := limit - head
+    r := io.MultiReader(io.NewSectionReader(ra, 0, head),
+        io.NewSectionReader(ra, file.Size - tail, tail))
+    var partial File
+    if err := partial.hashReader(r, []string{algorithm}); err != nil {
+        return err
+    }
+    file.setHash(algorithm, "partial:" + partial.HashOf(algorithm))
+    file.HashSizeLimit = true
+
+    return nil
+}
+
+type FileOpError struct {
+    Op string
+    Src string
+    Dst string
+    Err error
+}
+
+func (e *FileOpError) Error() string {
+    return fmt.Sprintf("%s %s %s: %s", e.Op, e.Src, e.Dst, e.Err)
+}
+
+func (e *FileOpError) Unwrap() error {
+    return e.Err
+}
+
+func (file *File) CopyTo(dst string) error {
+    copyError := func(err error) error {
+        return &FileOpError{"copy", file.Path, dst, err}
+    }
+
+    //Open source file, may be in an archive or virtual filesystem
+    src, err := file.open()
+    if err != nil {
+        return copyError(err)
+    }
+    defer src.Close()
+    fi, err := src.Stat()
+    if err != nil {
+        return copyError(err)
+    }
+
+    //Create destination file (and directory), replace existing file
+    if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+        return copyError(err)
+    }
+    out, err := os.OpenFile(dst, os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+        fi.Mode().Perm())
+    if err != nil {
+        return copyError(err)
+    }
+
+    //Copy content
+    if _, err := io.Copy(out, src); err != nil {
+        out.Close()
+        return copyError(err)
+    }
+    if err := out.Close(); err != nil {
+        return copyError(err)
+    }
+
+    //Keep modification time
+    if err := os.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+        return copyError(err)
+    }
+
+    return nil
+}
+
+func (file *File) MoveTo(dst string) error {
+    //Rename, copy and remove if destination is on another filesystem
+    if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+        return &FileOpError{"move", file.Path, dst, err}
+    }
+    if err := os.Rename(file.Path, dst); err == nil {
+        return nil
+    }
+    if err := file.CopyTo(dst); err != nil {
         return err
     }
-    file.MD5 = hex.EncodeToString(hashMD5.Sum(nil))
+    if err := os.Remove(file.Path); err != nil {
+        return &FileOpError{"move", file.Path, dst, err}
+    }
 
     return nil
 }
@@ -100,9 +676,11 @@ func (file *File) LooksIdentical(other *File) bool {
     probablyIdentical = file.Path != ""
 
     //Compare size and mtime
+    //A zero mtime (broken entry) is not trusted, it may match any other broken file
     probablyIdentical = probablyIdentical &&
         file.Size == other.Size &&
-        file.ModificationTime == other.ModificationTime
+        file.ModificationTime == other.ModificationTime &&
+        file.ModificationTime != 0
 
     return probablyIdentical
 }
@@ -0,0 +1,522 @@
+package main
+
+import (
+    "os"
+    "sort"
+    "time"
+    "errors"
+    "io/fs"
+    "strings"
+    "testing"
+    "reflect"
+    "encoding/json"
+    "path/filepath"
+)
+
+func TestComputeRelativePath(t *testing.T) {
+    base := t.TempDir()
+    tests := []struct {
+        path string
+        expected string
+    }{
+        {"a", "a"},
+        {"sub/b", filepath.Join("sub", "b")},
+        {"sub/deeper/deepest/c", filepath.Join("sub", "deeper", "deepest", "c")},
+    }
+    for _, test := range tests {
+        t.Run(test.path, func(t *testing.T) {
+            file := &File{FullPath: filepath.Join(base, test.path)}
+            if err := file.ComputeRelativePath(base); err != nil {
+                t.Fatal(err)
+            }
+            if file.RelativePath != test.expected {
+                t.Errorf("Relative path %s, expected %s", file.RelativePath, test.expected)
+            }
+        })
+    }
+
+    //Set during scan, relative to the search path the file was found in
+    writeTestFiles(t, base, map[string]string{"a": "a", "sub/deeper/c": "c"})
+    scan := scanTestDir(t, base)
+    for _, file := range scan.Files {
+        expected, _ := filepath.Rel(base, file.FullPath)
+        if file.RelativePath != expected {
+            t.Errorf("Relative path of %s is %s, expected %s", file.Path, file.RelativePath, expected)
+        }
+    }
+}
+
+func TestActionsRelativeSearchPath(t *testing.T) {
+    //Relative paths (-use-relative-path) are below the search path, not the working directory
+    //Actions must use paths that can be opened
+    actions := []struct {
+        name string
+        run func(scan *Scan, filePath func(*File) string) int //failed
+        check func(t *testing.T, paths []string)
+    }{
+        {"delete", func(scan *Scan, filePath func(*File) string) int {
+            return scan.DeleteDuplicates(filePath).Failed
+        }, func(t *testing.T, paths []string) {
+            var remaining int
+            for _, path := range paths {
+                if _, err := os.Stat(path); err == nil {
+                    remaining++
+                }
+            }
+            if remaining != 1 {
+                t.Errorf("%d files left, expected 1", remaining)
+            }
+        }},
+        {"link", func(scan *Scan, filePath func(*File) string) int {
+            return scan.LinkDuplicates(filePath).Failed
+        }, func(t *testing.T, paths []string) {
+            first, err := os.Stat(paths[0])
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, path := range paths[1:] {
+                fi, err := os.Stat(path)
+                if err != nil || !os.SameFile(first, fi) {
+                    t.Errorf("Not linked: %s", path)
+                }
+            }
+        }},
+        {"rename", func(scan *Scan, filePath func(*File) string) int {
+            if err := scan.RenameDuplicates(".dup", false, filePath); err != nil {
+                return 1
+            }
+            return 0
+        }, func(t *testing.T, paths []string) {
+            var renamed int
+            for _, path := range paths {
+                if _, err := os.Stat(path + ".dup"); err == nil {
+                    renamed++
+                }
+            }
+            if renamed != 2 {
+                t.Errorf("%d files renamed, expected 2", renamed)
+            }
+        }},
+    }
+    for _, action := range actions {
+        for _, useFullPath := range []bool{false, true} {
+            name := action.name
+            if useFullPath {
+                name += " full path"
+            }
+            t.Run(name, func(t *testing.T) {
+                dir := t.TempDir()
+                writeTestFiles(t, dir, map[string]string{
+                    "files/a": "same", "files/sub/b": "same", "files/sub/deeper/c": "same",
+                })
+                t.Chdir(dir)
+                scan := scanTestDir(t, "files")
+
+                //Displayed relative path does not exist in working directory
+                display := displayPath(useFullPath, true)
+                for _, file := range scan.Files {
+                    if !useFullPath && display(file) != file.RelativePath {
+                        t.Errorf("Displayed path %s, expected %s", display(file), file.RelativePath)
+                    }
+                }
+
+                if failed := action.run(scan, accessPath(useFullPath)); failed > 0 {
+                    t.Errorf("%d files failed", failed)
+                }
+                action.check(t, []string{
+                    filepath.Join(dir, "files", "a"),
+                    filepath.Join(dir, "files", "sub", "b"),
+                    filepath.Join(dir, "files", "sub", "deeper", "c"),
+                })
+            })
+        }
+    }
+}
+
+func TestHashValueFor(t *testing.T) {
+    file := &File{MD5: "m", SHA1: "s", Hashes: map[string]string{"other": "o"}}
+    tests := []struct {
+        algorithm string
+        expected string
+    }{
+        {"md5", "md5:m"},
+        {"sha1", "sha1:s"},
+        {"other", "other:o"},
+        {"sha256", "md5:m"}, //not computed, default order
+        {"", "md5:m"},
+    }
+    for _, test := range tests {
+        if value := file.HashValueFor(test.algorithm); value != test.expected {
+            t.Errorf("Hash value for %s: %s, expected %s", test.algorithm, value, test.expected)
+        }
+    }
+
+    //Files grouped by configured algorithm
+    scan := NewScan()
+    scan.HashAlgorithm = "sha1"
+    scan.Files = FileMap{
+        "a": &File{Path: "a", Size: 1, MD5: "m1", SHA1: "s"},
+        "b": &File{Path: "b", Size: 1, MD5: "m2", SHA1: "s"},
+    }
+    scan.BuildHashFilesMap()
+    if _, found := scan.DuplicatesMap()["sha1:s"]; !found {
+        t.Errorf("Files not grouped by SHA1: %v", scan.DuplicatesMap())
+    }
+}
+
+func TestCreationTimeJSON(t *testing.T) {
+    //Round trip, key doesn't look like the Unix change time (ctime)
+    file := &File{Path: "a", FullPath: "/a", Name: "a", ModificationTime: 1, CreationTime: 2}
+    data, err := json.Marshal(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), `"creation_time":2`) {
+        t.Errorf("Creation time missing: %s", data)
+    }
+    var decoded File
+    if err := json.Unmarshal(data, &decoded); err != nil {
+        t.Fatal(err)
+    }
+    if decoded.CreationTime != 2 || decoded.ModificationTime != 1 {
+        t.Errorf("Decoded times %d/%d, expected 2/1", decoded.CreationTime, decoded.ModificationTime)
+    }
+
+    //Older maps
+    for _, old := range []string{`{"path":"a","CreationTime":2}`, `{"path":"a","ctime":2}`} {
+        var decoded File
+        if err := json.Unmarshal([]byte(old), &decoded); err != nil {
+            t.Fatal(err)
+        }
+        if decoded.CreationTime != 2 {
+            t.Errorf("Creation time from %s: %d, expected 2", old, decoded.CreationTime)
+        }
+    }
+}
+
+func TestFileMapMethods(t *testing.T) {
+    mixed := FileMap{
+        "c.JPG": &File{Path: "c.JPG", Name: "c.JPG", Size: 300},
+        "a.txt": &File{Path: "a.txt", Name: "a.txt", Size: 100},
+        "b.png": &File{Path: "b.png", Name: "b.png", Size: 200},
+        "d": &File{Path: "d", Name: "d", Size: 0},
+    }
+    tests := []struct {
+        name string
+        files FileMap
+        keys []string
+        sized []string //FilterBySize(100, 200)
+        unlimited []string //FilterBySize(150, 0)
+        images []string //FilterByExtension("jpg", ".PNG")
+    }{
+        {"empty", FileMap{}, []string{}, []string{}, []string{}, []string{}},
+        {"single", FileMap{"a.jpg": &File{Path: "a.jpg", Name: "a.jpg", Size: 150}},
+            []string{"a.jpg"}, []string{"a.jpg"}, []string{"a.jpg"}, []string{"a.jpg"}},
+        {"mixed", mixed, []string{"a.txt", "b.png", "c.JPG", "d"},
+            []string{"a.txt", "b.png"}, []string{"b.png", "c.JPG"}, []string{"b.png", "c.JPG"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            count := len(test.files)
+            if test.files.Len() != len(test.keys) {
+                t.Errorf("Len %d, expected %d", test.files.Len(), len(test.keys))
+            }
+            if keys := test.files.Keys(); !reflect.DeepEqual(keys, test.keys) {
+                t.Errorf("Keys %v, expected %v", keys, test.keys)
+            }
+            var paths []string
+            for _, file := range test.files.Values() {
+                paths = append(paths, file.Path)
+            }
+            if len(paths) != len(test.keys) || (len(paths) > 0 && !reflect.DeepEqual(paths, test.keys)) {
+                t.Errorf("Values %v, expected %v", paths, test.keys)
+            }
+            if keys := test.files.FilterBySize(100, 200).Keys(); !reflect.DeepEqual(keys, test.sized) {
+                t.Errorf("Filtered by size %v, expected %v", keys, test.sized)
+            }
+            if keys := test.files.FilterBySize(150, 0).Keys(); !reflect.DeepEqual(keys, test.unlimited) {
+                t.Errorf("Filtered by minimum size %v, expected %v", keys, test.unlimited)
+            }
+            if keys := test.files.FilterByExtension([]string{"jpg", ".PNG"}).Keys(); !reflect.DeepEqual(keys, test.images) {
+                t.Errorf("Filtered by extension %v, expected %v", keys, test.images)
+            }
+            if len(test.files) != count {
+                t.Errorf("Map changed, %d files, expected %d", len(test.files), count)
+            }
+        })
+    }
+}
+
+func TestSortFiles(t *testing.T) {
+    files := FileList{
+        &File{Path: "dir/longer/b", Inum: 30},
+        &File{Path: "a", Inum: 20},
+        &File{Path: "dir/c", Inum: 10},
+    }
+    tests := []struct {
+        name string
+        sort SortMode
+        reverse bool
+        expected []string
+    }{
+        {"inode", SortByInode, false, []string{"dir/c", "a", "dir/longer/b"}},
+        {"inode reversed", SortByInode, true, []string{"dir/longer/b", "a", "dir/c"}},
+        {"path length", SortByPathLength, false, []string{"a", "dir/c", "dir/longer/b"}},
+        {"path length reversed", SortByPathLength, true, []string{"dir/longer/b", "dir/c", "a"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            sorted := Files{Files: append(FileList{}, files...), sort: test.sort, reverse: test.reverse}
+            sort.Sort(sorted)
+            var paths []string
+            for _, file := range sorted.Files {
+                paths = append(paths, file.Path)
+            }
+            if !reflect.DeepEqual(paths, test.expected) {
+                t.Errorf("Order %v, expected %v", paths, test.expected)
+            }
+        })
+    }
+}
+
+func TestCopyTo(t *testing.T) {
+    tests := []struct {
+        name string
+        existing bool //destination exists
+        missing bool //source doesn't exist
+    }{
+        {"new destination", false, false},
+        {"overwrite", true, false},
+        {"source missing", false, true},
+    }
+    for _, test := range tests {
+        for _, move := range []bool{false, true} {
+            name := "copy " + test.name
+            if move {
+                name = "move " + test.name
+            }
+            t.Run(name, func(t *testing.T) {
+                dir := t.TempDir()
+                src := filepath.Join(dir, "src")
+                dst := filepath.Join(dir, "sub", "dst")
+                mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+                if !test.missing {
+                    writeTestFiles(t, dir, map[string]string{"src": "content"})
+                    if err := os.Chtimes(src, mtime, mtime); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+                if test.existing {
+                    writeTestFiles(t, dir, map[string]string{"sub/dst": "old and longer content"})
+                }
+
+                file := &File{Path: src}
+                var err error
+                if move {
+                    err = file.MoveTo(dst)
+                } else {
+                    err = file.CopyTo(dst)
+                }
+                if test.missing {
+                    var opErr *FileOpError
+                    if !errors.As(err, &opErr) || opErr.Src != src || opErr.Dst != dst || !errors.Is(err, fs.ErrNotExist) {
+                        t.Errorf("Error %v, expected file operation error for missing source", err)
+                    }
+                    return
+                }
+                if err != nil {
+                    t.Fatal(err)
+                }
+                data, err := os.ReadFile(dst)
+                if err != nil || string(data) != "content" {
+                    t.Errorf("Destination %q (%v), expected %q", data, err, "content")
+                }
+                fi, err := os.Stat(dst)
+                if err != nil || !fi.ModTime().Equal(mtime) {
+                    t.Errorf("Modification time %v, expected %v", fi.ModTime(), mtime)
+                }
+                if _, err := os.Stat(src); (err == nil) == move {
+                    t.Errorf("Source exists: %t after move: %t", err == nil, move)
+                }
+            })
+        }
+    }
+}
+
+func TestHashPrefixNotGrouped(t *testing.T) {
+    //Same hash value of different algorithms is not a match
+    md5File := &File{Path: "a", Size: 3, MD5: "abc"}
+    sha1File := &File{Path: "b", Size: 3, SHA1: "abc"}
+    if md5File.HashValue() == sha1File.HashValue() {
+        t.Errorf("Same hash value: %s", md5File.HashValue())
+    }
+    for _, algorithm := range []string{"", "md5", "sha1"} {
+        scan := NewScan()
+        scan.HashAlgorithm = algorithm
+        scan.Files = FileMap{"a": md5File, "b": sha1File}
+        scan.BuildHashFilesMap()
+        if groups := scan.DuplicatesMap(); len(groups) != 0 {
+            t.Errorf("Algorithm %q: grouped %v", algorithm, groupPaths(groups))
+        }
+    }
+
+    //Prefixed and legacy values in imported map
+    scan := NewScan()
+    err := scan.ImportMapFrom(strings.NewReader(`[
+        {"path": "a", "full_path": "/a", "name": "a", "size": 3, "md5": "md5:abc"},
+        {"path": "b", "full_path": "/b", "name": "b", "size": 3, "md5": "abc"},
+        {"path": "c", "full_path": "/c", "name": "c", "size": 3, "sha1": "abc"}
+    ]`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := [][]string{{"a", "b"}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+}
+
+func TestHashReader(t *testing.T) {
+    //Known MD5 values, same result as hashing a file
+    tests := []struct {
+        content string
+        md5 string
+    }{
+        {"", "d41d8cd98f00b204e9800998ecf8427e"},
+        {"abc", "900150983cd24fb0d6963f7d28e17f72"},
+    }
+    dir := t.TempDir()
+    for _, test := range tests {
+        file := &File{}
+        if err := file.HashReader(strings.NewReader(test.content)); err != nil {
+            t.Fatal(err)
+        }
+        if file.MD5 != test.md5 {
+            t.Errorf("MD5 of %q: %s, expected %s", test.content, file.MD5, test.md5)
+        }
+        if fromContent := NewFileFromContent([]byte(test.content)); fromContent.MD5 != test.md5 || fromContent.Size != int64(len(test.content)) {
+            t.Errorf("File from %q: MD5 %s, size %d", test.content, fromContent.MD5, fromContent.Size)
+        }
+
+        path := filepath.Join(dir, "file")
+        writeTestFiles(t, dir, map[string]string{"file": test.content})
+        onDisk := &File{Path: path, Size: int64(len(test.content))}
+        if err := onDisk.Hash(); err != nil || onDisk.MD5 != file.MD5 {
+            t.Errorf("MD5 of file %s (%v), expected %s", onDisk.MD5, err, file.MD5)
+        }
+    }
+}
+
+func TestHashMmap(t *testing.T) {
+    //Same hashes as regular reads, content larger than one chunk included
+    algorithms := HashAlgorithms()
+    dir := t.TempDir()
+    tests := []struct {
+        name string
+        size int
+    }{
+        {"empty", 0},
+        {"small", 10},
+        {"one chunk", 1024 * 1024},
+        {"several chunks", 3 * 1024 * 1024 + 5},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            content := make([]byte, test.size)
+            for i := range content {
+                content[i] = byte(i * 7)
+            }
+            path := filepath.Join(dir, test.name)
+            if err := os.WriteFile(path, content, 0644); err != nil {
+                t.Fatal(err)
+            }
+            read := &File{Path: path, Size: int64(test.size)}
+            mapped := &File{Path: path, Size: int64(test.size)}
+            if err := read.HashAll(algorithms); err != nil {
+                t.Fatal(err)
+            }
+            if err := mapped.HashAllMmap(algorithms); err != nil {
+                t.Fatal(err)
+            }
+            for _, algorithm := range algorithms {
+                if read.HashOf(algorithm) == "" || mapped.HashOf(algorithm) != read.HashOf(algorithm) {
+                    t.Errorf("%s: %q (mmap), expected %q", algorithm, mapped.HashOf(algorithm), read.HashOf(algorithm))
+                }
+            }
+        })
+    }
+}
+
+func TestFileJSON(t *testing.T) {
+    //Current keys and old field names decoded the same way
+    expected := File{
+        Path: "a", FullPath: "/data/a", Name: "a", Size: 3, ModificationTime: 1700000000,
+        CreationTime: 1600000000, MD5: "abc", SampleHash: "def", Inum: 7, ArchivePath: "/data/x.zip",
+    }
+    tests := []struct {
+        name string
+        data string
+    }{
+        {"current", `{"path": "a", "full_path": "/data/a", "name": "a", "size": 3, "mtime": 1700000000,
+            "creation_time": 1600000000, "md5": "abc", "sample_hash": "def", "inum": 7, "archive_path": "/data/x.zip"}`},
+        {"old", `{"Path": "a", "FullPath": "/data/a", "Name": "a", "Size": 3, "ModificationTime": 1700000000,
+            "CreationTime": 1600000000, "MD5": "abc", "SampleHash": "def", "Inum": 7, "ArchivePath": "/data/x.zip"}`},
+        {"ctime", `{"path": "a", "full_path": "/data/a", "name": "a", "size": 3, "mtime": 1700000000,
+            "ctime": 1600000000, "md5": "abc", "sample_hash": "def", "inum": 7, "archive_path": "/data/x.zip"}`},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var file File
+            if err := json.Unmarshal([]byte(test.data), &file); err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(file, expected) {
+                t.Fatalf("File %+v, expected %+v", file, expected)
+            }
+
+            //Written with current keys, same file read back
+            data, err := json.Marshal(&file)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if strings.Contains(string(data), `"Path"`) || !strings.Contains(string(data), `"full_path"`) {
+                t.Errorf("Encoded %s, expected current keys", data)
+            }
+            var decoded File
+            if err := json.Unmarshal(data, &decoded); err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(decoded, expected) {
+                t.Errorf("Round trip %+v, expected %+v", decoded, expected)
+            }
+        })
+    }
+}
+
+func TestCASKey(t *testing.T) {
+    //SHA-256 of "abc", MD5 of "abc"
+    const sha = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+    const md5 = "900150983cd24fb0d6963f7d28e17f72"
+    base := filepath.Join("store", "cas")
+    tests := []struct {
+        name string
+        file File
+        key string
+        path string
+    }{
+        {"sha256", File{SHA256: sha, MD5: md5}, "sha256:" + sha,
+            filepath.Join(base, "sha", "256", "ba", sha[2:])},
+        {"md5 only", File{MD5: md5}, "md5:" + md5, ""},
+        {"no hash", File{}, "", ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if key := test.file.CASKey(); key != test.key {
+                t.Errorf("Key %s, expected %s", key, test.key)
+            }
+            if path := test.file.CASPath(base); path != test.path {
+                t.Errorf("Path %s, expected %s", path, test.path)
+            }
+        })
+    }
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "sort"
+    "strings"
+    "container/heap"
+    "path/filepath"
+
+    "github.com/dustin/go-humanize"
+)
+
+type DuplicateGroup struct {
+    Hash string
+    Files FileList
+}
+
+func (scan *Scan) DuplicateGroupsWithHardlinks() []DuplicateGroup {
+    //All paths of hardlinked files (-include-hardlinks), for listing only
+    //Never use these groups for actions, hardlinks share their data
+    var groups []DuplicateGroup
+    for hash, files := range scan.duplicatesMap(true) {
+        groups = append(groups, DuplicateGroup{Hash: hash, Files: files})
+    }
+    return groups
+}
+
+func (group DuplicateGroup) Size() int64 {
+    //Size of a single file, all files in a group have the same size
+    if len(group.Files) == 0 {
+        return 0
+    }
+    return group.Files[0].Size
+}
+
+func (group DuplicateGroup) WastedSize() int64 {
+    //Space used by all but one copy
+    //Hardlinks (-include-hardlinks) share their data, counted once
+    if len(group.Files) == 0 {
+        return 0
+    }
+    copies := 0
+    inodes := make(map[uint64]bool)
+    for _, file := range group.Files {
+        if file.HasInode() {
+            if inodes[file.Inum] {
+                continue
+            }
+            inodes[file.Inum] = true
+        }
+        copies++
+    }
+    return group.Size() * int64(copies - 1)
+}
+
+func (scan *Scan) DuplicateGroups() []DuplicateGroup {
+    var groups []DuplicateGroup
+    for hash, files := range scan.DuplicatesMap() {
+        groups = append(groups, DuplicateGroup{Hash: hash, Files: files})
+    }
+    return groups
+}
+
+func SortDuplicateGroups(groups []DuplicateGroup, by string, reverse bool) ([]DuplicateGroup, error) {
+    //Compare function, groups are ordered by hash if otherwise equal
+    var less func(a, b DuplicateGroup) bool
+    switch by {
+    case "path":
+        less = func(a, b DuplicateGroup) bool {
+            return a.Files[0].Path < b.Files[0].Path
+        }
+    case "size":
+        //Largest wasted size first
+        less = func(a, b DuplicateGroup) bool {
+            return a.WastedSize() > b.WastedSize()
+        }
+    case "count":
+        //Largest group first
+        less = func(a, b DuplicateGroup) bool {
+            return len(a.Files) > len(b.Files)
+        }
+    case "hash":
+        less = func(a, b DuplicateGroup) bool {
+            return false
+        }
+    default:
+        return nil, fmt.Errorf("Invalid group sort order: %s", by)
+    }
+
+    //Sort copy of list
+    sorted := make([]DuplicateGroup, len(groups))
+    copy(sorted, groups)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        a, b := sorted[i], sorted[j]
+        if reverse {
+            a, b = b, a
+        }
+        if less(a, b) {
+            return true
+        }
+        if less(b, a) {
+            return false
+        }
+        return a.Hash < b.Hash
+    })
+
+    return sorted, nil
+}
+
+func TopDuplicateGroups(groups []DuplicateGroup, n int) []DuplicateGroup {
+    //N groups with the most wasted space, most wasted space first
+    //Min-heap of size N, smallest of the top groups at the root
+    if n <= 0 {
+        return nil
+    }
+    h := &groupHeap{}
+    for _, group := range groups {
+        if h.Len() < n {
+            heap.Push(h, group)
+        } else if wastesMore(group, (*h)[0]) {
+            (*h)[0] = group
+            heap.Fix(h, 0)
+        }
+    }
+    top := make([]DuplicateGroup, h.Len())
+    for i := len(top) - 1; i >= 0; i-- {
+        top[i] = heap.Pop(h).(DuplicateGroup)
+    }
+    return top
+}
+
+func (scan *Scan) TopWastedSpace(n int) []DuplicateGroup {
+    //Top 10 by default
+    if n <= 0 {
+        n = 10
+    }
+    return TopDuplicateGroups(scan.DuplicateGroups(), n)
+}
+
+func wastesMore(a, b DuplicateGroup) bool {
+    //More wasted space, ordered by hash if equal
+    if a.WastedSize() != b.WastedSize() {
+        return a.WastedSize() > b.WastedSize()
+    }
+    return a.Hash < b.Hash
+}
+
+//Min-heap of duplicate groups, by wasted space
+type groupHeap []DuplicateGroup
+
+func (h groupHeap) Len() int {
+    return len(h)
+}
+
+func (h groupHeap) Less(i, j int) bool {
+    return wastesMore(h[j], h[i])
+}
+
+func (h groupHeap) Swap(i, j int) {
+    h[i], h[j] = h[j], h[i]
+}
+
+func (h *groupHeap) Push(x interface{}) {
+    *h = append(*h, x.(DuplicateGroup))
+}
+
+func (h *groupHeap) Pop() interface{} {
+    old := *h
+    x := old[len(old) - 1]
+    *h = old[:len(old) - 1]
+    return x
+}
+
+func PrintTopWasted(w io.Writer, groups []DuplicateGroup, filePath func(*File) string) {
+    //One line per group: wasted space, number of files, first file
+    for _, group := range groups {
+        fmt.Fprintf(w, "%s\t%d\t%s\n", humanize.IBytes(uint64(group.WastedSize())),
+            len(group.Files), filePath(group.Files[0]))
+    }
+}
+
+func (scan *Scan) DuplicatesByExtension() map[string][]DuplicateGroup {
+    //Duplicate groups by lowercase extension of first file (like ".jpg", "" if none)
+    byExtension := make(map[string][]DuplicateGroup)
+    for _, group := range scan.DuplicateGroups() {
+        ext := strings.ToLower(filepath.Ext(group.Files[0].Name))
+        byExtension[ext] = append(byExtension[ext], group)
+    }
+    return byExtension
+}
+
+func PrintExtensionTable(w io.Writer, byExtension map[string][]DuplicateGroup) {
+    //One line per extension, most wasted space first
+    exts := make([]string, 0, len(byExtension))
+    wasted := make(map[string]int64)
+    for ext, groups := range byExtension {
+        exts = append(exts, ext)
+        for _, group := range groups {
+            wasted[ext] += group.WastedSize()
+        }
+    }
+    sort.Slice(exts, func(i, j int) bool {
+        if wasted[exts[i]] != wasted[exts[j]] {
+            return wasted[exts[i]] > wasted[exts[j]]
+        }
+        return exts[i] < exts[j]
+    })
+    fmt.Fprintf(w, "ext\tgroups\tfiles\twasted\n")
+    for _, ext := range exts {
+        var fileCount int
+        for _, group := range byExtension[ext] {
+            fileCount += len(group.Files)
+        }
+        name := ext
+        if name == "" {
+            name = "(none)"
+        }
+        fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", name, len(byExtension[ext]), fileCount,
+            humanize.IBytes(uint64(wasted[ext])))
+    }
+}
+
+//Wasted space of duplicate groups with the same extension
+type ExtStats struct {
+    WastedBytes int64
+    FileCount int //files in duplicate groups
+}
+
+func (scan *Scan) WastedByExtension() map[string]ExtStats {
+    //Keyed like DuplicatesByExtension (".jpg", "" if none)
+    byExtension := make(map[string]ExtStats)
+    for ext, groups := range scan.DuplicatesByExtension() {
+        var stats ExtStats
+        for _, group := range groups {
+            stats.WastedBytes += group.WastedSize()
+            stats.FileCount += len(group.Files)
+        }
+        byExtension[ext] = stats
+    }
+    return byExtension
+}
+
+func FormatWastedByExtension(byExtension map[string]ExtStats, n int) string {
+    //Single line, most wasted space first, top n extensions only
+    exts := make([]string, 0, len(byExtension))
+    for ext := range byExtension {
+        exts = append(exts, ext)
+    }
+    sort.Slice(exts, func(i, j int) bool {
+        a, b := byExtension[exts[i]], byExtension[exts[j]]
+        if a.WastedBytes != b.WastedBytes {
+            return a.WastedBytes > b.WastedBytes
+        }
+        return exts[i] < exts[j]
+    })
+    if len(exts) > n {
+        exts = exts[:n]
+    }
+    var parts []string
+    for _, ext := range exts {
+        name := strings.TrimPrefix(ext, ".")
+        if name == "" {
+            name = "(none)"
+        }
+        parts = append(parts, fmt.Sprintf("%s: %s (%d files)", name,
+            humanize.IBytes(uint64(byExtension[ext].WastedBytes)), byExtension[ext].FileCount))
+    }
+    return strings.Join(parts, ", ")
+}
+
+func (scan *Scan) GroupsBySize() map[int64][]DuplicateGroup {
+    //Duplicate groups by file size
+    bySize := make(map[int64][]DuplicateGroup)
+    for _, group := range scan.DuplicateGroups() {
+        bySize[group.Size()] = append(bySize[group.Size()], group)
+    }
+    return bySize
+}
+
+func PrintSizeDistribution(w io.Writer, bySize map[int64][]DuplicateGroup) {
+    //One line per file size, largest first
+    sizes := make([]int64, 0, len(bySize))
+    for size := range bySize {
+        sizes = append(sizes, size)
+    }
+    sort.Slice(sizes, func(i, j int) bool {
+        return sizes[i] > sizes[j]
+    })
+    fmt.Fprintf(w, "size\tgroups\tfiles\twasted\n")
+    for _, size := range sizes {
+        var fileCount int
+        var wasted int64
+        for _, group := range bySize[size] {
+            fileCount += len(group.Files)
+            wasted += group.WastedSize()
+        }
+        fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", humanize.IBytes(uint64(size)),
+            len(bySize[size]), fileCount, humanize.IBytes(uint64(wasted)))
+    }
+}
+
+func PrintSameNameFiles(w io.Writer, byName map[string]FileList, filePath func(*File) string) {
+    //File name as header, files below, grouped by hash (content)
+    names := make([]string, 0, len(byName))
+    for name := range byName {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        files := byName[name]
+        annotation := "[SAME CONTENT]"
+        for _, file := range files {
+            if !file.IsHashed() || file.HashValue() != files[0].HashValue() {
+                annotation = "[DIFFERENT CONTENT]"
+                break
+            }
+        }
+        fmt.Fprintf(w, "%s %s\n", name, annotation)
+        for _, file := range files {
+            hash := file.HashValue()
+            if hash == "" {
+                hash = "-" //not hashed
+            }
+            fmt.Fprintf(w, "    %s  %s\n", hash, filePath(file))
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
+
+func PrintDuplicateHashes(w io.Writer, groups []DuplicateGroup) {
+    //One hash per duplicate group, in given order (-sort-groups-by)
+    for _, group := range groups {
+        fmt.Fprintf(w, "%s\n", group.Hash)
+    }
+}
+
+func (scan *Scan) PrintKeptFiles(w io.Writer, groups []DuplicateGroup, filePath func(*File) string) {
+    //File that would be kept, one per duplicate group
+    for _, group := range groups {
+        fmt.Fprintf(w, "%s\n", filePath(scan.keptFile(group.Files)))
+    }
+}
+
+func PrintCompact(w io.Writer, groups []DuplicateGroup, filePath func(*File) string, nullSeparated bool) {
+    //One line per duplicate group: hash, number of files, first file
+    //Lines are terminated by NUL if paths may contain newlines
+    terminator := "\n"
+    if nullSeparated {
+        terminator = "\x00"
+    }
+    for _, group := range groups {
+        fmt.Fprintf(w, "%s\t%d\t%s%s", group.Hash, len(group.Files), filePath(group.Files[0]), terminator)
+    }
+}
+
+func PrintDuplicateGroups(w io.Writer, groups []DuplicateGroup, filePath func(*File) string, showGroupNumber, showFileNumber bool) {
+    //One file per line, groups separated by an empty line
+    for i, group := range groups {
+        if showGroupNumber {
+            fmt.Fprintf(w, "=== Group %d/%d: hash %s (%d files, %s wasted) ===\n",
+                i + 1, len(groups), group.Hash, len(group.Files),
+                humanize.IBytes(uint64(group.WastedSize())))
+        }
+        inodeCount := make(map[uint64]int) //hardlinks (-include-hardlinks)
+        for _, file := range group.Files {
+            if file.HasInode() {
+                inodeCount[file.Inum]++
+            }
+        }
+        for j, file := range group.Files {
+            if showFileNumber {
+                fmt.Fprintf(w, "[%d/%d] ", j + 1, len(group.Files))
+            }
+            if file.HasInode() && inodeCount[file.Inum] > 1 {
+                fmt.Fprintf(w, "%s [hardlink]\n", filePath(file))
+                continue
+            }
+            fmt.Fprintf(w, "%s\n", filePath(file))
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
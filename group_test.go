@@ -0,0 +1,467 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "sort"
+    "bytes"
+    "runtime"
+    "strconv"
+    "strings"
+    "testing"
+    "reflect"
+    "path/filepath"
+)
+
+func TestIncludeHardlinks(t *testing.T) {
+    tests := []struct {
+        name string
+        files map[string]string
+        links map[string]string //link -> existing file
+        groups int //duplicate groups
+        listedGroups int //groups including hardlinks
+        listedFiles int //files listed including hardlinks
+        hardlinks int //files marked as hardlink
+    }{
+        {"hardlinks only", map[string]string{"a": "same"},
+            map[string]string{"a2": "a"}, 0, 1, 2, 2},
+        {"hardlink and copy", map[string]string{"a": "same", "b": "same"},
+            map[string]string{"a2": "a"}, 1, 1, 3, 2},
+        {"copies only", map[string]string{"a": "same", "b": "same"},
+            nil, 1, 1, 2, 0},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, test.files)
+            for link, file := range test.links {
+                if err := os.Link(filepath.Join(dir, file), filepath.Join(dir, link)); err != nil {
+                    t.Skip(err)
+                }
+            }
+            scan := scanTestDir(t, dir)
+            if groups := len(scan.DuplicatesMap()); groups != test.groups {
+                t.Errorf("%d duplicate groups, expected %d", groups, test.groups)
+            }
+
+            //Listing (-include-hardlinks)
+            groups := scan.DuplicateGroupsWithHardlinks()
+            if len(groups) != test.listedGroups {
+                t.Fatalf("%d groups listed, expected %d", len(groups), test.listedGroups)
+            }
+            var listedFiles int
+            for _, group := range groups {
+                listedFiles += len(group.Files)
+            }
+            if listedFiles != test.listedFiles {
+                t.Errorf("%d files listed, expected %d", listedFiles, test.listedFiles)
+            }
+            var buf bytes.Buffer
+            PrintDuplicateGroups(&buf, groups, func(file *File) string { return file.Path }, false, false)
+            if hardlinks := strings.Count(buf.String(), "[hardlink]"); hardlinks != test.hardlinks {
+                t.Errorf("%d files marked as hardlink, expected %d:\n%s", hardlinks, test.hardlinks, buf.String())
+            }
+
+            //Deleting duplicates leaves one copy of the data, hardlinks are not duplicates
+            scan.DeleteDuplicates(func(file *File) string { return file.Path })
+            inodes := make(map[uint64]bool)
+            for name := range test.files {
+                fi, err := os.Stat(filepath.Join(dir, name))
+                if err == nil {
+                    inodes[fileInode(fi)] = true
+                }
+            }
+            for name := range test.links {
+                fi, err := os.Stat(filepath.Join(dir, name))
+                if err != nil {
+                    t.Errorf("Hardlink deleted: %s", name)
+                    continue
+                }
+                inodes[fileInode(fi)] = true
+            }
+            if len(inodes) != 1 {
+                t.Errorf("%d copies left, expected 1", len(inodes))
+            }
+        })
+    }
+}
+
+func TestGroupListingOrder(t *testing.T) {
+    //Hashes, kept files and compact lines follow the group order, same output every run
+    dir := t.TempDir()
+    files := make(map[string]string)
+    for i := 0; i < 10; i++ {
+        for j := 0; j <= i % 3 + 1; j++ {
+            files[fmt.Sprintf("%d/%d", i, j)] = strings.Repeat("x", i + 1)
+        }
+    }
+    writeTestFiles(t, dir, files)
+    filePath := func(file *File) string { return file.Path }
+    for _, by := range []string{"path", "size", "count", "hash"} {
+        t.Run(by, func(t *testing.T) {
+            var outputs []string
+            var groups []DuplicateGroup
+            for run := 0; run < 2; run++ {
+                scan := scanTestDir(t, dir)
+                var err error
+                groups, err = SortDuplicateGroups(scan.DuplicateGroups(), by, false)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                var buf bytes.Buffer
+                PrintDuplicateHashes(&buf, groups)
+                scan.PrintKeptFiles(&buf, groups, filePath)
+                PrintCompact(&buf, groups, filePath, false)
+                outputs = append(outputs, buf.String())
+            }
+            if outputs[0] != outputs[1] {
+                t.Errorf("Output differs between runs:\n%s\n%s", outputs[0], outputs[1])
+            }
+
+            lines := strings.Split(outputs[0], "\n")
+            for i, group := range groups {
+                if lines[i] != group.Hash {
+                    t.Errorf("Hash %d: %s, expected %s", i + 1, lines[i], group.Hash)
+                }
+                compact := lines[2 * len(groups) + i]
+                if !strings.HasPrefix(compact, group.Hash + "\t") {
+                    t.Errorf("Compact line %d: %s, expected hash %s", i + 1, compact, group.Hash)
+                }
+            }
+        })
+    }
+}
+
+func TestWastedSizeHardlinks(t *testing.T) {
+    tests := []struct {
+        name string
+        inodes []uint64 //0 if unknown
+        wasted int64
+    }{
+        {"copies", []uint64{1, 2, 3}, 20},
+        {"hardlinks only", []uint64{1, 1, 1}, 0},
+        {"hardlink and copy", []uint64{1, 1, 2}, 10},
+        {"no inodes", []uint64{0, 0}, 10},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var group DuplicateGroup
+            for i, inode := range test.inodes {
+                group.Files = append(group.Files, &File{Path: fmt.Sprint(i), Size: 10, Inum: inode})
+            }
+            if wasted := group.WastedSize(); wasted != test.wasted {
+                t.Errorf("Wasted %d B, expected %d B", wasted, test.wasted)
+            }
+        })
+    }
+}
+
+func TestNoInode(t *testing.T) {
+    //Inode number 0 (network share), files are not taken for hardlinks of each other
+    tests := []struct {
+        name string
+        inodes []uint64
+        files int //files in duplicate group
+    }{
+        {"network share", []uint64{0, 0, 0}, 3},
+        {"hardlinks", []uint64{7, 7, 8}, 2},
+        {"mixed", []uint64{0, 7, 7}, 2},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            scan := NewScan()
+            for i, inode := range test.inodes {
+                path := fmt.Sprintf(`\\server\share\%d`, i)
+                scan.Files[path] = &File{Path: path, Name: fmt.Sprint(i), Size: 4, MD5: "hash", Inum: inode}
+            }
+            scan.BuildHashFilesMap()
+            duplicates := scan.DuplicatesMap()
+            if len(duplicates) != 1 || len(duplicates["md5:hash"]) != test.files {
+                t.Fatalf("Duplicates %v, expected %d files", groupPaths(duplicates), test.files)
+            }
+
+            var buf bytes.Buffer
+            PrintDuplicateGroups(&buf, scan.DuplicateGroupsWithHardlinks(), func(file *File) string { return file.Path }, false, false)
+            var linked int
+            for _, inode := range test.inodes {
+                if inode == 7 {
+                    linked++
+                }
+            }
+            if hardlinks := strings.Count(buf.String(), "[hardlink]"); hardlinks != linked {
+                t.Errorf("%d files marked as hardlink, expected %d:\n%s", hardlinks, linked, buf.String())
+            }
+        })
+    }
+}
+
+func TestTopDuplicateGroups(t *testing.T) {
+    //Selected by wasted space, not by file count or file size alone
+    var groups []DuplicateGroup
+    var inode uint64
+    for _, g := range []struct {
+        hash string
+        size int64
+        count int
+    }{
+        {"big", 100, 2}, //wasted 100, largest files
+        {"many", 10, 20}, //wasted 190, most files
+        {"mid", 60, 3}, //wasted 120
+        {"small", 5, 4}, //wasted 15
+    } {
+        group := DuplicateGroup{Hash: g.hash}
+        for i := 0; i < g.count; i++ {
+            inode++
+            group.Files = append(group.Files, &File{Path: fmt.Sprintf("%s/%d", g.hash, i), Size: g.size, Inum: inode})
+        }
+        groups = append(groups, group)
+    }
+    tests := []struct {
+        n int
+        expected []string
+    }{
+        {1, []string{"many"}},
+        {2, []string{"many", "mid"}},
+        {3, []string{"many", "mid", "big"}},
+        {10, []string{"many", "mid", "big", "small"}},
+        {0, nil},
+    }
+    for _, test := range tests {
+        t.Run(fmt.Sprint(test.n), func(t *testing.T) {
+            var hashes []string
+            for _, group := range TopDuplicateGroups(groups, test.n) {
+                hashes = append(hashes, group.Hash)
+            }
+            if strings.Join(hashes, ",") != strings.Join(test.expected, ",") {
+                t.Errorf("Top groups %v, expected %v", hashes, test.expected)
+            }
+        })
+    }
+}
+
+func TestPrintDuplicateGroupsNumbers(t *testing.T) {
+    groups := []DuplicateGroup{
+        {Hash: "md5:aaa", Files: FileList{{Path: "a1", Size: 10}, {Path: "a2", Size: 10}}},
+        {Hash: "md5:bbb", Files: FileList{{Path: "b1", Size: 5}, {Path: "b2", Size: 5}, {Path: "b3", Size: 5}}},
+    }
+    tests := []struct {
+        name string
+        showGroupNumber bool
+        showFileNumber bool
+        expected string
+    }{
+        {"plain", false, false, "a1\na2\n\nb1\nb2\nb3\n\n"},
+        {"group number", true, false,
+            "=== Group 1/2: hash md5:aaa (2 files, 10 B wasted) ===\na1\na2\n\n" +
+            "=== Group 2/2: hash md5:bbb (3 files, 10 B wasted) ===\nb1\nb2\nb3\n\n"},
+        {"file number", false, true, "[1/2] a1\n[2/2] a2\n\n[1/3] b1\n[2/3] b2\n[3/3] b3\n\n"},
+        {"both", true, true,
+            "=== Group 1/2: hash md5:aaa (2 files, 10 B wasted) ===\n[1/2] a1\n[2/2] a2\n\n" +
+            "=== Group 2/2: hash md5:bbb (3 files, 10 B wasted) ===\n[1/3] b1\n[2/3] b2\n[3/3] b3\n\n"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var buf bytes.Buffer
+            PrintDuplicateGroups(&buf, groups, func(file *File) string { return file.Path }, test.showGroupNumber, test.showFileNumber)
+            if buf.String() != test.expected {
+                t.Errorf("Output:\n%s\nexpected:\n%s", buf.String(), test.expected)
+            }
+        })
+    }
+}
+
+func TestPrintCompact(t *testing.T) {
+    //Output parsed back: hash, number of files, first file
+    for _, nullSeparated := range []bool{false, true} {
+        t.Run(fmt.Sprintf("null separated %t", nullSeparated), func(t *testing.T) {
+            dir := t.TempDir()
+            files := map[string]string{"a1": "a", "a2": "a", "b1": "bb", "b2": "bb", "b3": "bb", "unique": "unique"}
+            if nullSeparated && runtime.GOOS != "windows" {
+                files["c\nnewline"], files["c2"] = "ccc", "ccc"
+            }
+            writeTestFiles(t, dir, files)
+            groups, err := SortDuplicateGroups(scanTestDir(t, dir).DuplicateGroups(), "path", false)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            var buf bytes.Buffer
+            PrintCompact(&buf, groups, func(file *File) string { return file.Path }, nullSeparated)
+            terminator := "\n"
+            if nullSeparated {
+                terminator = "\x00"
+            }
+            lines := strings.Split(strings.TrimSuffix(buf.String(), terminator), terminator)
+            if len(lines) != len(groups) {
+                t.Fatalf("%d lines, expected %d:\n%q", len(lines), len(groups), buf.String())
+            }
+            for i, line := range lines {
+                fields := strings.SplitN(line, "\t", 3)
+                if len(fields) != 3 {
+                    t.Fatalf("Line %q, expected 3 fields", line)
+                }
+                count, err := strconv.Atoi(fields[1])
+                if fields[0] != groups[i].Hash || err != nil || count != len(groups[i].Files) || fields[2] != groups[i].Files[0].Path {
+                    t.Errorf("Line %q, expected %s, %d files, %s", line, groups[i].Hash, len(groups[i].Files), groups[i].Files[0].Path)
+                }
+            }
+        })
+    }
+}
+
+func TestDuplicatesByExtension(t *testing.T) {
+    //Lowercase extension of first file, no extension under ""
+    scan := NewScan()
+    for path, hash := range map[string]string{
+        "a.JPG": "1", "b.jpg": "1", "c.jpg": "2", "d.jpg": "2",
+        "e.png": "3", "f.png": "3",
+        "README": "4", "README.old": "4",
+        "unique.jpg": "5",
+    } {
+        scan.Files[path] = &File{Path: path, Name: path, Size: 10, MD5: hash}
+    }
+    scan.BuildHashFilesMap()
+
+    byExtension := scan.DuplicatesByExtension()
+    hashes := make(map[string][]string)
+    for ext, groups := range byExtension {
+        for _, group := range groups {
+            hashes[ext] = append(hashes[ext], group.Hash)
+        }
+        sort.Strings(hashes[ext])
+    }
+    expected := map[string][]string{".jpg": {"md5:1", "md5:2"}, ".png": {"md5:3"}, "": {"md5:4"}}
+    if !reflect.DeepEqual(hashes, expected) {
+        t.Errorf("Groups by extension %v, expected %v", hashes, expected)
+    }
+}
+
+func TestGroupsBySize(t *testing.T) {
+    //Three sizes, two groups of the same size
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a1": "aaaa", "a2": "aaaa", "b1": "bbbb", "b2": "bbbb", "b3": "bbbb", //4 B
+        "c1": "cccccccc", "c2": "cccccccc", //8 B
+        "d1": "dd", "d2": "dd", //2 B
+        "unique": "uuuu",
+    })
+    scan := scanTestDir(t, dir)
+    bySize := scan.GroupsBySize()
+    counts := make(map[int64][]int) //files per group
+    for size, groups := range bySize {
+        for _, group := range groups {
+            counts[size] = append(counts[size], len(group.Files))
+            for _, file := range group.Files {
+                if file.Size != size {
+                    t.Errorf("File %s with %d B in %d B groups", file.Path, file.Size, size)
+                }
+            }
+        }
+        sort.Ints(counts[size])
+    }
+    expected := map[int64][]int{2: {2}, 4: {2, 3}, 8: {2}}
+    if !reflect.DeepEqual(counts, expected) {
+        t.Fatalf("Groups by size %v, expected %v", counts, expected)
+    }
+
+    var buf bytes.Buffer
+    PrintSizeDistribution(&buf, bySize)
+    table := "size\tgroups\tfiles\twasted\n8 B\t1\t2\t8 B\n4 B\t2\t5\t12 B\n2 B\t1\t2\t2 B\n"
+    if buf.String() != table {
+        t.Errorf("Table:\n%s\nexpected:\n%s", buf.String(), table)
+    }
+}
+
+func TestTopWastedSpace(t *testing.T) {
+    //20 groups, ties at the cut-off broken by hash
+    scan := NewScan()
+    for i := 0; i < 20; i++ {
+        for j := 0; j < 2; j++ {
+            path := fmt.Sprintf("%02d/%d", i, j)
+            scan.Files[path] = &File{Path: path, Name: path, Size: int64(i % 7 + 1) * 10, MD5: fmt.Sprintf("%02d", i)}
+        }
+    }
+    scan.BuildHashFilesMap()
+    expected := []string{"06", "13", "05", "12", "19", "04", "11", "18", "03", "10"}
+    for _, n := range []int{10, 0} {
+        var hashes []string
+        for _, group := range scan.TopWastedSpace(n) {
+            hashes = append(hashes, strings.TrimPrefix(group.Hash, "md5:"))
+        }
+        if !reflect.DeepEqual(hashes, expected) {
+            t.Errorf("Top %d groups %v, expected %v", n, hashes, expected)
+        }
+    }
+}
+
+func TestSameNameFiles(t *testing.T) {
+    //Same name in several directories, annotated by content
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a/notes.txt": "v1", "b/notes.txt": "v2", //same name, different content
+        "a/photo.jpg": "photo", "c/photo.jpg": "photo", //same name and content
+        "a/unique.txt": "v1", //same content, other name
+    })
+    scan := scanTestDir(t, dir)
+    byName := scan.SameNameFiles()
+    names := make(map[string]int)
+    for name, files := range byName {
+        names[name] = len(files)
+    }
+    if expected := map[string]int{"notes.txt": 2, "photo.jpg": 2}; !reflect.DeepEqual(names, expected) {
+        t.Fatalf("Names %v, expected %v", names, expected)
+    }
+
+    var buf bytes.Buffer
+    PrintSameNameFiles(&buf, byName, func(file *File) string { return file.Path })
+    headers := make(map[string]bool)
+    for _, line := range strings.Split(buf.String(), "\n") {
+        if line != "" && !strings.HasPrefix(line, " ") {
+            headers[line] = true
+        }
+    }
+    expected := map[string]bool{"notes.txt [DIFFERENT CONTENT]": true, "photo.jpg [SAME CONTENT]": true}
+    if !reflect.DeepEqual(headers, expected) {
+        t.Errorf("Headers %v, expected %v:\n%s", headers, expected, buf.String())
+    }
+    if !strings.Contains(buf.String(), filepath.Join(dir, "b", "notes.txt")) {
+        t.Errorf("File missing in output:\n%s", buf.String())
+    }
+}
+
+func TestWastedByExtension(t *testing.T) {
+    scan := NewScan()
+    var inode uint64
+    for _, f := range []struct {
+        path string
+        size int64
+        hash string
+    }{
+        {"a.jpg", 100, "1"}, {"b.JPG", 100, "1"}, {"c.jpg", 100, "1"}, //200 B wasted
+        {"d.jpg", 50, "2"}, {"e.jpg", 50, "2"}, //50 B wasted
+        {"f.mp4", 1000, "3"}, {"g.mp4", 1000, "3"}, //1000 B wasted
+        {"h", 10, "4"}, {"i", 10, "4"}, //10 B wasted
+        {"unique.png", 500, "5"},
+    } {
+        inode++
+        scan.Files[f.path] = &File{Path: f.path, Name: f.path, Size: f.size, MD5: f.hash, Inum: inode}
+    }
+    scan.BuildHashFilesMap()
+    byExtension := scan.WastedByExtension()
+    expected := map[string]ExtStats{
+        ".jpg": {WastedBytes: 250, FileCount: 5},
+        ".mp4": {WastedBytes: 1000, FileCount: 2},
+        "": {WastedBytes: 10, FileCount: 2},
+    }
+    if !reflect.DeepEqual(byExtension, expected) {
+        t.Fatalf("Wasted by extension %+v, expected %+v", byExtension, expected)
+    }
+    summary := "mp4: 1000 B (2 files), jpg: 250 B (5 files)"
+    if line := FormatWastedByExtension(byExtension, 2); line != summary {
+        t.Errorf("Summary %q, expected %q", line, summary)
+    }
+    summary += ", (none): 10 B (2 files)"
+    if line := FormatWastedByExtension(byExtension, 10); line != summary {
+        t.Errorf("Summary %q, expected %q", line, summary)
+    }
+}
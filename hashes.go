@@ -0,0 +1,50 @@
+package main
+
+import (
+    "hash"
+    "sort"
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+
+    "golang.org/x/crypto/blake2b"
+    "github.com/zeebo/blake3"
+)
+
+//Hash functions by algorithm name (-hash-algorithm)
+//A factory must return a new, independent hash.Hash for every call:
+//Write never returns an error, Sum appends the hash without changing the state,
+//Size is the length of the hash in bytes (hex encoded in map and sum files)
+//Algorithms without a File field (sha512, registered ones) are stored in File.Hashes
+var HashRegistry = map[string]func() hash.Hash{
+    "md5": md5.New,
+    "sha1": sha1.New,
+    "sha256": sha256.New,
+    "sha512": sha512.New,
+    "blake2b": func() hash.Hash {
+        //BLAKE2b-512, compatible with b2sum
+        h, _ := blake2b.New512(nil)
+        return h
+    },
+    "blake3": func() hash.Hash {
+        //BLAKE3-256, compatible with b3sum
+        return blake3.New()
+    },
+}
+
+func RegisterHash(name string, factory func() hash.Hash) {
+    //Add or replace hash algorithm, call before scanning (init function)
+    //Registry is not protected by a mutex
+    HashRegistry[name] = factory
+}
+
+func HashAlgorithms() []string {
+    //Registered algorithm names, sorted
+    var names []string
+    for name := range HashRegistry {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
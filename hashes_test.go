@@ -0,0 +1,89 @@
+package main
+
+import (
+    "os"
+    "flag"
+    "hash"
+    "sort"
+    "testing"
+    "math/rand"
+    "hash/crc32"
+    "path/filepath"
+)
+
+//Size of the file hashed in benchmarks, like -bench-file-size 1073741824 (1 GiB)
+var benchFileSize = flag.Int64("bench-file-size", 64 << 20, "size of file hashed in benchmarks")
+
+func TestHashAlgorithms(t *testing.T) {
+    //CRC32 as an example of a registered algorithm (not built in)
+    RegisterHash("test-crc32", func() hash.Hash { return crc32.NewIEEE() })
+    t.Cleanup(func() { delete(HashRegistry, "test-crc32") })
+
+    algorithms := HashAlgorithms()
+    if !sort.StringsAreSorted(algorithms) {
+        t.Errorf("Algorithms not sorted: %v", algorithms)
+    }
+    for _, algorithm := range []string{"md5", "sha1", "sha256", "sha512", "blake2b", "blake3", "test-crc32"} {
+        if !contains(algorithms, algorithm) {
+            t.Errorf("Algorithm not registered: %s", algorithm)
+        }
+    }
+
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "other"})
+    for _, algorithm := range algorithms {
+        t.Run(algorithm, func(t *testing.T) {
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.HashAlgorithm = algorithm
+            runScan(t, scan)
+            if len(scan.DuplicatesMap()) != 1 {
+                t.Fatalf("%d duplicate groups, expected 1", len(scan.DuplicatesMap()))
+            }
+            file := scan.Files[filepath.Join(dir, "a")]
+            hash := file.HashOf(algorithm)
+            if len(hash) != HashRegistry[algorithm]().Size() * 2 {
+                t.Errorf("Hash %s has wrong length", hash)
+            }
+            if file.HashValue() != algorithm + ":" + hash {
+                t.Errorf("Hash value %s, expected %s:%s", file.HashValue(), algorithm, hash)
+            }
+        })
+    }
+}
+
+func writeBenchFile(b *testing.B) string {
+    //Random data, so that compression or sparse files don't matter
+    b.Helper()
+    path := filepath.Join(b.TempDir(), "data")
+    f, err := os.Create(path)
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer f.Close()
+    buf := make([]byte, 1 << 20)
+    rng := rand.New(rand.NewSource(1))
+    for written := int64(0); written < *benchFileSize; written += int64(len(buf)) {
+        rng.Read(buf)
+        if _, err := f.Write(buf[:min(int64(len(buf)), *benchFileSize - written)]); err != nil {
+            b.Fatal(err)
+        }
+    }
+    return path
+}
+
+func BenchmarkHashAlgorithms(b *testing.B) {
+    //Throughput (MB/s) of hash algorithms on the same file (page cache)
+    path := writeBenchFile(b)
+    for _, algorithm := range []string{"md5", "sha256", "blake2b", "blake3"} {
+        b.Run(algorithm, func(b *testing.B) {
+            b.SetBytes(*benchFileSize)
+            for i := 0; i < b.N; i++ {
+                file := &File{Path: path, Size: *benchFileSize}
+                if err := file.HashAll([]string{algorithm}); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
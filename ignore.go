@@ -0,0 +1,67 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "strings"
+    "path/filepath"
+
+    ignore "github.com/sabhiram/go-gitignore"
+)
+
+const ignoreFileName = ".dupeignore"
+
+type ignoreMatcher struct {
+    dir string
+    gitIgnore *ignore.GitIgnore
+}
+
+type ignoreList struct {
+    matchers []ignoreMatcher
+}
+
+func newIgnoreList(root string) *ignoreList {
+    list := &ignoreList{}
+
+    //Global ignore file applies to whole scan path
+    if home, err := os.UserHomeDir(); err == nil {
+        list.loadFile(root, filepath.Join(home, ignoreFileName))
+    }
+
+    return list
+}
+
+func (list *ignoreList) load(dir string) {
+    //Ignore file in directory applies to directory and subdirectories
+    list.loadFile(dir, filepath.Join(dir, ignoreFileName))
+}
+
+func (list *ignoreList) loadFile(dir string, file string) {
+    if _, err := os.Stat(file); err != nil {
+        return
+    }
+    gitIgnore, err := ignore.CompileIgnoreFile(file)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading ignore file %s: %s\n", file, err)
+        return
+    }
+    fmt.Fprintf(verboseIO, "Using ignore file: %s\n", file)
+    list.matchers = append(list.matchers, ignoreMatcher{dir, gitIgnore})
+}
+
+func (list *ignoreList) matches(path string, isDir bool) bool {
+    for _, matcher := range list.matchers {
+        //Patterns are relative to the directory of the ignore file
+        rel, err := filepath.Rel(matcher.dir, path)
+        if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+            continue
+        }
+        if isDir {
+            rel += "/" //match patterns like "dir/"
+        }
+        if matcher.gitIgnore.MatchesPath(rel) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "path/filepath"
+)
+
+func TestIgnoreFiles(t *testing.T) {
+    //Global ignore file in home directory, local ones apply to their directory
+    home := t.TempDir()
+    t.Setenv("HOME", home)
+    t.Setenv("USERPROFILE", home)
+    writeTestFiles(t, home, map[string]string{ignoreFileName: "*.bak\n"})
+
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        ignoreFileName: "*.tmp\ncache/\n",
+        "a.txt": "a", "b.tmp": "b", "cache/c.txt": "c", "old.bak": "old",
+        "local.txt": "local",
+        "sub/" + ignoreFileName: "local.txt\n",
+        "sub/local.txt": "local", "sub/d.txt": "d", "sub/e.tmp": "e",
+    })
+    for _, useIgnoreFiles := range []bool{true, false} {
+        scan := NewScan()
+        scan.Paths = []string{dir}
+        scan.UseIgnoreFiles = useIgnoreFiles
+        runScan(t, scan)
+
+        var names []string
+        for _, file := range scan.Files.Values() {
+            rel, _ := filepath.Rel(dir, file.Path)
+            names = append(names, filepath.ToSlash(rel))
+        }
+        expected := []string{"a.txt", "local.txt", "sub/d.txt"} //ignore files not scanned
+        if !useIgnoreFiles {
+            expected = []string{
+                ignoreFileName, "a.txt", "b.tmp", "cache/c.txt", "local.txt", "old.bak",
+                "sub/" + ignoreFileName, "sub/d.txt", "sub/e.tmp", "sub/local.txt",
+            }
+        }
+        if !reflect.DeepEqual(names, expected) {
+            t.Errorf("Ignore files %t: scanned %v, expected %v", useIgnoreFiles, names, expected)
+        }
+    }
+}
@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || windows)
+
+package main
+
+func isFileLocked(path string) (bool, error) {
+    return false, nil
+}
+
+func openError(err error) error {
+    return err
+}
+
+func processRunning(pid int) bool {
+    //Unknown, assume it's running
+    return true
+}
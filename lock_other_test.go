@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || windows)
+
+package main
+
+import (
+    "testing"
+)
+
+func lockFile(t *testing.T, path string) {
+    t.Skip("File locks not supported")
+}
@@ -0,0 +1,44 @@
+//go:build darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd
+
+package main
+
+import (
+    "os"
+    "errors"
+    "syscall"
+)
+
+func isFileLocked(path string) (bool, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return false, err
+    }
+    defer f.Close()
+
+    //Try to get an exclusive lock without waiting
+    //Fails if another process holds a (shared or exclusive) lock
+    fd := int(f.Fd())
+    if err := syscall.Flock(fd, syscall.LOCK_EX | syscall.LOCK_NB); err != nil {
+        if err == syscall.EWOULDBLOCK {
+            return true, nil
+        }
+        return false, err
+    }
+    syscall.Flock(fd, syscall.LOCK_UN)
+
+    return false, nil
+}
+
+func openError(err error) error {
+    return err
+}
+
+func processRunning(pid int) bool {
+    //Signal 0 checks if process exists, EPERM means it exists (other user)
+    p, err := os.FindProcess(pid)
+    if err != nil {
+        return false
+    }
+    err = p.Signal(syscall.Signal(0))
+    return err == nil || errors.Is(err, syscall.EPERM)
+}
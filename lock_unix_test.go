@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd
+
+package main
+
+import (
+    "os"
+    "syscall"
+    "testing"
+)
+
+//lockFile holds a lock on path until the test ends, like another process would
+func lockFile(t *testing.T, path string) {
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { f.Close() })
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX | syscall.LOCK_NB); err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+    "os"
+    "errors"
+    "syscall"
+)
+
+//ERROR_SHARING_VIOLATION, file opened exclusively by another process
+const errorSharingViolation syscall.Errno = 32
+
+func isFileLocked(path string) (bool, error) {
+    //Locked files can't be opened, see openError()
+    return false, nil
+}
+
+func openError(err error) error {
+    if errors.Is(err, errorSharingViolation) {
+        return ErrFileLocked
+    }
+    return err
+}
+
+func processRunning(pid int) bool {
+    //Process can only be opened if it exists
+    p, err := os.FindProcess(pid)
+    if err != nil {
+        return false
+    }
+    p.Release()
+    return true
+}
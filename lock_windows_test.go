@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+    "syscall"
+    "testing"
+)
+
+//lockFile opens path without sharing until the test ends, like another process would
+func lockFile(t *testing.T, path string) {
+    name, err := syscall.UTF16PtrFromString(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    h, err := syscall.CreateFile(name, syscall.GENERIC_READ, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { syscall.CloseHandle(h) })
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+    "syscall"
+)
+
+func adviseSequential(data []byte) {
+    //Hint for kernel, file is read sequentially (read-ahead)
+    syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+func adviseSequential(data []byte) {
+}
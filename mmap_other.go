@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd)
+
+package main
+
+import (
+    "os"
+    "errors"
+)
+
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+    return nil, errors.New("mmap not supported")
+}
+
+func munmapFile(data []byte) error {
+    return nil
+}
@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd
+
+package main
+
+import (
+    "os"
+    "errors"
+    "syscall"
+)
+
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+    if size <= 0 || int64(int(size)) != size {
+        return nil, errors.New("Invalid size for mmap")
+    }
+    data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+    if err != nil {
+        return nil, err
+    }
+    adviseSequential(data)
+    return data, nil
+}
+
+func munmapFile(data []byte) error {
+    return syscall.Munmap(data)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "bufio"
+    "bytes"
+    "errors"
+    "runtime"
+    "strings"
+    "strconv"
+    "os/user"
+    "path/filepath"
+)
+
+func ReadPaths(r io.Reader, nulSeparated bool) ([]string, error) {
+    var paths []string
+
+    //Split input into lines or NUL-separated entries (find -print0)
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+    if nulSeparated {
+        scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+            if i := bytes.IndexByte(data, 0); i >= 0 {
+                return i + 1, data[:i], nil
+            }
+            if atEOF && len(data) > 0 {
+                return len(data), data, nil
+            }
+            return 0, nil, nil
+        })
+    }
+
+    //Collect paths, skip empty entries
+    for scanner.Scan() {
+        path := scanner.Text()
+        if !nulSeparated {
+            path = strings.TrimSuffix(path, "\r")
+        }
+        if path == "" {
+            continue
+        }
+        paths = append(paths, path)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    return paths, nil
+}
+
+func ReadFileList(file string) ([]string, error) {
+    //File paths, one per line (like output of find or locate)
+    //Trailing whitespace is removed, ~ is expanded to the home directory
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    lines, err := ReadPaths(f, false)
+    if err != nil {
+        return nil, err
+    }
+
+    var paths []string
+    for _, path := range lines {
+        path = strings.TrimRight(path, " \t")
+        if path == "" {
+            continue
+        }
+        if path == "~" || strings.HasPrefix(path, "~/") {
+            home, err := os.UserHomeDir()
+            if err != nil {
+                return nil, err
+            }
+            path = filepath.Join(home, path[1:])
+        }
+        paths = append(paths, path)
+    }
+
+    return paths, nil
+}
+
+func PathContains(root, target string) bool {
+    //Absolute paths with symlinks resolved (as far as they exist)
+    root = resolvePath(root)
+    target = resolvePath(target)
+
+    //Target is inside root unless relative path leads outside
+    rel, err := filepath.Rel(root, target)
+    if err != nil {
+        return false
+    }
+    if rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+        return false
+    }
+    return true
+}
+
+func resolvePath(path string) string {
+    if abs, err := filepath.Abs(path); err == nil {
+        path = abs
+    }
+
+    //Resolve symlinks, target file may not exist yet (export file)
+    if resolved, err := filepath.EvalSymlinks(path); err == nil {
+        return resolved
+    }
+    dir, name := filepath.Split(path)
+    if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+        return filepath.Join(resolved, name)
+    }
+    return path
+}
+
+func ExpandPaths(args []string) ([]string, error) {
+    var paths []string
+
+    //Expand wildcards (for shells that don't do it, like cmd on Windows)
+    for _, arg := range args {
+        if !strings.ContainsAny(arg, "*?[") {
+            paths = append(paths, arg) //not a pattern
+            continue
+        }
+        matches, err := filepath.Glob(arg)
+        if err != nil {
+            return nil, fmt.Errorf("Invalid pattern %s: %s", arg, err)
+        }
+        if len(matches) == 0 {
+            //Like the shell, keep going if a pattern doesn't match
+            fmt.Fprintf(os.Stderr, "Warning: no match for pattern: %s\n", arg)
+            continue
+        }
+        paths = append(paths, matches...)
+    }
+
+    return paths, nil
+}
+
+//No numeric owner ids on this platform (Windows uses SIDs)
+var ErrOwnerUnsupported = errors.New("File owner filters are not supported on this platform")
+
+func LookupUID(name string) (uint32, error) {
+    //User name or numeric id
+    if runtime.GOOS == "windows" {
+        return 0, ErrOwnerUnsupported
+    }
+    u, err := user.Lookup(name)
+    if err != nil {
+        if id, parseErr := strconv.ParseUint(name, 10, 32); parseErr == nil {
+            return uint32(id), nil
+        }
+        return 0, err
+    }
+    id, err := strconv.ParseUint(u.Uid, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("%w: user id %s for %s", ErrOwnerUnsupported, u.Uid, name)
+    }
+    return uint32(id), nil
+}
+
+func LookupGID(name string) (uint32, error) {
+    //Group name or numeric id
+    if runtime.GOOS == "windows" {
+        return 0, ErrOwnerUnsupported
+    }
+    g, err := user.LookupGroup(name)
+    if err != nil {
+        if id, parseErr := strconv.ParseUint(name, 10, 32); parseErr == nil {
+            return uint32(id), nil
+        }
+        return 0, err
+    }
+    id, err := strconv.ParseUint(g.Gid, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("%w: group id %s for %s", ErrOwnerUnsupported, g.Gid, name)
+    }
+    return uint32(id), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "os"
+    "bytes"
+    "errors"
+    "reflect"
+    "runtime"
+    "strings"
+    "testing"
+    "path/filepath"
+)
+
+func TestLookupOwner(t *testing.T) {
+    tests := []struct {
+        name string
+        lookup func(string) (uint32, error)
+        value string
+        id uint32
+        valid bool
+    }{
+        {"user name", LookupUID, "root", 0, true},
+        {"user id", LookupUID, "0", 0, true},
+        {"unknown user", LookupUID, "no-such-user-dupefinder", 0, false},
+        {"group id", LookupGID, "0", 0, true},
+        {"unknown group", LookupGID, "no-such-group-dupefinder", 0, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            id, err := test.lookup(test.value)
+            if runtime.GOOS == "windows" {
+                //Filter ignored with a warning, not an unknown user
+                if !errors.Is(err, ErrOwnerUnsupported) {
+                    t.Errorf("Error %v, expected %v", err, ErrOwnerUnsupported)
+                }
+                return
+            }
+            if (err == nil) != test.valid || errors.Is(err, ErrOwnerUnsupported) {
+                t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+            }
+            if id != test.id {
+                t.Errorf("Id %d, expected %d", id, test.id)
+            }
+        })
+    }
+}
+
+func TestReadPaths(t *testing.T) {
+    tests := []struct {
+        name string
+        input string
+        nulSeparated bool
+        expected []string
+    }{
+        {"lines", "a\nsub/b\n\nc d\n", false, []string{"a", "sub/b", "c d"}},
+        {"windows line endings", "a\r\nb\r\n", false, []string{"a", "b"}},
+        {"no final newline", "a\nb", false, []string{"a", "b"}},
+        {"nul separated", "a\x00new\nline\x00\x00b", true, []string{"a", "new\nline", "b"}},
+        {"empty", "", false, nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            paths, err := ReadPaths(bytes.NewBufferString(test.input), test.nulSeparated)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(paths, test.expected) {
+                t.Errorf("Paths %q, expected %q", paths, test.expected)
+            }
+        })
+    }
+
+    //Files are scanned directly, directories are walked
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "sub/b": "same", "other/c": "same"})
+    var input bytes.Buffer
+    for _, path := range []string{filepath.Join(dir, "a"), filepath.Join(dir, "sub")} {
+        input.WriteString(path + "\x00")
+    }
+    paths, err := ReadPaths(&input, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    scan := NewScan()
+    scan.Paths = paths[1:]
+    scan.DirectFiles = paths[:1]
+    runScan(t, scan)
+    expected := [][]string{{filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+}
+
+func TestPathContains(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"data/a": "a", "other/b": "b"})
+    linked := filepath.Join(dir, "link")
+    if err := os.Symlink(filepath.Join(dir, "data"), linked); err != nil {
+        linked = "" //symlinks not supported
+    }
+    t.Chdir(dir)
+
+    tests := []struct {
+        name string
+        root string
+        target string
+        inside bool
+    }{
+        {"absolute", filepath.Join(dir, "data"), filepath.Join(dir, "data", "map.json"), true},
+        {"root itself", filepath.Join(dir, "data"), filepath.Join(dir, "data"), true},
+        {"sibling", filepath.Join(dir, "data"), filepath.Join(dir, "other", "map.json"), false},
+        {"common prefix", filepath.Join(dir, "data"), filepath.Join(dir, "data2", "map.json"), false},
+        {"relative", "data", filepath.Join("data", "sub", "map.json"), true},
+        {"relative and absolute", "data", filepath.Join(dir, "data", "map.json"), true},
+        {"relative outside", "data", filepath.Join("data", "..", "map.json"), false},
+        {"symlinked root", linked, filepath.Join(dir, "data", "map.json"), true},
+        {"symlinked target", filepath.Join(dir, "data"), filepath.Join(linked, "map.json"), true},
+        {"symlink outside", filepath.Join(dir, "other"), filepath.Join(linked, "map.json"), false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if linked == "" && strings.Contains(test.name, "symlink") {
+                t.Skip("Symlinks not supported")
+            }
+            if inside := PathContains(test.root, test.target); inside != test.inside {
+                t.Errorf("%s in %s: %t, expected %t", test.target, test.root, inside, test.inside)
+            }
+        })
+    }
+}
+
+func TestExpandPaths(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "users/alice/Downloads/a": "a", "users/bob/Downloads/b": "b", "users/carol/Documents/c": "c",
+    })
+    tests := []struct {
+        name string
+        args []string
+        expected []string
+        valid bool
+    }{
+        {"pattern", []string{filepath.Join(dir, "users", "*", "Downloads")}, []string{
+            filepath.Join(dir, "users", "alice", "Downloads"), filepath.Join(dir, "users", "bob", "Downloads"),
+        }, true},
+        {"character class", []string{filepath.Join(dir, "users", "[ab]*")}, []string{
+            filepath.Join(dir, "users", "alice"), filepath.Join(dir, "users", "bob"),
+        }, true},
+        {"plain path kept", []string{filepath.Join(dir, "missing")}, []string{filepath.Join(dir, "missing")}, true},
+        {"no match", []string{filepath.Join(dir, "users", "*", "Music"), filepath.Join(dir, "users")}, []string{
+            filepath.Join(dir, "users"),
+        }, true},
+        {"invalid pattern", []string{filepath.Join(dir, "[")}, nil, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            paths, err := ExpandPaths(test.args)
+            if (err == nil) != test.valid {
+                t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+            }
+            if !reflect.DeepEqual(paths, test.expected) {
+                t.Errorf("Paths %v, expected %v", paths, test.expected)
+            }
+        })
+    }
+}
+
+func TestReadFileList(t *testing.T) {
+    //Trailing whitespace removed, ~ expanded, missing files kept (checked when scanning)
+    dir := t.TempDir()
+    t.Setenv("HOME", dir)
+    t.Setenv("USERPROFILE", dir)
+    list := filepath.Join(dir, "list.txt")
+    writeTestFiles(t, dir, map[string]string{"list.txt": "/data/a  \n~/b\t\n\n/data/c d\n"})
+    paths, err := ReadFileList(list)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []string{"/data/a", filepath.Join(dir, "b"), "/data/c d"}
+    if !reflect.DeepEqual(paths, expected) {
+        t.Errorf("Paths %q, expected %q", paths, expected)
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+    "io/fs"
+)
+
+func CreatePIDFile(path string) error {
+    //Create PID file exclusively, replace it if process is gone (stale)
+    for attempt := 0; attempt < 2; attempt++ {
+        f, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+        if err == nil {
+            _, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+            if closeErr := f.Close(); err == nil {
+                err = closeErr
+            }
+            return err
+        }
+        if !errors.Is(err, fs.ErrExist) {
+            return err
+        }
+
+        //PID file exists, check if other process is still running
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+        if err == nil && processRunning(pid) {
+            return fmt.Errorf("Already running (PID %d in %s)", pid, path)
+        }
+        fmt.Fprintf(verboseIO, "Removing stale PID file: %s\n", path)
+        if err := os.Remove(path); err != nil {
+            return err
+        }
+    }
+
+    return fmt.Errorf("Could not create PID file: %s", path)
+}
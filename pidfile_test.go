@@ -0,0 +1,74 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+    "os/exec"
+    "testing"
+    "path/filepath"
+)
+
+func TestCreatePIDFile(t *testing.T) {
+    tests := []struct {
+        name string
+        existing string //PID file content, empty if none
+        valid bool
+    }{
+        {"new", "", true},
+        {"stale", "999999999\n", true}, //above any pid_max
+        {"garbage", "not a pid\n", true},
+        {"active", fmt.Sprintf("%d\n", os.Getpid()), false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if test.name == "stale" && processRunning(999999999) {
+                t.Skip("Running processes unknown on this platform")
+            }
+            path := filepath.Join(t.TempDir(), "dupefinder.pid")
+            if test.existing != "" {
+                if err := os.WriteFile(path, []byte(test.existing), 0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            err := CreatePIDFile(path)
+            if (err == nil) != test.valid {
+                t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+            }
+            data, err := os.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            expected := fmt.Sprintf("%d\n", os.Getpid())
+            if !test.valid {
+                expected = test.existing //left alone
+            }
+            if string(data) != expected {
+                t.Errorf("PID file %q, expected %q", data, expected)
+            }
+        })
+    }
+}
+
+func TestExitRemovesPIDFile(t *testing.T) {
+    //exit() in a child process, deferred functions don't run on exit
+    if path := os.Getenv("DUPEFINDER_TEST_PID_FILE"); path != "" {
+        if err := CreatePIDFile(path); err != nil {
+            os.Exit(2)
+        }
+        atExit = append(atExit, func() { os.Remove(path) })
+        exit(1)
+    }
+
+    path := filepath.Join(t.TempDir(), "dupefinder.pid")
+    cmd := exec.Command(os.Args[0], "-test.run=^TestExitRemovesPIDFile$")
+    cmd.Env = append(os.Environ(), "DUPEFINDER_TEST_PID_FILE=" + path)
+    err := cmd.Run()
+    var exitErr *exec.ExitError
+    if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+        t.Fatalf("Exit status %v, expected 1", err)
+    }
+    if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("PID file not removed on exit: %v", err)
+    }
+}
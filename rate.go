@@ -0,0 +1,70 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+//Throughput over the last few seconds (rolling average)
+//Hashing speed changes over time (disk cache, small and large files)
+type RollingRate struct {
+    Window time.Duration
+    samples [64]rateSample //circular buffer
+    next int
+    start time.Time //first sample
+}
+
+type rateSample struct {
+    t time.Time
+    n int64
+}
+
+func NewRollingRate(window time.Duration) *RollingRate {
+    return &RollingRate{Window: window}
+}
+
+func (r *RollingRate) Add(n int64) {
+    r.add(time.Now(), n)
+}
+
+func (r *RollingRate) add(t time.Time, n int64) {
+    if r.start.IsZero() {
+        r.start = t
+    }
+    r.samples[r.next] = rateSample{t, n}
+    r.next = (r.next + 1) % len(r.samples)
+}
+
+func (r *RollingRate) Rate() float64 {
+    return r.rate(time.Now())
+}
+
+func (r *RollingRate) rate(now time.Time) float64 {
+    //Bytes per second, averaged over window (or time since first sample)
+    if r.start.IsZero() {
+        return 0
+    }
+    span := now.Sub(r.start)
+    if span > r.Window {
+        span = r.Window
+    }
+    if span <= 0 {
+        return 0
+    }
+    var sum int64
+    for _, sample := range r.samples {
+        if !sample.t.IsZero() && now.Sub(sample.t) <= span {
+            sum += sample.n
+        }
+    }
+    return float64(sum) / span.Seconds()
+}
+
+func formatETA(d time.Duration) string {
+    //Like ~2m 5s or ~42s
+    d = d.Round(time.Second)
+    if d >= time.Minute {
+        return fmt.Sprintf("~%dm %ds", int(d / time.Minute), int(d % time.Minute / time.Second))
+    }
+    return fmt.Sprintf("~%ds", int(d / time.Second))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "math"
+    "time"
+    "testing"
+)
+
+func TestRollingRate(t *testing.T) {
+    //10 MB/s, then 50 MB/s, rate follows after the window has passed
+    const mb = 1000 * 1000
+    r := NewRollingRate(10 * time.Second)
+    start := time.Unix(1000, 0)
+    const interval = 200 * time.Millisecond
+    now := start
+    feed := func(rate int64, d time.Duration) {
+        for end := now.Add(d); now.Before(end); {
+            now = now.Add(interval)
+            r.add(now, rate * int64(interval) / int64(time.Second))
+        }
+    }
+    check := func(expected float64) {
+        t.Helper()
+        if rate := r.rate(now); math.Abs(rate - expected) > expected * 0.05 {
+            t.Errorf("Rate %.0f B/s after %s, expected %.0f B/s", rate, now.Sub(start), expected)
+        }
+    }
+
+    feed(10 * mb, 20 * time.Second)
+    check(10 * mb)
+    feed(50 * mb, 5 * time.Second)
+    check(30 * mb) //half of the window
+    feed(50 * mb, 5 * time.Second)
+    check(50 * mb)
+    feed(50 * mb, 20 * time.Second)
+    check(50 * mb)
+
+    if rate := NewRollingRate(time.Second).rate(now); rate != 0 {
+        t.Errorf("Rate %f without samples, expected 0", rate)
+    }
+}
+
+func TestFormatETA(t *testing.T) {
+    tests := []struct {
+        d time.Duration
+        expected string
+    }{
+        {0, "~0s"},
+        {42 * time.Second, "~42s"},
+        {59600 * time.Millisecond, "~1m 0s"},
+        {125 * time.Second, "~2m 5s"},
+        {90 * time.Minute, "~90m 0s"},
+    }
+    for _, test := range tests {
+        if eta := formatETA(test.d); eta != test.expected {
+            t.Errorf("ETA for %s: %s, expected %s", test.d, eta, test.expected)
+        }
+    }
+}
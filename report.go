@@ -0,0 +1,212 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "html"
+    "strconv"
+    "encoding/csv"
+    "encoding/json"
+
+    "github.com/dustin/go-humanize"
+)
+
+//Output format (-report-format), duplicate listing and summary
+type ReportWriter interface {
+    WriteDuplicates(w io.Writer, groups []DuplicateGroup)
+    WriteSummary(w io.Writer, stats SummaryStats)
+}
+
+//Formats with groups and summary in a single document (JSON)
+type documentWriter interface {
+    WriteDocument(w io.Writer, groups []DuplicateGroup, stats *SummaryStats)
+}
+
+func WriteReport(w io.Writer, reporter ReportWriter, groups []DuplicateGroup, stats *SummaryStats) {
+    //Duplicate groups and summary (nil if not shown)
+    if dw, ok := reporter.(documentWriter); ok {
+        dw.WriteDocument(w, groups, stats)
+        return
+    }
+    reporter.WriteDuplicates(w, groups)
+    if stats != nil {
+        reporter.WriteSummary(w, *stats)
+    }
+}
+
+//Duplicate group as written by JSON reporters
+type reportGroup struct {
+    Hash string
+    Size int64
+    Files []string
+}
+
+func ReportWriters(filePath func(*File) string) map[string]ReportWriter {
+    //Available formats by name
+    return map[string]ReportWriter{
+        "text": TextReporter{filePath},
+        "json": JSONReporter{FilePath: filePath},
+        "ndjson": JSONReporter{FilePath: filePath, Lines: true},
+        "csv": CSVReporter{filePath},
+        "html": HTMLReporter{filePath},
+        "fdupes": FdupesReporter{filePath},
+    }
+}
+
+func newReportGroup(group DuplicateGroup, filePath func(*File) string) reportGroup {
+    rg := reportGroup{Hash: group.Hash, Size: group.Size(), Files: []string{}}
+    for _, file := range group.Files {
+        rg.Files = append(rg.Files, filePath(file))
+    }
+    return rg
+}
+
+//Plain text, like the default listing
+type TextReporter struct {
+    FilePath func(*File) string
+}
+
+func (reporter TextReporter) WriteDuplicates(w io.Writer, groups []DuplicateGroup) {
+    PrintDuplicateGroups(w, groups, reporter.FilePath, false, false)
+}
+
+func (reporter TextReporter) WriteSummary(w io.Writer, stats SummaryStats) {
+    fmt.Fprintf(w, "Files:\t\t\t%d\n", stats.TotalFiles)
+    fmt.Fprintf(w, "Total size:\t\t%s (%d B)\n",
+        humanize.IBytes(uint64(stats.TotalSize)), stats.TotalSize)
+    fmt.Fprintf(w, "Duplicate groups:\t%d\n", stats.DuplicateGroups)
+    fmt.Fprintf(w, "Duplicate count:\t%d\n", stats.DuplicateFiles)
+    fmt.Fprintf(w, "Size of duplicates:\t%s (%d B)\n",
+        humanize.IBytes(uint64(stats.DuplicatesSize)), stats.DuplicatesSize)
+}
+
+//JSON object with groups and summary (WriteReport)
+//One object per line if Lines is set (ndjson), groups first, summary last
+type JSONReporter struct {
+    FilePath func(*File) string
+    Lines bool
+}
+
+func (reporter JSONReporter) encoder(w io.Writer) *json.Encoder {
+    enc := json.NewEncoder(w)
+    if !reporter.Lines {
+        enc.SetIndent("", "  ")
+    }
+    return enc
+}
+
+func (reporter JSONReporter) WriteDuplicates(w io.Writer, groups []DuplicateGroup) {
+    enc := reporter.encoder(w)
+    if reporter.Lines {
+        for _, group := range groups {
+            enc.Encode(newReportGroup(group, reporter.FilePath))
+        }
+        return
+    }
+    list := []reportGroup{}
+    for _, group := range groups {
+        list = append(list, newReportGroup(group, reporter.FilePath))
+    }
+    enc.Encode(list)
+}
+
+func (reporter JSONReporter) WriteSummary(w io.Writer, stats SummaryStats) {
+    reporter.encoder(w).Encode(stats)
+}
+
+func (reporter JSONReporter) WriteDocument(w io.Writer, groups []DuplicateGroup, stats *SummaryStats) {
+    //Stream of objects (ndjson), nothing to combine
+    if reporter.Lines {
+        reporter.WriteDuplicates(w, groups)
+        if stats != nil {
+            reporter.WriteSummary(w, *stats)
+        }
+        return
+    }
+    document := struct {
+        Groups []reportGroup `json:"groups"`
+        Summary *SummaryStats `json:"summary,omitempty"`
+    }{[]reportGroup{}, stats}
+    for _, group := range groups {
+        document.Groups = append(document.Groups, newReportGroup(group, reporter.FilePath))
+    }
+    reporter.encoder(w).Encode(document)
+}
+
+//One file per row, with group number, hash and size
+type CSVReporter struct {
+    FilePath func(*File) string
+}
+
+func (reporter CSVReporter) WriteDuplicates(w io.Writer, groups []DuplicateGroup) {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"group", "hash", "size", "path"})
+    for i, group := range groups {
+        for _, file := range group.Files {
+            cw.Write([]string{
+                strconv.Itoa(i + 1),
+                group.Hash,
+                strconv.FormatInt(file.Size, 10),
+                reporter.FilePath(file),
+            })
+        }
+    }
+    cw.Flush()
+}
+
+func (reporter CSVReporter) WriteSummary(w io.Writer, stats SummaryStats) {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"key", "value"})
+    for _, row := range [][2]string{
+        {"files", strconv.Itoa(stats.TotalFiles)},
+        {"total_size", strconv.FormatInt(stats.TotalSize, 10)},
+        {"empty_files", strconv.Itoa(stats.EmptyFiles)},
+        {"duplicate_groups", strconv.Itoa(stats.DuplicateGroups)},
+        {"duplicate_files", strconv.Itoa(stats.DuplicateFiles)},
+        {"duplicates_size", strconv.FormatInt(stats.DuplicatesSize, 10)},
+    } {
+        cw.Write(row[:])
+    }
+    cw.Flush()
+}
+
+//HTML tables, one per group
+type HTMLReporter struct {
+    FilePath func(*File) string
+}
+
+func (reporter HTMLReporter) WriteDuplicates(w io.Writer, groups []DuplicateGroup) {
+    for i, group := range groups {
+        fmt.Fprintf(w, "<h2>Group %d: %s (%s)</h2>\n",
+            i + 1, html.EscapeString(group.Hash), humanize.IBytes(uint64(group.Size())))
+        fmt.Fprintf(w, "<table>\n")
+        for _, file := range group.Files {
+            fmt.Fprintf(w, "<tr><td>%s</td></tr>\n", html.EscapeString(reporter.FilePath(file)))
+        }
+        fmt.Fprintf(w, "</table>\n")
+    }
+}
+
+func (reporter HTMLReporter) WriteSummary(w io.Writer, stats SummaryStats) {
+    fmt.Fprintf(w, "<h2>Summary</h2>\n<table>\n")
+    fmt.Fprintf(w, "<tr><th>Files</th><td>%d</td></tr>\n", stats.TotalFiles)
+    fmt.Fprintf(w, "<tr><th>Total size</th><td>%s</td></tr>\n",
+        humanize.IBytes(uint64(stats.TotalSize)))
+    fmt.Fprintf(w, "<tr><th>Duplicate groups</th><td>%d</td></tr>\n", stats.DuplicateGroups)
+    fmt.Fprintf(w, "<tr><th>Duplicate count</th><td>%d</td></tr>\n", stats.DuplicateFiles)
+    fmt.Fprintf(w, "<tr><th>Size of duplicates</th><td>%s</td></tr>\n",
+        humanize.IBytes(uint64(stats.DuplicatesSize)))
+    fmt.Fprintf(w, "</table>\n")
+}
+
+//Format of fdupes, no summary
+type FdupesReporter struct {
+    FilePath func(*File) string
+}
+
+func (reporter FdupesReporter) WriteDuplicates(w io.Writer, groups []DuplicateGroup) {
+    ExportForFdupes(w, groups, reporter.FilePath)
+}
+
+func (reporter FdupesReporter) WriteSummary(w io.Writer, stats SummaryStats) {
+}
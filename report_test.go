@@ -0,0 +1,113 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "encoding/csv"
+    "encoding/json"
+)
+
+func TestWriteReport(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a": "same", "b": "same", "c": "other", "d": "other", "unique": "unique",
+    })
+    scan := scanTestDir(t, dir)
+    groups, err := SortDuplicateGroups(scan.DuplicateGroups(), "path", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stats := scan.SummaryStats()
+    filePath := func(file *File) string { return file.Path }
+    reporters := ReportWriters(filePath)
+
+    //JSON is a single document, with or without summary
+    for _, summary := range []*SummaryStats{&stats, nil} {
+        var buf bytes.Buffer
+        WriteReport(&buf, reporters["json"], groups, summary)
+        var document struct {
+            Groups []reportGroup `json:"groups"`
+            Summary *SummaryStats `json:"summary"`
+        }
+        decoder := json.NewDecoder(&buf)
+        if err := decoder.Decode(&document); err != nil {
+            t.Fatal(err)
+        }
+        if decoder.More() {
+            t.Errorf("More than one JSON document")
+        }
+        if len(document.Groups) != 2 || (document.Summary == nil) != (summary == nil) {
+            t.Errorf("Unexpected document: %+v", document)
+        }
+        if summary != nil && document.Summary.DuplicateGroups != 2 {
+            t.Errorf("Summary lists %d duplicate groups, expected 2", document.Summary.DuplicateGroups)
+        }
+    }
+
+    //One object per line, summary last
+    var buf bytes.Buffer
+    WriteReport(&buf, reporters["ndjson"], groups, &stats)
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    if len(lines) != 3 {
+        t.Fatalf("%d lines, expected 3", len(lines))
+    }
+    var summary SummaryStats
+    if err := json.Unmarshal([]byte(lines[2]), &summary); err != nil || summary.DuplicateFiles != 2 {
+        t.Errorf("Unexpected summary line: %s", lines[2])
+    }
+
+    //Same output as -fdupes-output
+    var report, fdupes bytes.Buffer
+    WriteReport(&report, reporters["fdupes"], groups, nil)
+    if err := ExportForFdupes(&fdupes, groups, filePath); err != nil {
+        t.Fatal(err)
+    }
+    if report.String() != fdupes.String() {
+        t.Errorf("Report:\n%s\nExpected:\n%s", report.String(), fdupes.String())
+    }
+
+    //Text, same listing as without -report-format, summary after it
+    var text, listing bytes.Buffer
+    WriteReport(&text, reporters["text"], groups, &stats)
+    PrintDuplicateGroups(&listing, groups, filePath, false, false)
+    if !strings.HasPrefix(text.String(), listing.String()) {
+        t.Errorf("Report:\n%s\nExpected listing:\n%s", text.String(), listing.String())
+    }
+    if !strings.Contains(text.String(), "Duplicate groups:\t2\n") {
+        t.Errorf("Summary missing:\n%s", text.String())
+    }
+
+    //CSV, one row per file, summary as key-value rows
+    var csvReport bytes.Buffer
+    WriteReport(&csvReport, reporters["csv"], groups, &stats)
+    reader := csv.NewReader(&csvReport)
+    reader.FieldsPerRecord = -1 //two tables
+    records, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) != 1 + 4 + 1 + 6 {
+        t.Fatalf("%d CSV records, expected 12: %v", len(records), records)
+    }
+    if first := records[1]; first[0] != "1" || first[1] != groups[0].Hash || first[3] != groups[0].Files[0].Path {
+        t.Errorf("Unexpected first row: %v", first)
+    }
+    if row := records[5 + 4]; row[0] != "duplicate_groups" || row[1] != "2" {
+        t.Errorf("Unexpected summary row: %v", row)
+    }
+
+    //HTML, one table per group, paths escaped
+    var htmlReport bytes.Buffer
+    escapedPath := func(file *File) string { return file.Name + "<&>" }
+    WriteReport(&htmlReport, ReportWriters(escapedPath)["html"], groups, &stats)
+    if count := strings.Count(htmlReport.String(), "<h2>Group "); count != 2 {
+        t.Errorf("%d groups, expected 2:\n%s", count, htmlReport.String())
+    }
+    if count := strings.Count(htmlReport.String(), "&lt;&amp;&gt;</td>"); count != 4 {
+        t.Errorf("%d escaped paths, expected 4:\n%s", count, htmlReport.String())
+    }
+    if !strings.Contains(htmlReport.String(), "<h2>Summary</h2>") {
+        t.Errorf("Summary missing:\n%s", htmlReport.String())
+    }
+}
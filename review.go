@@ -0,0 +1,51 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "strings"
+    "path/filepath"
+)
+
+func reviewGroupName(number int, group DuplicateGroup) string {
+    //Like GROUP_001_md5abc123_3files
+    algorithm, hash, _ := strings.Cut(group.Hash, ":")
+    hash = strings.TrimPrefix(hash, "partial:")
+    if len(hash) > 6 {
+        hash = hash[:6]
+    }
+    return fmt.Sprintf("GROUP_%03d_%s%s_%dfiles", number, algorithm, hash, len(group.Files))
+}
+
+func WriteGroupsToDir(destDir string, groups []DuplicateGroup, useHardlinks bool, filePath func(*File) string) error {
+    //Review directory, one subdirectory per duplicate group, in the order listed
+    //Files are hardlinked or, if useHardlinks is not set, copied, originals are not touched
+    //Files in archives can't be linked, they are always copied
+    //Linking fails across filesystems, nothing is copied then
+    //Files are numbered, files with the same name would collide otherwise
+    if entries, err := os.ReadDir(destDir); err == nil && len(entries) > 0 {
+        return fmt.Errorf("Review directory not empty: %s", destDir)
+    }
+    for i, group := range groups {
+        groupDir := filepath.Join(destDir, reviewGroupName(i + 1, group))
+        if err := os.MkdirAll(groupDir, 0755); err != nil {
+            return err
+        }
+        for j, file := range group.Files {
+            dst := filepath.Join(groupDir, fmt.Sprintf("%d_%s", j + 1, file.Name))
+            if useHardlinks && !file.IsVirtual() {
+                if err := os.Link(file.Path, dst); err != nil {
+                    return fmt.Errorf("Failed to link %s (-no-hardlink to copy): %w", filePath(file), err)
+                }
+                fmt.Fprintf(verboseIO, "Linked %s to %s\n", filePath(file), dst)
+                continue
+            }
+            if err := file.CopyTo(dst); err != nil {
+                return err
+            }
+            fmt.Fprintf(verboseIO, "Copied %s to %s\n", filePath(file), dst)
+        }
+    }
+
+    return nil
+}
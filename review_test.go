@@ -0,0 +1,118 @@
+package main
+
+import (
+    "os"
+    "testing"
+    "path/filepath"
+)
+
+func TestWriteGroupsToDir(t *testing.T) {
+    tests := []struct {
+        name string
+        useHardlinks bool
+        existing bool //review directory not empty
+        valid bool
+    }{
+        {"hardlinks", true, false, true},
+        {"copies", false, false, true},
+        {"directory not empty", true, true, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            searchPath := filepath.Join(dir, "files")
+            reviewDir := filepath.Join(dir, "review")
+            writeTestFiles(t, searchPath, map[string]string{
+                "a": "same", "sub/a": "same", "unique": "unique",
+            })
+            if test.existing {
+                writeTestFiles(t, reviewDir, map[string]string{"old": "old"})
+            }
+            scan := scanTestDir(t, searchPath)
+
+            err := WriteGroupsToDir(reviewDir, scan.DuplicateGroups(), test.useHardlinks, func(file *File) string { return file.Path })
+            if (err == nil) != test.valid {
+                t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+            }
+            if !test.valid {
+                return
+            }
+
+            //One directory per group, numbered files with the same name
+            groupDirs, _ := filepath.Glob(filepath.Join(reviewDir, "GROUP_001_*_2files"))
+            if len(groupDirs) != 1 {
+                t.Fatalf("Group directories %v, expected 1", groupDirs)
+            }
+            original, err := os.Stat(filepath.Join(searchPath, "a"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, name := range []string{"1_a", "2_a"} {
+                fi, err := os.Stat(filepath.Join(groupDirs[0], name))
+                if err != nil {
+                    t.Fatalf("File missing: %s", name)
+                }
+                if name == "1_a" && os.SameFile(original, fi) != test.useHardlinks {
+                    t.Errorf("File %s linked: %t, expected %t", name, os.SameFile(original, fi), test.useHardlinks)
+                }
+            }
+        })
+    }
+}
+
+func TestWriteGroupsToDirListed(t *testing.T) {
+    //Only the listed groups are written, in the order listed
+    dir := t.TempDir()
+    searchPath := filepath.Join(dir, "files")
+    reviewDir := filepath.Join(dir, "review")
+    writeTestFiles(t, searchPath, map[string]string{
+        "a1": "small", "a2": "small", "b1": "bigger", "b2": "bigger", "b3": "bigger",
+    })
+    scan := scanTestDir(t, searchPath)
+    groups, err := SortDuplicateGroups(scan.DuplicateGroups(), "path", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := WriteGroupsToDir(reviewDir, groups[:1], true, func(file *File) string { return file.Path }); err != nil {
+        t.Fatal(err)
+    }
+    entries, err := os.ReadDir(reviewDir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 1 {
+        t.Fatalf("%d group directories, expected 1", len(entries))
+    }
+    if _, err := os.Stat(filepath.Join(reviewDir, entries[0].Name(), "1_b1")); err != nil {
+        t.Errorf("Group %s is not the one listed first: %v", entries[0].Name(), err)
+    }
+}
+
+func TestWriteGroupsToDirArchive(t *testing.T) {
+    //Files in archives are copied, even if other files are linked
+    dir := t.TempDir()
+    searchPath := filepath.Join(dir, "files")
+    reviewDir := filepath.Join(dir, "review")
+    writeTestFiles(t, searchPath, map[string]string{"a": "same"})
+    writeTestZip(t, filepath.Join(searchPath, "archive.zip"), map[string]string{"x": "same"})
+    scan := NewScan()
+    scan.Paths = []string{searchPath}
+    scan.ScanArchives = true
+    runScan(t, scan)
+    groups := scan.DuplicateGroups()
+    if len(groups) != 1 {
+        t.Fatalf("Found %d duplicate groups, expected 1", len(groups))
+    }
+
+    if err := WriteGroupsToDir(reviewDir, groups, true, func(file *File) string { return file.Path }); err != nil {
+        t.Fatal(err)
+    }
+    copies, _ := filepath.Glob(filepath.Join(reviewDir, "GROUP_001_*", "*_x"))
+    if len(copies) != 1 {
+        t.Fatalf("Archive entry not copied: %v", copies)
+    }
+    data, err := os.ReadFile(copies[0])
+    if err != nil || string(data) != "same" {
+        t.Errorf("Copied content %q (%v), expected %q", data, err, "same")
+    }
+}
@@ -2,85 +2,343 @@ package main
 
 import (
     "os"
-    "syscall"
+    "io"
+    "io/fs"
+    "context"
+    "errors"
+    "io/ioutil"
     "sync"
     "sort"
     "path/filepath"
     "fmt"
     "encoding/json"
     "bufio"
+    "strings"
+    "time"
+    "encoding/hex"
+    "crypto/sha256"
+    "regexp"
+    "runtime"
+
+    "github.com/dustin/go-humanize"
 )
 
+//Walk aborted because file limit has been reached
+var errMaxFiles = errors.New("Maximum number of files reached")
+
 type FilePathInfo struct {
     file string
     fi os.FileInfo
+    fsys fs.FS //archive or virtual filesystem
+    archive string //archive path, for archive entries
+    root string //search path the file was found in
+}
+
+type ScanError struct {
+    Path string
+    Err error
+}
+
+type ScanProgress struct {
+    ScannedCount int
+    TotalCount int //files found so far
+    ScannedBytes int64 //size of files scanned (hashed or not)
+    TotalBytes int64 //size of files found so far
+    BytesHashed int64
+    CurrentFile string
+}
+
+type MapMetadata struct {
+    CreatedAt time.Time
+    Hostname string
+    DupefinderVersion string
+    TotalFiles int
+    ScanPaths []string
+}
+
+type mapFileWithMetadata struct {
+    Metadata MapMetadata `json:"metadata"`
+    Files FileList `json:"files"`
+}
+
+type AccessError struct {
+    Path string
+    Err error
+}
+
+//Device and inode number, identifies hardlinks
+type inodeKey struct {
+    device uint64
+    inode uint64
+}
+
+//Hash of an inode, claimed by the first path found, other paths wait for it
+type inodeHash struct {
+    hashed chan struct{} //closed when hashing is done
+    file *File //hashed file, nil if hashing failed
+}
+
+type ScanStats struct {
+    Unchanged int
+    New int
+    Updated int
+    Removed int
+    Found int //files scanned, Found = Hashed + Cached + Failed
+    Hashed int //files hashed (not from imported map)
+    Cached int //hash from imported map, sum file or hardlink
+    Failed int //files that could not be hashed
+    BytesHashed int64
+}
+
+//Totals shown in summary (server, reports)
+type SummaryStats struct {
+    TotalFiles int
+    TotalSize int64
+    EmptyFiles int
+    DuplicateGroups int
+    DuplicateFiles int
+    DuplicatesSize int64
+    Stats ScanStats
 }
 
 type Scan struct {
     Paths []string
+    DirectFiles []string
+    FS fs.FS //scan virtual filesystem instead of paths, if set
+    ExcludePaths []string
+    ExcludeDirNames []string //directory names excluded at any depth
+    IncludePathRegex []*regexp.Regexp //only files with a matching path, if set
+    ExcludePathRegex []*regexp.Regexp //files with a matching path are skipped
     Files FileMap
+    ErrorFiles FileList //files that could not be hashed
     HashFilesMap map[string]Files
-    SortOrder int
+    SortOrder SortMode
     SortReversed bool
     WorkerCount int
+    MmapThreshold int64 //files of this size or larger are hashed using mmap, 0 = never
+    HashSizeLimit int64 //larger files only get a partial hash (beginning and end), 0 = no limit
+    sizeMismatches map[*File]bool //reported in ScanErrors
+    sizeMismatchMutex sync.Mutex
+    HashAlgorithm string
+    ComputeSHA1 bool //also compute SHA1, in the same pass
+    KeepLast bool
+    KeepLongestPath bool //keep file with longest path, overrides KeepLast
+    LinkFullPaths bool //link using full paths, independent of working directory
+    SymlinkRelative bool //symlink targets relative to the duplicate, absolute otherwise
+    FastMode bool
+    FastModeVerified int
+    VerifyGroupSizes bool
+    SameNameOnly bool //only groups of files with the same name
+    DifferentNameOnly bool //only groups with different file names
+    SameDirOnly bool //only files with a duplicate in the same directory
+    MinCopies int //only groups with at least this many files
+    MaxCopies int //only groups with at most this many files, if set
+    SkipLockedFiles bool
+    DedupeByInode bool //hash hardlinks (same inode) only once
+    inodeFiles *sync.Map //*inodeHash by inodeKey
+    RetryModifiedFiles bool //hash file again if it was modified while it was hashed
+    WarnZeroMtime bool //report files with a modification time of 0 in ScanErrors
+    UseIgnoreFiles bool
+    ScanArchives bool //scan files in zip and tar.gz archives
+    OneFilesystem bool //don't cross filesystem boundaries (find -xdev)
+    FilterByOwner bool //only files owned by OwnerUID
+    OwnerUID uint32
+    FilterByGroup bool //only files owned by group OwnerGID
+    OwnerGID uint32
+    ExcludeNewerThan time.Duration //skip files modified within this time, if set
+    ExcludeOlderThan time.Duration //skip files not modified within this time, if set
+    ParallelWalk bool //read directories concurrently
+    WalkParallelism int //directories read at the same time (parallel walk)
+    MaxFiles int //0 = no limit
+    MaxDepth int //1 = only files directly in search path, 0 = no limit
+    ScanErrors []ScanError
+    AccessErrors []AccessError //paths that could not be read
+    accessMutex sync.Mutex
+    Stats ScanStats
+    statsMutex sync.Mutex
+    ExportMetadata bool
+    RequireHash bool //reject imported maps with files that have no hash
+    ImportedMetadata *MapMetadata
+    importedMaps int //number of imported maps
+    seededFiles map[string]*File //hashes from sum files, by full path
+    scanned bool //scan has been run
+    Progress <-chan ScanProgress //latest progress, closed when scan completes
+    progress chan ScanProgress
+    progressState ScanProgress
+    progressMutex sync.Mutex
 }
 
 func NewScan() *Scan {
     scan := &Scan{}
     scan.Files = make(FileMap)
+    scan.HashAlgorithm = "md5"
+    scan.MmapThreshold = 1 << 30 //1 GiB
+    scan.DedupeByInode = true
+    scan.MinCopies = 2
 
     return scan
 }
 
+func (scan *Scan) Reset() {
+    //Clear results, keep configuration (paths, options)
+    scan.Files = make(FileMap)
+    scan.ErrorFiles = nil
+    scan.HashFilesMap = nil
+    scan.FastModeVerified = 0
+    scan.ScanErrors = nil
+    scan.sizeMismatchMutex.Lock()
+    scan.sizeMismatches = nil
+    scan.sizeMismatchMutex.Unlock()
+    scan.accessMutex.Lock()
+    scan.AccessErrors = nil
+    scan.accessMutex.Unlock()
+    scan.statsMutex.Lock()
+    scan.Stats = ScanStats{}
+    scan.statsMutex.Unlock()
+    scan.ImportedMetadata = nil
+    scan.importedMaps = 0
+    scan.seededFiles = nil
+    scan.scanned = false
+    scan.progressMutex.Lock()
+    scan.progressState = ScanProgress{}
+    scan.progressMutex.Unlock()
+    scan.Progress = nil
+}
+
 func (scan *Scan) ImportMap(file string) error {
     //Open file
     fmt.Fprintf(verboseIO, "Importing map from file: %s\n", file)
     f, err := os.Open(file)
-    defer f.Close()
     if err != nil {
         return err
     }
+    defer f.Close()
+
+    if err := scan.importMap(f, file); err != nil {
+        return err
+    }
 
-    //Decoder
-    decoder := json.NewDecoder(f)
+    //Report inconsistent entries (verbose mode)
+    for _, problem := range scan.ValidateMap() {
+        fmt.Fprintf(verboseIO, "Invalid map entry: %s\n", problem)
+    }
+
+    return nil
+}
 
-    //Format
-    r := bufio.NewReader(f)
+func (scan *Scan) ImportMapFrom(r io.Reader) error {
+    return scan.importMap(r, "input")
+}
+
+func (scan *Scan) importMap(r io.Reader, file string) error {
+    scan.importedMaps++
+
+    //Working directory, imported paths are resolved relative to it
+    workingDir, err := os.Getwd()
+    if err != nil {
+        return err
+    }
+
+    //Format, detected by first character
+    br := bufio.NewReader(r)
     var isFormatMap bool
     var isFormatArray bool
-    if c, _, err := r.ReadRune(); err == nil {
+    for {
+        c, _, err := br.ReadRune()
+        if err != nil {
+            fmt.Fprintf(verboseIO, "Format error\n")
+            return err
+        }
+        if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+            continue
+        }
         if c == '{' {
             isFormatMap = true
         } else if c == '[' {
             isFormatArray = true
         }
-    } else {
-        fmt.Fprintf(verboseIO, "Format error\n")
-        return err
+        br.UnreadRune()
+        break
     }
-    f.Seek(0, 0)
+
+    //Decoder
+    decoder := json.NewDecoder(br)
 
     //Try to import map directly (alternative format: dict instead of array)
     if isFormatMap {
         //Parse hash map
         fmt.Fprintf(verboseIO, "Importing full map...\n")
-        var importedMap FileMap
+        var importedMap map[string]json.RawMessage
         if err := decoder.Decode(&importedMap); err != nil {
             return err
         }
 
-        //Ignore hash keys, collect file structs
-        for _, importedFile := range importedMap {
-            //Check fields
-            if importedFile.FullPath == "" || importedFile.Path == "" {
-                return fmt.Errorf("Path field missing (%s)", file)
+        //Map with metadata, files in array
+        _, hasMetadata := importedMap["metadata"]
+        rawFiles, hasFiles := importedMap["files"]
+        if hasMetadata && hasFiles {
+            metadata := &MapMetadata{}
+            if err := json.Unmarshal(importedMap["metadata"], metadata); err != nil {
+                return err
+            }
+            fmt.Fprintf(verboseIO, "Map created at %s on %s (version %s), %d files, scan paths: %s\n",
+                metadata.CreatedAt.Format(time.RFC3339), metadata.Hostname,
+                metadata.DupefinderVersion, metadata.TotalFiles,
+                strings.Join(metadata.ScanPaths, ", "))
+            scan.ImportedMetadata = metadata
+            var importedFiles FileList
+            if err := json.Unmarshal(rawFiles, &importedFiles); err != nil {
+                return err
+            }
+            for _, importedFile := range importedFiles {
+                if err := scan.addImportedFile(importedFile, file, workingDir); err != nil {
+                    return err
+                }
+            }
+            scan.BuildHashFilesMap()
+            return nil
+        }
+
+        //One file object per line (NDJSON), first object is a file
+        _, isFile := importedMap["path"]
+        if _, isLegacyFile := importedMap["Path"]; isLegacyFile {
+            isFile = true
+        }
+        if isFile {
+            fmt.Fprintf(verboseIO, "Importing file objects (one per line)...\n")
+            rawFile, err := json.Marshal(importedMap)
+            if err != nil {
+                return err
             }
-            if importedFile.Name == "" {
-                return fmt.Errorf("Name field missing (%s)", file)
+            importedFile := &File{}
+            if err := json.Unmarshal(rawFile, importedFile); err != nil {
+                return err
             }
+            if err := scan.addImportedFile(importedFile, file, workingDir); err != nil {
+                return err
+            }
+            for decoder.More() {
+                importedFile := &File{}
+                if err := decoder.Decode(importedFile); err != nil {
+                    return err
+                }
+                if err := scan.addImportedFile(importedFile, file, workingDir); err != nil {
+                    return err
+                }
+            }
+            scan.BuildHashFilesMap()
+            return nil
+        }
 
-            //Add file to map
-            scan.Files[importedFile.Path] = importedFile
+        //Ignore hash keys, collect file structs
+        for _, rawFile := range importedMap {
+            importedFile := &File{}
+            if err := json.Unmarshal(rawFile, importedFile); err != nil {
+                return err
+            }
+            if err := scan.addImportedFile(importedFile, file, workingDir); err != nil {
+                return err
+            }
         }
 
         //Build hash files map
@@ -107,16 +365,9 @@ func (scan *Scan) ImportMap(file string) error {
             return err
         }
 
-        //Check fields
-        if importedFile.FullPath == "" || importedFile.Path == "" {
-            return fmt.Errorf("Path field missing (%s)", file)
-        }
-        if importedFile.Name == "" {
-            return fmt.Errorf("Name field missing (%s)", file)
+        if err := scan.addImportedFile(importedFile, file, workingDir); err != nil {
+            return err
         }
-
-        //Add file to map
-        scan.Files[importedFile.Path] = importedFile
     }
 
     //Closing bracket
@@ -130,15 +381,52 @@ func (scan *Scan) ImportMap(file string) error {
     return nil
 }
 
+func (scan *Scan) addImportedFile(importedFile *File, file string, workingDir string) error {
+    //Check fields
+    if importedFile.FullPath == "" || importedFile.Path == "" {
+        return fmt.Errorf("Path field missing (%s)", file)
+    }
+    if importedFile.Name == "" {
+        return fmt.Errorf("Name field missing (%s)", file)
+    }
+    if scan.RequireHash && !importedFile.IsHashed() {
+        return fmt.Errorf("Hash missing for %s (%s)", importedFile.Path, file)
+    }
+
+    //Hash values may be stored with algorithm prefix
+    importedFile.MD5 = trimHashPrefix(importedFile.MD5, "md5")
+    importedFile.SHA1 = trimHashPrefix(importedFile.SHA1, "sha1")
+    importedFile.SHA256 = trimHashPrefix(importedFile.SHA256, "sha256")
+    importedFile.BLAKE2b = trimHashPrefix(importedFile.BLAKE2b, "blake2b")
+    importedFile.BLAKE3 = trimHashPrefix(importedFile.BLAKE3, "blake3")
+
+    //Map created in another directory, paths may need to be adjusted
+    if root := importedFile.rootPath(); root != "" && root != workingDir {
+        path := importedFile.Path
+        if err := importedFile.Resolve(workingDir); err == nil && importedFile.Path != path {
+            fmt.Fprintf(verboseIO, "Resolved imported file: %s -> %s\n", path, importedFile.Path)
+        }
+    }
+
+    //Add file to map
+    scan.Files[importedFile.Path] = importedFile
+
+    return nil
+}
+
 func (scan *Scan) ExportMap(file string) error {
     //Export map to file
     fmt.Fprintf(verboseIO, "Exporting map to file: %s\n", file)
     f, err := os.Create(file)
-    defer f.Close()
     if err != nil {
         return err
     }
+    defer f.Close()
 
+    return scan.ExportMapTo(f)
+}
+
+func (scan *Scan) ExportMapTo(w io.Writer) error {
     //Array of File objects
     files := make(FileList, len(scan.Files))
     index := 0
@@ -147,9 +435,7 @@ func (scan *Scan) ExportMap(file string) error {
         index++
     }
 
-    //Encode map
-    encoder := json.NewEncoder(f)
-    if err := encoder.Encode(files); err != nil {
+    if err := scan.writeMap(w, files); err != nil {
         return err
     }
     fmt.Fprintf(verboseIO, "Done exporting map\n")
@@ -157,41 +443,236 @@ func (scan *Scan) ExportMap(file string) error {
     return nil
 }
 
+func (scan *Scan) writeMap(w io.Writer, files FileList) error {
+    //Encode map, wrapped with metadata if requested
+    encoder := json.NewEncoder(w)
+    if scan.ExportMetadata {
+        hostname, _ := os.Hostname()
+        wrapper := mapFileWithMetadata{
+            Metadata: MapMetadata{
+                CreatedAt: time.Now(),
+                Hostname: hostname,
+                DupefinderVersion: Version,
+                TotalFiles: len(files),
+                ScanPaths: scan.Paths,
+            },
+            Files: files,
+        }
+        if err := encoder.Encode(wrapper); err != nil {
+            return err
+        }
+    } else {
+        if err := encoder.Encode(files); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (scan *Scan) ExportDuplicatesMap(file string) error {
+    //Export compact map to file (only files with the same hash as another file)
+    //Unfiltered hash groups including hardlinks, filters like -same-name-only apply after import
+    fmt.Fprintf(verboseIO, "Exporting duplicates map to file: %s\n", file)
+
+    //Array of File objects, unique files are left out
+    files := FileList{}
+    for _, group := range scan.HashFilesMap {
+        if len(group.Files) > 1 {
+            files = append(files, group.Files...)
+        }
+    }
+
+    err := writeFileAtomic(file, func(w io.Writer) error {
+        return scan.writeMap(w, files)
+    })
+    if err != nil {
+        return err
+    }
+    fmt.Fprintf(verboseIO, "Done exporting duplicates map (%d files)\n", len(files))
+
+    return nil
+}
+
 func (scan *Scan) ExportMD5(file string) error {
+    return scan.exportHashFile(file, "md5")
+}
+
+func (scan *Scan) ExportSHA1(file string) error {
+    return scan.exportHashFile(file, "sha1")
+}
+
+func (scan *Scan) ExportBLAKE2b(file string) error {
+    return scan.exportHashFile(file, "blake2b")
+}
+
+func (scan *Scan) exportHashFile(file string, algorithm string) error {
     //Export hash file
-    fmt.Fprintf(verboseIO, "Exporting MD5SUMS file: %s\n", file)
-    f, err := os.Create(file)
-    defer f.Close()
+    fmt.Fprintf(verboseIO, "Exporting %s hash file: %s\n", algorithm, file)
+
+    //Go thru files and get hash
+    var partialFiles int
+    err := writeFileAtomic(file, func(w io.Writer) error {
+        for _, file := range scan.Files {
+            if file.Path == "" {
+                err := fmt.Errorf("No data generated for file, run scan")
+                return err
+            }
+            hashValue := file.HashOf(algorithm)
+            if hashValue == "" {
+                err := fmt.Errorf("No %s hash generated for file: %s",
+                    algorithm, file.Path)
+                return err
+            }
+            if file.HashSizeLimit {
+                //Partial hash (-size-limit-per-file), no sum tool could verify it
+                partialFiles++
+                continue
+            }
+            hashLine := hashValue + "  " + file.Path
+            _, err := io.WriteString(w, hashLine + "\n")
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
     if err != nil {
         return err
     }
 
-    //Go thru files and get MD5 hash
-    for _, file := range scan.Files {
-        if file.Path == "" {
-            err := fmt.Errorf("No data generated for file, run scan")
-            return err
-        }
-        if file.MD5 == "" {
-            err := fmt.Errorf("No md5 hash generated for file: %s",
-                file.Path)
-            return err
-        }
-        hashLine := file.MD5 + "  " + file.Path
-        _, err := f.WriteString(hashLine + "\n")
-        if err != nil {
-            return err
-        }
+    if partialFiles > 0 {
+        fmt.Fprintf(os.Stderr, "Warning: %d files with partial hashes not exported to hash file\n", partialFiles)
+    }
+
+    return nil
+}
+
+func writeFileAtomic(file string, write func(w io.Writer) error) error {
+    //Written to temporary file first, replaced only if complete
+    f, err := os.CreateTemp(filepath.Dir(file), ".dupefinder-")
+    if err != nil {
+        return err
+    }
+    tmpFile := f.Name()
+    defer func() {
+        f.Close()
+        os.Remove(tmpFile) //gone after successful rename
+    }()
+
+    if err := write(f); err != nil {
+        return err
     }
 
     //Sync/flush
     if err := f.Sync(); err != nil {
         return err
     }
+    if err := f.Chmod(0644); err != nil {
+        return err //temporary files are private
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmpFile, file)
+}
+
+func (scan *Scan) ValidateMap() []string {
+    //Find map entries with inconsistent or missing paths
+    var problems []string
+    for _, key := range scan.Files.Keys() {
+        file := scan.Files[key]
+        if file == nil {
+            problems = append(problems, fmt.Sprintf("%s: no file object", key))
+            continue
+        }
+        if key != file.Path {
+            problems = append(problems, fmt.Sprintf("%s: key does not match path %s", key, file.Path))
+        }
+        if file.FullPath == "" {
+            problems = append(problems, fmt.Sprintf("%s: full path missing", key))
+        }
+    }
+    return problems
+}
+
+func (scan *Scan) RemoveFromMap(path string) error {
+    //Remove file from map (not from disk), hash map is rebuilt
+    return scan.RemoveFromMapBatch([]string{path})
+}
+
+func (scan *Scan) RemoveFromMapBatch(paths []string) error {
+    //Remove files from map (not from disk), hash map is rebuilt once
+    //Paths not in map are reported, all other files are still removed
+    var missing []string
+    for _, path := range paths {
+        if _, found := scan.Files[path]; !found {
+            missing = append(missing, path)
+            continue
+        }
+        delete(scan.Files, path)
+    }
+    scan.BuildHashFilesMap()
+    if len(missing) > 0 {
+        return fmt.Errorf("File not in map: %s", strings.Join(missing, ", "))
+    }
 
     return nil
 }
 
+func (scan *Scan) ValidateHashes() []string {
+    //Paths of files without hash
+    var paths []string
+    for _, path := range scan.Files.Keys() {
+        if !scan.Files[path].IsHashed() {
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+func (scan *Scan) FixImportedPaths(newBase string) (int, error) {
+    //Move imported files to new base directory (like another mount point)
+    //Path below the original search path is kept, map is rekeyed
+    //Nothing is changed if two files would end up with the same path
+    absBase, err := filepath.Abs(newBase)
+    if err != nil {
+        return 0, err
+    }
+    rebased := make(FileMap)
+    moved := make(map[*File]string) //file -> path below base
+    for key, file := range scan.Files {
+        rel := file.RelativePath
+        if rel == "" && !filepath.IsAbs(file.Path) {
+            rel = file.Path
+        }
+        path := key
+        if rel != "" && !file.IsVirtual() {
+            path = filepath.Join(newBase, rel)
+            moved[file] = rel
+        }
+        if other, ok := rebased[path]; ok {
+            return 0, fmt.Errorf("Imported files %s and %s both rebased to %s", other.Path, file.Path, path)
+        }
+        rebased[path] = file
+    }
+    var updated int
+    for file, rel := range moved {
+        path := filepath.Join(newBase, rel)
+        fullPath := filepath.Join(absBase, rel)
+        if path != file.Path || fullPath != file.FullPath {
+            fmt.Fprintf(verboseIO, "Rebased imported file: %s -> %s\n", file.Path, path)
+            file.Path = path
+            file.FullPath = fullPath
+            updated++
+        }
+    }
+    scan.Files = rebased
+    scan.BuildHashFilesMap()
+
+    return updated, nil
+}
+
 func (scan *Scan) Clean() FileList {
     var removedFiles FileList
 
@@ -200,7 +681,13 @@ func (scan *Scan) Clean() FileList {
     i := 0 //index
     ii := len(scan.Files) //count
     for path, file := range scan.Files {
-        if !file.Exists() {
+        exists := file.Exists()
+        if file.ArchivePath != "" {
+            //Archive entry, check archive (entries are read during scan)
+            _, err := os.Stat(file.ArchivePath)
+            exists = err == nil
+        }
+        if !exists {
             fmt.Fprintf(verboseIO, "[%d/%d] File not found: %s\n", i + 1, ii, path)
             delete(scan.Files, path)
             removedFiles = append(removedFiles, file)
@@ -210,6 +697,7 @@ func (scan *Scan) Clean() FileList {
         i++
     }
     fmt.Fprintf(verboseIO, "Done cleaning file list (%d removed)\n", len(removedFiles))
+    scan.Stats.Removed += len(removedFiles)
 
     //Rebuild hash files map
     scan.BuildHashFilesMap()
@@ -218,60 +706,99 @@ func (scan *Scan) Clean() FileList {
 }
 
 func (scan *Scan) Scan(wait *sync.WaitGroup) {
+    scan.ScanContext(context.Background(), wait)
+}
+
+func (scan *Scan) ScanContext(ctx context.Context, wait *sync.WaitGroup) {
+    //Progress channel, only the latest value is kept
+    scan.progressState = ScanProgress{}
+    scan.progress = make(chan ScanProgress, 1)
+    scan.Progress = scan.progress
+    scan.scanned = true
+    scan.inodeFiles = &sync.Map{}
+
     go func() {
         defer wait.Done()
+        defer scan.closeProgress()
+        startTime := time.Now()
 
         //Remove non-existent files from list
         //Some files may have been deleted after creating the imported map
         scan.Clean()
 
         //Scan workers (responsible for hashing files)
-        workerCount := scan.WorkerCount
-        if workerCount == 0 {
-            workerCount = 1 //1 worker by default
-        }
+        //Workers discard their results once the collector has stopped
+        workerCount := scan.workerCount()
         foundFiles := make(chan FilePathInfo)
         scannedFiles := make(chan *File)
+        scanErrors := make(chan ScanError) //files that were skipped
+        collectorDone := make(chan struct{}) //collector stopped signal
+        var wgWorkers sync.WaitGroup
         for i := 0; i < workerCount; i++ {
-            go scan.scanFileWorker(foundFiles, scannedFiles)
+            wgWorkers.Add(1)
+            go func() {
+                defer wgWorkers.Done()
+                scan.scanFileWorker(foundFiles, scannedFiles, scanErrors, collectorDone)
+            }()
         }
+        go func() {
+            wgWorkers.Wait()
+            close(scannedFiles) //all workers finished
+        }()
 
         //Collect scanned files (in the background)
         //Received files not yet saved in map while workers read from map
         var collectedFiles FileList //buffer for received files
-        foundCountSignal := make(chan int, 1) //scan complete signal
         var wgDone sync.WaitGroup
         wgDone.Add(1)
         go func() {
-            var totalCount, receivedCount int
+            defer wgDone.Done()
+            defer close(collectorDone)
             for {
                 select {
-                case count := <-foundCountSignal:
-                    //Filesystem scan complete, total file count now known
-                    totalCount = count
-                case scannedFile := <-scannedFiles:
+                case scannedFile, ok := <-scannedFiles:
+                    if !ok {
+                        //Last file received
+                        return
+                    }
                     //Received file from worker
-                    receivedCount++
                     collectedFiles = append(collectedFiles, scannedFile)
-                }
-                if receivedCount == totalCount {
-                    //Last file received
-                    break
+                case scanError := <-scanErrors:
+                    //File skipped by worker
+                    scan.ScanErrors = append(scan.ScanErrors, scanError)
+                case <-ctx.Done():
+                    //Scan cancelled, keep files received so far
+                    return
                 }
             }
-            wgDone.Done() //all files received
         }()
 
-        //Scan search path recursively
+        //Send file to workers unless scan has been cancelled
+        sendFile := func(fpi FilePathInfo) error {
+            select {
+            case foundFiles <- fpi:
+                scan.updateProgress(func(p *ScanProgress) {
+                    p.TotalCount++
+                    p.TotalBytes += fpi.fi.Size()
+                })
+                return nil
+            case <-ctx.Done():
+                return ctx.Err()
+            }
+        }
+
+        //Walk function for search path (base)
         var count int //number of files
-        for _, path := range scan.Paths {
-            //Search path (base)
-            fmt.Fprintf(verboseIO, "Scanning %s ...\n", path)
-            filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+        var scanArchive func(file string) error
+        devices := make(map[string]uint64) //device of each search path
+        walkFunc := func(path string, ignores *ignoreList) fs.WalkDirFunc {
+            rootDevice, checkDevice := devices[path]
+            return func(file string, d fs.DirEntry, err error) error {
                 //Check for error
                 if err != nil {
-                    //Handle error
-                    if fi.IsDir() {
+                    //Handle error, remember path that couldn't be accessed
+                    scan.addAccessError(file, err)
+                    if d != nil && d.IsDir() {
                         //Skip directory on error (such as permission denied)
                         return filepath.SkipDir
                     } else {
@@ -280,100 +807,794 @@ func (scan *Scan) Scan(wait *sync.WaitGroup) {
                     }
                 }
 
+                //Directory on other filesystem (mount point)
+                if checkDevice && d.IsDir() && !scan.sameDevice(d, rootDevice) {
+                    fmt.Fprintf(verboseIO, "Skipping %s (other filesystem)\n", file)
+                    return filepath.SkipDir
+                }
+
+                //Don't descend below maximum depth (search path itself is depth 0)
+                if d.IsDir() && scan.MaxDepth > 0 && pathDepth(path, file) >= scan.MaxDepth {
+                    return filepath.SkipDir
+                }
+
+                //Excluded directory name (like node_modules), not search path itself
+                if d.IsDir() && file != path && scan.isExcludedDirName(d.Name()) {
+                    fmt.Fprintf(verboseIO, "Excluding %s\n", file)
+                    return filepath.SkipDir
+                }
+
+                //Excluded path (like an export file)
+                if scan.FS == nil && scan.isExcluded(file) {
+                    if d.IsDir() {
+                        return filepath.SkipDir
+                    }
+                    return nil
+                }
+
+                //Ignore files (.dupeignore), never scan ignore file itself
+                if ignores != nil {
+                    if ignores.matches(file, d.IsDir()) {
+                        fmt.Fprintf(verboseIO, "Ignoring %s\n", file)
+                        if d.IsDir() {
+                            return filepath.SkipDir
+                        }
+                        return nil
+                    }
+                    if d.IsDir() {
+                        ignores.load(file)
+                    } else if d.Name() == ignoreFileName {
+                        return nil
+                    }
+                }
+
                 //Directory
-                if fi.IsDir() {
+                if d.IsDir() {
                     return nil //continue, descend into directory
                 }
 
                 //Regular file
                 //Skip symlinks (a symlink target might be deleted as duplicate)
-                if fi.Mode().IsRegular() {
+                if d.Type().IsRegular() {
+                    //Stop walk if file limit reached
+                    if scan.maxFilesReached(count) {
+                        return errMaxFiles
+                    }
+
+                    //Include and exclude patterns
+                    if !scan.pathRegexMatches(file) {
+                        return nil
+                    }
+
+                    //File info (size, time) only needed for regular files
+                    fi, err := d.Info()
+                    if err != nil {
+                        return nil //file gone
+                    }
+                    if checkDevice && !scan.sameDevice(d, rootDevice) {
+                        return nil //other filesystem
+                    }
+                    if !scan.ownerMatches(fi) {
+                        return nil //other owner
+                    }
+                    if !scan.ageMatches(fi) {
+                        return nil //too new or too old
+                    }
+
                     //Scan this file
                     count++
-                    fpi := FilePathInfo{file, fi}
-                    foundFiles <- fpi //send it to workers
+                    fpi := FilePathInfo{file: file, fi: fi, root: path}
+                    if err := sendFile(fpi); err != nil {
+                        return err
+                    }
+
+                    //Scan files in archive
+                    if scan.ScanArchives && scan.FS == nil && isArchive(d.Name()) {
+                        return scanArchive(file)
+                    }
+                    return nil
                 }
 
                 return nil
-            })
+            }
+        }
+
+        //Send files in archive to workers, keep archive open until scan is done
+        var archiveClosers []io.Closer
+        defer func() {
+            for _, closer := range archiveClosers {
+                closer.Close()
+            }
+        }()
+        scanArchive = func(file string) error {
+            fsys, closer, entries, err := openArchive(file)
+            if err != nil {
+                fmt.Fprintf(verboseIO, "Error opening archive %s: %s\n", file, err)
+                return nil //skip archive
+            }
+            if closer != nil {
+                archiveClosers = append(archiveClosers, closer)
+            }
+            for _, entry := range entries {
+                if scan.maxFilesReached(count) {
+                    return errMaxFiles
+                }
+                count++
+                fpi := FilePathInfo{
+                    file: file + archiveSeparator + entry.name,
+                    fi: entry.fi,
+                    fsys: fsys,
+                    archive: file,
+                }
+                if err := sendFile(fpi); err != nil {
+                    return err
+                }
+            }
+            return nil
+        }
+
+        //Scan virtual filesystem or search paths recursively
+        if scan.FS != nil {
+            fmt.Fprintf(verboseIO, "Scanning virtual filesystem ...\n")
+            fs.WalkDir(scan.FS, ".", walkFunc(".", nil))
+        }
+        for _, path := range scan.Paths {
+            if scan.FS != nil || ctx.Err() != nil || scan.maxFilesReached(count) {
+                break
+            }
+
+            //Search path (base)
+            fmt.Fprintf(verboseIO, "Scanning %s ...\n", path)
+            var ignores *ignoreList
+            if scan.UseIgnoreFiles {
+                ignores = newIgnoreList(path)
+            }
+            if scan.OneFilesystem {
+                if fi, err := os.Stat(path); err == nil {
+                    if device, ok := fileDevice(fi); ok {
+                        devices[path] = device
+                    }
+                }
+            }
+            if scan.ParallelWalk {
+                parallelWalkDir(ctx, path, scan.walkParallelism(), walkFunc(path, ignores))
+            } else {
+                filepath.WalkDir(path, walkFunc(path, ignores))
+            }
+        }
+
+        //Scan files specified directly (not walked)
+        for _, file := range scan.DirectFiles {
+            if scan.FS != nil || scan.maxFilesReached(count) {
+                break
+            }
+            fi, err := os.Lstat(file)
+            if err != nil || !fi.Mode().IsRegular() || !scan.ownerMatches(fi) || !scan.ageMatches(fi) {
+                continue
+            }
+            if !scan.pathRegexMatches(file) {
+                continue
+            }
+            count++
+            fpi := FilePathInfo{file: file, fi: fi}
+            if sendFile(fpi) != nil {
+                break
+            }
         }
         close(foundFiles) //tell workers there are no more files
-        foundCountSignal <- count //total number of files to wait for
         fmt.Fprintf(verboseIO, "Found %d files\n", count)
+        if scan.maxFilesReached(count) {
+            fmt.Fprintf(verboseIO, "File limit reached (%d files)\n", scan.MaxFiles)
+        }
 
         //Wait for results, put results in map (add or update)
-        wgDone.Wait() //wait for all workers
+        //Workers must be done reading from the map before it's updated
+        wgDone.Wait() //wait for collector
+        wgWorkers.Wait() //wait for all workers
         for _, file := range collectedFiles {
+            if file.ScanError != "" {
+                //Hash failed, old entry (if any) is outdated
+                scan.ErrorFiles = append(scan.ErrorFiles, file)
+                delete(scan.Files, file.Path)
+                continue
+            }
             scan.Files[file.Path] = file
         }
+        if ctx.Err() != nil {
+            fmt.Fprintf(verboseIO, "Scan cancelled, %d files processed\n", len(collectedFiles))
+        }
+
+        //Fast mode, fully hash files with matching samples
+        if scan.FastMode && ctx.Err() == nil {
+            scan.verifySamples()
+        }
 
         //Rebuild hash files map
+        buildStartTime := time.Now()
         scan.BuildHashFilesMap()
+        buildDuration := time.Since(buildStartTime)
+
+        //Totals
+        fmt.Fprintf(verboseIO, "Scan complete: %d files found, %d hashed (%d B), %d from cache, %d skipped (error), elapsed %s\n",
+            scan.Stats.Found, scan.Stats.Hashed, scan.Stats.BytesHashed, scan.Stats.Cached,
+            scan.Stats.Failed, time.Since(startTime).Round(time.Millisecond))
+        fmt.Fprintf(verboseIO, "Hash map built in %s (%d groups)\n",
+            buildDuration.Round(time.Microsecond), len(scan.HashFilesMap))
 
     }()
 }
 
-func (scan *Scan) scanFileWorker(foundFiles <-chan FilePathInfo, newFiles chan<- *File) {
-    for fpi := range foundFiles {
-        //Scan file (this worker is running in the background)
-        scan.scanFile(fpi.file, fpi.fi, newFiles)
+func pathDepth(root, file string) int {
+    //Number of path elements below root, 0 for root itself
+    rel, err := filepath.Rel(root, file)
+    if err != nil || rel == "." {
+        return 0
     }
+    return strings.Count(rel, string(filepath.Separator)) + 1
 }
 
-func (scan *Scan) scanFile(file string, fi os.FileInfo, newFiles chan<- *File) {
+func (scan *Scan) maxFilesReached(count int) bool {
+    return scan.MaxFiles > 0 && count >= scan.MaxFiles
+}
+
+func (scan *Scan) ownerMatches(fi os.FileInfo) bool {
+    //Owner and group filter, no filter if ownership unknown (Windows)
+    if !scan.FilterByOwner && !scan.FilterByGroup {
+        return true
+    }
+    uid, gid, ok := fileOwner(fi)
+    if !ok {
+        return true
+    }
+    if scan.FilterByOwner && uid != scan.OwnerUID {
+        return false
+    }
+    if scan.FilterByGroup && gid != scan.OwnerGID {
+        return false
+    }
+    return true
+}
+
+func (scan *Scan) ageMatches(fi os.FileInfo) bool {
+    //Age filter, by modification time relative to now
+    age := time.Since(fi.ModTime())
+    if scan.ExcludeNewerThan > 0 && age < scan.ExcludeNewerThan {
+        return false
+    }
+    if scan.ExcludeOlderThan > 0 && age > scan.ExcludeOlderThan {
+        return false
+    }
+    return true
+}
+
+func (scan *Scan) addAccessError(path string, err error) {
+    scan.accessMutex.Lock()
+    defer scan.accessMutex.Unlock()
+    scan.AccessErrors = append(scan.AccessErrors, AccessError{path, err})
+}
+
+func (scan *Scan) sameDevice(d fs.DirEntry, device uint64) bool {
+    //Same filesystem, assume so if unknown
+    fi, err := d.Info()
+    if err != nil {
+        return true
+    }
+    other, ok := fileDevice(fi)
+    return !ok || other == device
+}
+
+func (scan *Scan) isExcludedDirName(name string) bool {
+    for _, excludedName := range scan.ExcludeDirNames {
+        if name == excludedName {
+            return true
+        }
+    }
+    return false
+}
+
+func (scan *Scan) pathRegexMatches(file string) bool {
+    //Include patterns first (any of them), then exclude patterns
+    //Patterns are matched against the path with forward slashes
+    path := filepath.ToSlash(file)
+    if len(scan.IncludePathRegex) > 0 {
+        var included bool
+        for _, re := range scan.IncludePathRegex {
+            if re.MatchString(path) {
+                included = true
+                break
+            }
+        }
+        if !included {
+            return false
+        }
+    }
+    for _, re := range scan.ExcludePathRegex {
+        if re.MatchString(path) {
+            return false
+        }
+    }
+    return true
+}
+
+func (scan *Scan) isExcluded(file string) bool {
+    if len(scan.ExcludePaths) == 0 {
+        return false
+    }
+    abs, err := filepath.Abs(file)
+    if err != nil {
+        return false
+    }
+    //Excluded paths may be resolved (symlinks), resolve only if name matches
+    for _, excludePath := range scan.ExcludePaths {
+        if abs == excludePath {
+            return true
+        }
+        if filepath.Base(abs) == filepath.Base(excludePath) && resolvePath(abs) == excludePath {
+            return true
+        }
+    }
+    return false
+}
+
+func (scan *Scan) scanFileWorker(foundFiles <-chan FilePathInfo, newFiles chan<- *File, scanErrors chan<- ScanError, done <-chan struct{}) {
+    for fpi := range foundFiles {
+        //Scan file (this worker is running in the background)
+        //A panic caused by a single file must not crash the whole scan
+        func() {
+            defer func() {
+                if r := recover(); r != nil {
+                    err := fmt.Errorf("Panic while scanning file: %v", r)
+                    scan.countFile(&scan.Stats.Failed)
+                    select {
+                    case scanErrors <- ScanError{fpi.file, err}:
+                    case <-done:
+                    }
+                }
+            }()
+            scan.scanFile(fpi, newFiles, scanErrors, done)
+        }()
+        scan.updateProgress(func(p *ScanProgress) {
+            p.ScannedCount++
+            p.ScannedBytes += fpi.fi.Size()
+            p.CurrentFile = fpi.file
+        })
+    }
+}
+
+func (scan *Scan) scanFile(fpi FilePathInfo, newFiles chan<- *File, scanErrors chan<- ScanError, done <-chan struct{}) {
+    file, fi := fpi.file, fpi.fi
+
+    //Skip file if nobody is waiting for it anymore (scan cancelled)
+    select {
+    case <-done:
+        return
+    default:
+    }
+
     //New file object
+    //Files in virtual filesystem have no absolute path
     fullPath, err := filepath.Abs(file)
     if err != nil {
+        scan.countFile(&scan.Stats.Failed)
         return
     }
-    newFile := &File{ Path: file }
+    fsys := scan.FS
+    if fpi.archive != "" {
+        //Archive entry, full path of archive
+        fsys = fpi.fsys
+        archivePath, err := filepath.Abs(fpi.archive)
+        if err != nil {
+            scan.countFile(&scan.Stats.Failed)
+            return
+        }
+        fullPath = archivePath + strings.TrimPrefix(file, fpi.archive)
+    } else if fsys != nil {
+        fullPath = file
+    }
+    newFile := &File{ Path: file, fsys: fsys }
     newFile.FullPath = fullPath
+    newFile.ArchivePath = fpi.archive
     newFile.Name = fi.Name()
     newFile.Size = fi.Size()
     newFile.ModificationTime = fi.ModTime().Unix()
+    newFile.CreationTime = getCreationTime(fi) //macOS/BSD only
 
-    //Get inode number, if possible
-    if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
-        newFile.Inum = uint64(stat.Ino)
+    //Broken filesystem entry, mtime can't be used to detect changes
+    if scan.WarnZeroMtime && newFile.ModificationTime == 0 {
+        select {
+        case scanErrors <- ScanError{file, ErrZeroMtime}:
+        case <-done:
+        }
     }
+
+    //Path relative to search path
+    if fpi.root != "" && fpi.archive == "" && fsys == nil {
+        newFile.ComputeRelativePath(fpi.root)
+    }
+
+    //Get inode number, if possible (not on network shares)
+    newFile.Inum = fileInode(fi)
     fmt.Fprintf(verboseIO, "File: %s\n", file)
 
+    //Hash algorithm
+    algorithm := scan.hashAlgorithm()
+
     //Check for old file object
     oldFile, found := scan.Files[newFile.Path]
-    if found && oldFile.IsHashed() {
+    if seededFile, seeded := scan.seededFiles[newFile.FullPath]; !found && seeded {
+        oldFile, found = seededFile, true //hash from sum file
+    }
+    unchanged := false
+    if found && !oldFile.ModifiedDuringScan && (oldFile.HashOf(algorithm) != "" || oldFile.SampleHash != "") {
         //File already in map, probably imported
         //Stat file, check size and time
         probablyIdentical := newFile.LooksIdentical(oldFile)
         if probablyIdentical {
+            unchanged = true
             //File already in map (imported)
             //Mtime unchanged, so content assumed to be unchanged as well
             newFile.MD5 = oldFile.MD5
             newFile.SHA1 = oldFile.SHA1
+            newFile.SHA256 = oldFile.SHA256
+            newFile.BLAKE2b = oldFile.BLAKE2b
+            newFile.BLAKE3 = oldFile.BLAKE3
+            newFile.Hashes = oldFile.Hashes
+            newFile.SampleHash = oldFile.SampleHash
+            newFile.HashSizeLimit = oldFile.HashSizeLimit
             fmt.Fprintf(verboseIO, "File already in map: %s\n", file)
         }
     }
 
+    //Count new, updated and unchanged files (incremental scan)
+    scan.statsMutex.Lock()
+    if !found {
+        scan.Stats.New++
+    } else if unchanged {
+        scan.Stats.Unchanged++
+    } else {
+        scan.Stats.Updated++
+    }
+    scan.statsMutex.Unlock()
+
     //Calculate hash (slow!) unless imported
-    if !newFile.IsHashed() {
-        fmt.Fprintf(verboseIO, "Hashing file: %s\n", file)
-        if err := newFile.Hash(); err != nil {
-            return
+    //In fast mode, only samples are hashed, full hash is calculated later
+    //Imported files without SHA1 are hashed again if SHA1 is needed
+    if newFile.HashOf(algorithm) == "" || (scan.ComputeSHA1 && newFile.SHA1 == "" && !newFile.HashSizeLimit) {
+        if scan.FastMode {
+            if newFile.SampleHash == "" {
+                fmt.Fprintf(verboseIO, "Hashing file samples: %s\n", file)
+                if err := newFile.HashSample(); err != nil {
+                    newFile.ScanError = err.Error()
+                    scan.countFile(&scan.Stats.Failed)
+                } else {
+                    scan.addBytesHashed(newFile.Size)
+                    scan.countFile(&scan.Stats.Hashed)
+                }
+            } else {
+                scan.countFile(&scan.Stats.Cached)
+            }
+        } else if reused, claimed := scan.reuseInodeHash(newFile, fi, algorithm, done); reused {
+            //Hardlink of a file that has already been hashed
+            fmt.Fprintf(verboseIO, "Hash taken from hardlink: %s\n", file)
+            scan.countFile(&scan.Stats.Cached)
+        } else {
+            fmt.Fprintf(verboseIO, "Hashing file: %s\n", file)
+            var hashedFile *File //shared with hardlinks unless hashing failed
+            defer scan.storeInodeHash(claimed, &hashedFile)
+            var err error
+            if scan.SkipLockedFiles && !newFile.IsVirtual() {
+                //Skip file locked by another process
+                if locked, _ := isFileLocked(file); locked {
+                    err = ErrFileLocked
+                }
+            }
+            if err == nil {
+                err = scan.hashFile(newFile, algorithm)
+            }
+            if errors.Is(err, ErrModifiedDuringScan) {
+                err = nil //hashed, size changed meanwhile (see checkModified)
+            }
+            if errors.Is(err, ErrFileLocked) || errors.Is(err, ErrHashPanic) {
+                //Report file rather than dropping it silently
+                //Sent as error file below, old entry (imported hash) is removed
+                newFile.ScanError = err.Error()
+                scan.countFile(&scan.Stats.Failed)
+                select {
+                case scanErrors <- ScanError{file, err}:
+                case <-done:
+                }
+            } else if err != nil {
+                //Hash failed, access error if file could not be opened (like permission denied)
+                var pathErr *fs.PathError
+                if errors.As(err, &pathErr) && pathErr.Op == "open" {
+                    scan.addAccessError(file, err)
+                }
+                newFile.ScanError = err.Error()
+                scan.countFile(&scan.Stats.Failed)
+            } else {
+                scan.addBytesHashed(scan.hashedSize(newFile))
+                scan.countFile(&scan.Stats.Hashed)
+                if scan.checkModified(newFile, algorithm) {
+                    select {
+                    case scanErrors <- ScanError{file, ErrModifiedDuringScan}:
+                    case <-done:
+                    }
+                } else {
+                    hashedFile = newFile
+                }
+            }
+        }
+    } else {
+        //Hash from imported map or sum file
+        scan.countFile(&scan.Stats.Cached)
+    }
+
+    //Return new file object (also if hashing failed), discard it if collector has stopped
+    select {
+    case newFiles <- newFile:
+    case <-done:
+    }
+}
+
+func (scan *Scan) verifySamples() {
+    algorithm := scan.hashAlgorithm()
+
+    //Group files by size, only files of equal size can be identical
+    sizeMap := make(map[int64]FileList)
+    for _, file := range scan.Files {
+        if file.Size == 0 {
+            continue //empty files are never listed as duplicates
+        }
+        sizeMap[file.Size] = append(sizeMap[file.Size], file)
+    }
+
+    //Collect files with matching samples that have not been hashed yet
+    var candidates FileList
+    for _, files := range sizeMap {
+        if len(files) < 2 {
+            continue
+        }
+        sampleMap := make(map[string]FileList)
+        for _, file := range files {
+            if file.SampleHash == "" {
+                //Imported file hashed without samples
+                if err := file.HashSample(); err != nil {
+                    continue
+                }
+            }
+            sampleMap[file.SampleHash] = append(sampleMap[file.SampleHash], file)
+        }
+        for _, sampleFiles := range sampleMap {
+            if len(sampleFiles) < 2 {
+                continue
+            }
+            for _, file := range sampleFiles {
+                if file.HashOf(algorithm) == "" {
+                    candidates = append(candidates, file)
+                }
+            }
+        }
+    }
+    fmt.Fprintf(verboseIO, "Fast mode: %d files need full verification\n", len(candidates))
+
+    //Fully hash candidates (in parallel)
+    candidateFiles := make(chan *File)
+    var wg sync.WaitGroup
+    for i := 0; i < scan.workerCount(); i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for file := range candidateFiles {
+                fmt.Fprintf(verboseIO, "Hashing file: %s\n", file.Path)
+                err := safeHash(file, []string{algorithm}, scan.MmapThreshold)
+                if errors.Is(err, ErrModifiedDuringScan) {
+                    file.ModifiedDuringScan = true //hash may not match size
+                }
+                if err != nil {
+                    fmt.Fprintf(verboseIO, "Error hashing file %s: %s\n", file.Path, err)
+                    continue
+                }
+                scan.addBytesHashed(file.Size)
+            }
+        }()
+    }
+    for _, file := range candidates {
+        candidateFiles <- file
+    }
+    close(candidateFiles)
+    wg.Wait()
+    scan.FastModeVerified = len(candidates)
+}
+
+func (scan *Scan) updateProgress(update func(*ScanProgress)) {
+    scan.progressMutex.Lock()
+    defer scan.progressMutex.Unlock()
+    update(&scan.progressState)
+    if scan.progress == nil {
+        return
+    }
+
+    //Never block, replace old value if it hasn't been received yet
+    select {
+    case scan.progress <- scan.progressState:
+        return
+    default:
+    }
+    select {
+    case <-scan.progress:
+    default:
+    }
+    scan.progress <- scan.progressState
+}
+
+func (scan *Scan) countFile(counter *int) {
+    //Result of scanning a file (Hashed, Cached or Failed)
+    scan.statsMutex.Lock()
+    scan.Stats.Found++
+    *counter++
+    scan.statsMutex.Unlock()
+}
+
+func (scan *Scan) addBytesHashed(size int64) {
+    scan.statsMutex.Lock()
+    scan.Stats.BytesHashed += size
+    scan.statsMutex.Unlock()
+    scan.updateProgress(func(p *ScanProgress) {
+        p.BytesHashed += size
+    })
+}
+
+func (scan *Scan) closeProgress() {
+    scan.progressMutex.Lock()
+    defer scan.progressMutex.Unlock()
+    if scan.progress != nil {
+        close(scan.progress)
+        scan.progress = nil
+    }
+}
+
+func safeHash(file *File, algorithms []string, mmapThreshold int64) (err error) {
+    //Turn panic in hash function into error
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%w: %v", ErrHashPanic, r)
         }
+    }()
+    if mmapThreshold > 0 && file.Size >= mmapThreshold {
+        return file.HashAllMmap(algorithms)
+    }
+    return file.HashAll(algorithms)
+}
+
+func (scan *Scan) checkModified(file *File, algorithm string) bool {
+    //Compare size and mtime after hashing, content may have changed while it was read
+    //Hash again once if requested, file is marked if it's still changing
+    if file.IsVirtual() {
+        return false
+    }
+    stat := func() (os.FileInfo, bool) {
+        fi, err := os.Stat(file.Path)
+        if err != nil {
+            return nil, false //gone, will be removed by next scan
+        }
+        return fi, fi.Size() != file.Size || fi.ModTime().Unix() != file.ModificationTime
+    }
+    fi, changed := stat()
+    if changed && scan.RetryModifiedFiles {
+        fmt.Fprintf(verboseIO, "File modified while hashing, hashing again: %s\n", file.Path)
+        file.Size = fi.Size()
+        file.ModificationTime = fi.ModTime().Unix()
+        if err := scan.hashFile(file, algorithm); err == nil || errors.Is(err, ErrModifiedDuringScan) {
+            scan.addBytesHashed(scan.hashedSize(file))
+            fi, changed = stat()
+        }
+    }
+    if changed {
+        //Final size and mtime, hash is not used (not grouped, hashed again next time)
+        file.Size = fi.Size()
+        file.ModificationTime = fi.ModTime().Unix()
+    }
+    file.ModifiedDuringScan = changed
+
+    return changed
+}
+
+func (scan *Scan) hashFile(file *File, algorithm string) error {
+    //Partial hash for files over the size limit, full hash otherwise
+    if scan.HashSizeLimit > 0 && file.Size > scan.HashSizeLimit {
+        return file.HashPartial(scan.HashSizeLimit, algorithm)
+    }
+    algorithms := []string{algorithm}
+    if scan.ComputeSHA1 && algorithm != "sha1" {
+        algorithms = append(algorithms, "sha1")
+    }
+    return safeHash(file, algorithms, scan.MmapThreshold)
+}
+
+func (scan *Scan) hashedSize(file *File) int64 {
+    //Bytes read to hash file
+    if file.HashSizeLimit && file.Size > scan.HashSizeLimit {
+        return scan.HashSizeLimit
+    }
+    return file.Size
+}
+
+func (scan *Scan) hashAlgorithm() string {
+    if scan.HashAlgorithm == "" {
+        return "md5" //md5 by default
+    }
+    return scan.HashAlgorithm
+}
+
+func (scan *Scan) reuseInodeHash(file *File, fi os.FileInfo, algorithm string, done <-chan struct{}) (bool, *inodeHash) {
+    //Copy hash from another path of the same inode (hardlink)
+    //First path claims the inode and hashes it (returned), others wait for its hash
+    if !scan.DedupeByInode || scan.inodeFiles == nil || !file.HasInode() || file.IsVirtual() {
+        return false, nil
+    }
+    device, ok := fileDevice(fi)
+    if !ok {
+        return false, nil
+    }
+    claim := &inodeHash{hashed: make(chan struct{})}
+    value, found := scan.inodeFiles.LoadOrStore(inodeKey{device, file.Inum}, claim)
+    if !found {
+        return false, claim
+    }
+    entry := value.(*inodeHash)
+    select {
+    case <-entry.hashed:
+    case <-done:
+        return false, nil
+    }
+    hashedFile := entry.file
+    if hashedFile == nil || hashedFile.Size != file.Size || hashedFile.ModificationTime != file.ModificationTime ||
+        hashedFile.HashOf(algorithm) == "" {
+        return false, nil //failed or changed in the meantime, hash this path
     }
+    file.MD5 = hashedFile.MD5
+    file.SHA1 = hashedFile.SHA1
+    file.SHA256 = hashedFile.SHA256
+    file.BLAKE2b = hashedFile.BLAKE2b
+    file.BLAKE3 = hashedFile.BLAKE3
+    file.Hashes = hashedFile.Hashes
+    file.HashSizeLimit = hashedFile.HashSizeLimit
+    return true, nil
+}
 
-    //Return new file object
-    newFiles <- newFile
+func (scan *Scan) storeInodeHash(claim *inodeHash, hashedFile **File) {
+    //Release claimed inode, waiting paths take the hash (or hash the file themselves if nil)
+    if claim == nil {
+        return
+    }
+    claim.file = *hashedFile
+    close(claim.hashed)
+}
+
+func (scan *Scan) walkParallelism() int {
+    if scan.WalkParallelism <= 0 {
+        return 4 //4 directories by default
+    }
+    return scan.WalkParallelism
+}
+
+func (scan *Scan) workerCount() int {
+    if scan.WorkerCount == 0 {
+        return 1 //1 worker by default
+    }
+    return scan.WorkerCount
 }
 
 func (scan *Scan) BuildHashFilesMap() map[string]Files {
     //Build hash map (hash -> file list)
+    fmt.Fprintf(verboseIO, "Building hash map (%d files)...\n", len(scan.Files))
     hashMap := make(map[string]Files)
     for _, file := range scan.Files {
-        if !file.IsHashed() {
+        if !file.IsHashed() || file.ScanError != "" || file.ModifiedDuringScan {
             //File not hashed, error
             continue
         }
-        hash := file.HashValue()
+        hash := file.HashValueFor(scan.hashAlgorithm())
         filesGroup := Files{
             sort: scan.SortOrder,
             reverse: scan.SortReversed,
@@ -387,16 +1608,74 @@ func (scan *Scan) BuildHashFilesMap() map[string]Files {
         hashMap[hash] = filesGroup //update list
     }
 
+    //Split groups with files of different sizes (hash collision)
+    if scan.VerifyGroupSizes {
+        for hash, files := range hashMap {
+            remaining := files.Files
+            key := hash
+            for len(remaining) > 0 {
+                cleaned, warnings := verifyGroupConsistency(hash, remaining)
+                for _, warning := range warnings {
+                    fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+                }
+                files.Files = cleaned
+                hashMap[key] = files
+                if len(cleaned) == len(remaining) {
+                    break
+                }
+
+                //Files left out go into a separate group
+                var other FileList
+                for _, file := range remaining {
+                    if file.Size != cleaned[0].Size {
+                        other = append(other, file)
+                    }
+                }
+                remaining = other
+                key = fmt.Sprintf("%s-%d", hash, remaining[0].Size)
+            }
+        }
+    }
+
     //Sort
     for _, files := range hashMap {
         sort.Sort(files)
     }
 
+    //Identical files (same hash) must have the same size
+    for hash, files := range hashMap {
+        if len(files.Files) > 1 {
+            scan.checkGroupSizes(hash, files.Files)
+        }
+    }
+
     scan.HashFilesMap = hashMap
     return hashMap
 }
 
+func verifyGroupConsistency(hash string, files FileList) (FileList, []string) {
+    //Keep files with the same size as the first one
+    var cleaned FileList
+    var warnings []string
+    for _, file := range files {
+        if file.Size != files[0].Size {
+            warnings = append(warnings,
+                fmt.Sprintf("Hash collision, %s (%d B) and %s (%d B) have the same hash %s",
+                    files[0].Path, files[0].Size, file.Path, file.Size, hash))
+            continue
+        }
+        cleaned = append(cleaned, file)
+    }
+
+    return cleaned, warnings
+}
+
 func (scan *Scan) DuplicatesMap() map[string]FileList {
+    //Hardlinks (same inode) count as one file, actions and summary rely on that
+    return scan.duplicatesMap(false)
+}
+
+func (scan *Scan) duplicatesMap(includeHardlinks bool) map[string]FileList {
     duplicates := make(map[string]FileList)
 
     //Go through hash map (files grouped by hash)
@@ -415,7 +1694,7 @@ func (scan *Scan) DuplicatesMap() map[string]FileList {
         addedInums = nil
         FILES:
         for _, file := range fileList {
-            if file.Inum != 0 {
+            if file.HasInode() && !includeHardlinks {
                 for _, otherInum := range addedInums {
                     if otherInum == file.Inum {
                         continue FILES
@@ -431,6 +1710,32 @@ func (scan *Scan) DuplicatesMap() map[string]FileList {
             continue
         }
 
+        //Only files with a copy in the same directory, skip group if there are none
+        if scan.SameDirOnly {
+            duplicateFiles = sameDirFiles(duplicateFiles)
+            if len(duplicateFiles) < 2 {
+                continue
+            }
+        }
+
+        //Skip group if number of copies is out of range
+        if len(duplicateFiles) < scan.MinCopies {
+            continue
+        }
+        if scan.MaxCopies > 0 && len(duplicateFiles) > scan.MaxCopies {
+            continue
+        }
+
+        //Skip group if file names differ
+        if scan.SameNameOnly && !sameNames(duplicateFiles) {
+            continue
+        }
+
+        //Skip group if all files have the same name
+        if scan.DifferentNameOnly && sameNames(duplicateFiles) {
+            continue
+        }
+
         //Add list of duplicates for current hash (identical files)
         duplicates[hash] = duplicateFiles
 
@@ -439,11 +1744,123 @@ func (scan *Scan) DuplicatesMap() map[string]FileList {
     return duplicates
 }
 
+func (scan *Scan) checkGroupSizes(hash string, files FileList) {
+    //Report files with a different size than the first one, once per file
+    scan.sizeMismatchMutex.Lock()
+    defer scan.sizeMismatchMutex.Unlock()
+    for _, file := range files[1:] {
+        if file.Size == files[0].Size || scan.sizeMismatches[file] {
+            continue
+        }
+        if scan.sizeMismatches == nil {
+            scan.sizeMismatches = make(map[*File]bool)
+        }
+        scan.sizeMismatches[file] = true
+        err := fmt.Errorf("%w: %d B, %s has %d B (%s)", ErrSizeMismatch,
+            file.Size, files[0].Path, files[0].Size, hash)
+        scan.ScanErrors = append(scan.ScanErrors, ScanError{Path: file.Path, Err: err})
+        fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", file.Path, err)
+    }
+}
+
+func (scan *Scan) SameNameFiles() map[string]FileList {
+    //Files with the same name (case-insensitive on Windows), content may differ
+    //Only names that occur more than once, files sorted by hash and path
+    algorithm := scan.hashAlgorithm()
+    byName := make(map[string]FileList)
+    for _, file := range scan.Files {
+        name := file.Name
+        if runtime.GOOS == "windows" {
+            name = strings.ToLower(name)
+        }
+        byName[name] = append(byName[name], file)
+    }
+    for name, files := range byName {
+        if len(files) < 2 {
+            delete(byName, name)
+            continue
+        }
+        sort.Slice(files, func(i, j int) bool {
+            hashI, hashJ := files[i].HashValueFor(algorithm), files[j].HashValueFor(algorithm)
+            if hashI != hashJ {
+                return hashI < hashJ
+            }
+            return files[i].Path < files[j].Path
+        })
+    }
+    return byName
+}
+
+func sameDirFiles(files FileList) FileList {
+    //Files in a directory that contains more than one of the given files
+    dirCount := make(map[string]int)
+    for _, file := range files {
+        dirCount[filepath.Dir(file.Path)]++
+    }
+    var sameDir FileList
+    for _, file := range files {
+        if dirCount[filepath.Dir(file.Path)] > 1 {
+            sameDir = append(sameDir, file)
+        }
+    }
+    return sameDir
+}
+
+func sameNames(files FileList) bool {
+    for _, file := range files {
+        if file.Name != files[0].Name {
+            return false
+        }
+    }
+    return true
+}
+
+func (scan *Scan) keptFile(files FileList) *File {
+    //File to be kept in a duplicate group
+    //Files on disk are preferred, a copy in an archive must not be the only one left
+    var onDisk FileList
+    for _, file := range files {
+        if !file.IsVirtual() {
+            onDisk = append(onDisk, file)
+        }
+    }
+    if len(onDisk) > 0 {
+        files = onDisk
+    }
+    if scan.KeepLongestPath {
+        //Longest path, alphabetical order if paths have the same length
+        kept := files[0]
+        for _, file := range files[1:] {
+            if len(file.Path) > len(kept.Path) ||
+                (len(file.Path) == len(kept.Path) && file.Path < kept.Path) {
+                kept = file
+            }
+        }
+        return kept
+    }
+    if scan.KeepLast {
+        return files[len(files) - 1]
+    }
+    return files[0]
+}
+
+func (scan *Scan) additionalFiles(files FileList) FileList {
+    //Files in a duplicate group except the one to be kept
+    kept := scan.keptFile(files)
+    additional := make(FileList, 0, len(files) - 1)
+    for _, file := range files {
+        if file != kept {
+            additional = append(additional, file)
+        }
+    }
+    return additional
+}
+
 func (scan *Scan) AdditionalFilesMap() map[string]FileList {
     additional := make(map[string]FileList)
 
     for hash, files := range scan.DuplicatesMap() {
-        additional[hash] = files[1:]
+        additional[hash] = scan.additionalFiles(files)
     }
 
     return additional
@@ -459,6 +1876,122 @@ func (scan *Scan) AdditionalFiles() FileList {
     return additionalFiles
 }
 
+func (scan *Scan) UniqueFiles() FileList {
+    //Files without any identical copy (hardlinks of the same file count as one)
+    //Empty files are left out, like in the duplicates map
+    var uniqueFiles FileList
+    for _, files := range scan.HashFilesMap {
+        fileList := files.Files
+        if fileList[0].Size == 0 {
+            continue
+        }
+        unique := true
+        for _, file := range fileList[1:] {
+            if !file.HasInode() || file.Inum != fileList[0].Inum {
+                unique = false
+                break
+            }
+        }
+        if unique {
+            uniqueFiles = append(uniqueFiles, fileList...)
+        }
+    }
+
+    //Sort like duplicate groups, by path if otherwise equal
+    sort.Slice(uniqueFiles, func(i, j int) bool {
+        return uniqueFiles[i].Path < uniqueFiles[j].Path
+    })
+    sort.Stable(Files{uniqueFiles, scan.SortOrder, scan.SortReversed})
+
+    return uniqueFiles
+}
+
+func (scan *Scan) LargestUniqueFiles(n int) FileList {
+    //Top 10 by default, largest first
+    if n <= 0 {
+        n = 10
+    }
+    uniqueFiles := scan.UniqueFiles()
+    sort.SliceStable(uniqueFiles, func(i, j int) bool {
+        return uniqueFiles[i].Size > uniqueFiles[j].Size
+    })
+    if len(uniqueFiles) > n {
+        uniqueFiles = uniqueFiles[:n]
+    }
+
+    return uniqueFiles
+}
+
+func (scan *Scan) Summary() string {
+    //Summary table (multiple lines)
+    var b strings.Builder
+    totalFileCount := len(scan.Files)
+    totalFilesSize := uint64(scan.TotalFilesSize())
+    groupCount := len(scan.DuplicatesMap())
+    duplicatesSize := uint64(scan.DuplicatesSize())
+    duplicateCount := len(scan.AdditionalFiles())
+    if emptyFileCount := scan.EmptyFileCount(); emptyFileCount > 0 {
+        fmt.Fprintf(&b, "Files:\t\t\t%d (%d excluding empty files)\n",
+            totalFileCount, totalFileCount - emptyFileCount)
+    } else {
+        fmt.Fprintf(&b, "Files:\t\t\t%d\n", totalFileCount)
+    }
+    fmt.Fprintf(&b, "Total size:\t\t%s (%d B)\n",
+        humanize.IBytes(totalFilesSize), totalFilesSize)
+    fmt.Fprintf(&b, "Duplicate groups:\t%d\n", groupCount)
+    fmt.Fprintf(&b, "Duplicate count:\t%d\n", duplicateCount)
+    fmt.Fprintf(&b, "Size of duplicates:\t%s (%d B)\n",
+        humanize.IBytes(duplicatesSize), duplicatesSize)
+    fmt.Fprintf(&b, "Unique files:\t\t%d\n", len(scan.UniqueFiles()))
+
+    //Files found in this scan (not imported only)
+    if scan.scanned {
+        fmt.Fprintf(&b, "Files found:\t\t%d\n", scan.Stats.Found)
+        fmt.Fprintf(&b, "Files hashed:\t\t%d\n", scan.Stats.Hashed)
+        fmt.Fprintf(&b, "Files from cache:\t%d\n", scan.Stats.Cached)
+        fmt.Fprintf(&b, "Files skipped (error):\t%d\n", scan.Stats.Failed)
+    }
+
+    //Incremental scan (imported map)
+    if scan.importedMaps > 0 && scan.scanned {
+        fmt.Fprintf(&b, "Unchanged files:\t%d\n", scan.Stats.Unchanged)
+        fmt.Fprintf(&b, "New files:\t\t%d\n", scan.Stats.New)
+        fmt.Fprintf(&b, "Updated files:\t\t%d\n", scan.Stats.Updated)
+        fmt.Fprintf(&b, "Removed files:\t\t%d\n", scan.Stats.Removed)
+    }
+    if len(scan.ErrorFiles) > 0 {
+        fmt.Fprintf(&b, "Hash errors:\t\t%d\n", len(scan.ErrorFiles))
+    }
+    if scan.FastMode {
+        fmt.Fprintf(&b, "Fast mode:\t\t%d full verifications needed\n",
+            scan.FastModeVerified)
+    }
+
+    return b.String()
+}
+
+func (scan *Scan) SummaryStats() SummaryStats {
+    return SummaryStats{
+        TotalFiles: len(scan.Files),
+        TotalSize: scan.TotalFilesSize(),
+        EmptyFiles: scan.EmptyFileCount(),
+        DuplicateGroups: len(scan.DuplicatesMap()),
+        DuplicateFiles: len(scan.AdditionalFiles()),
+        DuplicatesSize: scan.DuplicatesSize(),
+        Stats: scan.Stats,
+    }
+}
+
+func (scan *Scan) Fingerprint() string {
+    //SHA-256 of all paths and hashes, sorted by path
+    //Same fingerprint means nothing has changed
+    h := sha256.New()
+    for _, path := range scan.Files.Keys() {
+        fmt.Fprintf(h, "%s\x00%s\n", path, scan.Files[path].HashValueFor(scan.hashAlgorithm()))
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
 func (scan *Scan) TotalFilesSize() int64 {
     var size int64
     for _, file := range scan.Files {
@@ -468,11 +2001,47 @@ func (scan *Scan) TotalFilesSize() int64 {
     return size
 }
 
+func (scan *Scan) TotalRegularFilesSize() int64 {
+    //Total size without empty files
+    var size int64
+    for _, file := range scan.Files {
+        if file.Size > 0 {
+            size += file.Size
+        }
+    }
+
+    return size
+}
+
+func (scan *Scan) EmptyFileCount() int {
+    var count int
+    for _, file := range scan.Files {
+        if file.Size == 0 {
+            count++
+        }
+    }
+
+    return count
+}
+
+func (scan *Scan) PartialHashGroups() int {
+    //Duplicate groups compared by partial hash (-size-limit-per-file), might not be identical
+    var count int
+    for _, files := range scan.DuplicatesMap() {
+        if files[0].HashSizeLimit {
+            count++
+        }
+    }
+
+    return count
+}
+
 func (scan *Scan) DuplicatesSize() int64 {
     var size int64
 
     //Sum file sizes of additional files (duplicates - 1 per group)
     //5 identical files (in group) = 4 additional files
+    //Sizes are checked in BuildHashFilesMap (ErrSizeMismatch)
     for _, files := range scan.AdditionalFilesMap() {
         duplicatesCount := len(files)
         var duplicatesSize int64
@@ -483,3 +2052,360 @@ func (scan *Scan) DuplicatesSize() int64 {
     return size
 }
 
+type DeleteReport struct {
+    Freed int64
+    Deleted int
+    Failed int
+}
+
+type LinkReport struct {
+    Saved int64
+    Linked int
+    Failed int
+}
+
+func partialHashGroup(files FileList) bool {
+    //Group matched by partial hash (-size-limit-per-file), files might differ
+    for _, file := range files {
+        if file.HashSizeLimit {
+            return true
+        }
+    }
+    return false
+}
+
+func (scan *Scan) actionGroups() map[string]FileList {
+    //Duplicate groups for delete, link, symlink and rename
+    //Groups matched by partial hash are refused, they're only listed
+    groups := scan.DuplicatesMap()
+    for hash, files := range groups {
+        if partialHashGroup(files) {
+            fmt.Fprintf(os.Stderr, "Not touching files with partial hashes, verify them manually: %s\n", files[0].Path)
+            delete(groups, hash)
+        }
+    }
+    return groups
+}
+
+func (scan *Scan) DeleteDuplicates(filePath func(*File) string) DeleteReport {
+    var report DeleteReport
+
+    //Delete duplicates (keep first or last one per group)
+    //Groups without a file on disk are skipped
+    for _, files := range scan.actionGroups() {
+        if scan.keptFile(files).IsVirtual() {
+            continue
+        }
+        for _, file := range scan.additionalFiles(files) {
+            if file.IsVirtual() {
+                continue //not on disk
+            }
+            path := filePath(file)
+            err := os.Remove(path)
+            if err != nil {
+                fmt.Fprintf(os.Stderr,
+                    "Error deleting file %s: %s\n", path, err.Error())
+                report.Failed++
+                continue
+            }
+            fmt.Fprintf(outputIO, "Deleted %s\n", path)
+            report.Freed += file.Size
+            report.Deleted++
+        }
+    }
+
+    return report
+}
+
+func absoluteFilePath(file *File) string {
+    //Full path, resolved now if missing (imported)
+    if file.FullPath != "" {
+        return file.FullPath
+    }
+    if path, err := filepath.Abs(file.Path); err == nil {
+        return path
+    }
+    return file.Path
+}
+
+func crossDeviceFiles(pairs [][2]*File, filePath func(*File) string) map[*File]bool {
+    //Duplicates on another filesystem than the kept file, can't be linked
+    //All of them are reported before anything is linked
+    devices := make(map[*File]uint64)
+    device := func(file *File) (uint64, bool) {
+        if dev, found := devices[file]; found {
+            return dev, true
+        }
+        fi, err := os.Stat(filePath(file))
+        if err != nil {
+            return 0, false
+        }
+        dev, ok := fileDevice(fi)
+        if ok {
+            devices[file] = dev
+        }
+        return dev, ok
+    }
+    crossDevice := make(map[*File]bool)
+    for _, pair := range pairs {
+        keptDevice, ok := device(pair[0])
+        if !ok {
+            continue
+        }
+        if dev, ok := device(pair[1]); ok && dev != keptDevice {
+            fmt.Fprintf(os.Stderr, "Not linking file on different filesystem: %s (%s)\n",
+                filePath(pair[1]), filePath(pair[0]))
+            crossDevice[pair[1]] = true
+        }
+    }
+    return crossDevice
+}
+
+func (scan *Scan) LinkDuplicates(filePath func(*File) string) LinkReport {
+    var report LinkReport
+
+    //Absolute paths, independent of working directory
+    if scan.LinkFullPaths {
+        filePath = absoluteFilePath
+    }
+
+    //Pairs of kept file and duplicate, files not on disk are skipped
+    var pairs [][2]*File
+    for _, files := range scan.actionGroups() {
+        keptFile := scan.keptFile(files)
+        if keptFile.IsVirtual() {
+            continue //not on disk
+        }
+        for _, file := range scan.additionalFiles(files) {
+            if !file.IsVirtual() {
+                pairs = append(pairs, [2]*File{keptFile, file})
+            }
+        }
+    }
+    crossDevice := crossDeviceFiles(pairs, filePath)
+    report.Failed += len(crossDevice)
+
+    //Replace duplicates with links
+    for _, pair := range pairs {
+        keptFile, file := pair[0], pair[1]
+        if crossDevice[file] {
+            continue
+        }
+
+        //Create hardlink in destination directory
+        //Replace duplicate only if hardlink created successfully
+        duplicateFilePath := filePath(file)
+        keptFilePath := filePath(keptFile)
+        dir := filepath.Dir(duplicateFilePath) //hardlink directory
+        prefix := "DUPE"
+        f, err := ioutil.TempFile(dir, prefix)
+        if err != nil {
+            //Directory not writable, like a read-only network share
+            fmt.Fprintf(os.Stderr,
+                "Error writing to directory %s (read-only filesystem or share?): %s\n",
+                dir, err.Error())
+            report.Failed++
+            continue
+        }
+        tmpFilePath := f.Name()
+        f.Close()
+        os.Remove(tmpFilePath)
+
+        //Create hardlink using temporary (new) file
+        //Fails if duplicate is on another filesystem
+        if err := os.Link(keptFilePath, tmpFilePath); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error creating link: %s\n",
+                err.Error())
+            report.Failed++
+            continue
+        }
+
+        //Replace duplicate with link
+        if err := os.Rename(tmpFilePath, duplicateFilePath); err != nil {
+            fmt.Fprintf(os.Stderr,
+                "Error replacing file %s with link: %s\n",
+                duplicateFilePath, err.Error())
+            os.Remove(tmpFilePath)
+            report.Failed++
+            continue
+        }
+        fmt.Fprintf(outputIO, "Replaced %s\n", duplicateFilePath)
+        report.Saved += file.Size
+        report.Linked++
+    }
+
+    return report
+}
+
+func symlinkTarget(keptFilePath, duplicateFilePath string, relative bool) (string, error) {
+    //Absolute path of kept file, or path relative to the duplicate's directory
+    keptAbs, err := filepath.Abs(keptFilePath)
+    if err != nil {
+        return "", err
+    }
+    if !relative {
+        return keptAbs, nil
+    }
+    duplicateAbs, err := filepath.Abs(duplicateFilePath)
+    if err != nil {
+        return "", err
+    }
+    return filepath.Rel(filepath.Dir(duplicateAbs), keptAbs)
+}
+
+func verifySymlink(link, target, keptFilePath string) error {
+    //Symlink must point to target and resolve to the kept file
+    linkTarget, err := os.Readlink(link)
+    if err != nil {
+        return err
+    }
+    if linkTarget != target {
+        return fmt.Errorf("Symlink %s points to %s instead of %s", link, linkTarget, target)
+    }
+    resolve := func(path string) (string, error) {
+        resolved, err := filepath.EvalSymlinks(path)
+        if err != nil {
+            return "", err
+        }
+        return filepath.Abs(resolved)
+    }
+    resolved, err := resolve(link)
+    if err != nil {
+        return err
+    }
+    keptResolved, err := resolve(keptFilePath)
+    if err != nil {
+        return err
+    }
+    if resolved != keptResolved {
+        return fmt.Errorf("Symlink %s resolves to %s instead of %s", link, resolved, keptResolved)
+    }
+    return nil
+}
+
+func (scan *Scan) SymlinkDuplicates(filePath func(*File) string) LinkReport {
+    var report LinkReport
+
+    //Replace duplicates with symlinks to the kept file
+    //Absolute targets by default, relative ones break if files are moved
+    for _, files := range scan.actionGroups() {
+        keptFile := scan.keptFile(files)
+        if keptFile.IsVirtual() {
+            continue //not on disk
+        }
+        keptFilePath := filePath(keptFile)
+        for _, file := range scan.additionalFiles(files) {
+            if file.IsVirtual() {
+                continue
+            }
+            duplicateFilePath := filePath(file)
+            target, err := symlinkTarget(keptFilePath, duplicateFilePath, scan.SymlinkRelative)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error creating symlink: %s\n", err.Error())
+                report.Failed++
+                continue
+            }
+
+            //Move duplicate out of the way (temporary file in same directory)
+            dir := filepath.Dir(duplicateFilePath)
+            f, err := ioutil.TempFile(dir, "DUPE")
+            if err != nil {
+                fmt.Fprintf(os.Stderr,
+                    "Error writing to directory %s (read-only filesystem or share?): %s\n",
+                    dir, err.Error())
+                report.Failed++
+                continue
+            }
+            backupFilePath := f.Name()
+            f.Close()
+            if err := os.Rename(duplicateFilePath, backupFilePath); err != nil {
+                fmt.Fprintf(os.Stderr, "Error moving file %s: %s\n", duplicateFilePath, err.Error())
+                os.Remove(backupFilePath)
+                report.Failed++
+                continue
+            }
+
+            //Create and verify symlink, restore duplicate if anything went wrong
+            err = os.Symlink(target, duplicateFilePath)
+            if err == nil {
+                err = verifySymlink(duplicateFilePath, target, keptFilePath)
+                if err != nil {
+                    os.Remove(duplicateFilePath)
+                }
+            }
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error creating symlink: %s\n", err.Error())
+                if err := os.Rename(backupFilePath, duplicateFilePath); err != nil {
+                    fmt.Fprintf(os.Stderr, "Error restoring file %s from %s: %s\n",
+                        duplicateFilePath, backupFilePath, err.Error())
+                }
+                report.Failed++
+                continue
+            }
+            os.Remove(backupFilePath)
+            fmt.Fprintf(outputIO, "Replaced %s with symlink to %s\n", duplicateFilePath, target)
+            report.Saved += file.Size
+            report.Linked++
+        }
+    }
+
+    return report
+}
+
+func renameTarget(path string, suffix string) string {
+    //Path with suffix, numbered if taken (.dup, .dup2, .dup3, ...)
+    target := path + suffix
+    for n := 2; ; n++ {
+        if _, err := os.Lstat(target); os.IsNotExist(err) {
+            return target
+        }
+        target = fmt.Sprintf("%s%s%d", path, suffix, n)
+    }
+}
+
+func (scan *Scan) RenameDuplicates(suffix string, dryRun bool, filePath func(*File) string) error {
+    //Rename duplicates (all but the kept file per group) instead of deleting them
+    //Renamed files are removed from the map, so they're no longer listed as duplicates
+    if suffix == "" {
+        return fmt.Errorf("Suffix required to rename duplicates")
+    }
+    var renamed []string
+    var failed int
+    for _, files := range scan.actionGroups() {
+        if scan.keptFile(files).IsVirtual() {
+            continue //no file on disk
+        }
+        for _, file := range scan.additionalFiles(files) {
+            if file.IsVirtual() {
+                continue //not on disk
+            }
+            path := filePath(file)
+            target := renameTarget(path, suffix)
+            if dryRun {
+                fmt.Fprintf(outputIO, "Would rename %s to %s\n", path, target)
+                continue
+            }
+            if err := os.Rename(path, target); err != nil {
+                fmt.Fprintf(os.Stderr,
+                    "Error renaming file %s: %s\n", path, err.Error())
+                failed++
+                continue
+            }
+            fmt.Fprintf(outputIO, "Renamed %s to %s\n", path, target)
+            renamed = append(renamed, file.Path)
+        }
+    }
+
+    //Hash map rebuilt without renamed files
+    if len(renamed) > 0 {
+        if err := scan.RemoveFromMapBatch(renamed); err != nil {
+            return err
+        }
+    }
+    if failed > 0 {
+        return fmt.Errorf("Failed to rename %d files", failed)
+    }
+
+    return nil
+}
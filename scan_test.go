@@ -0,0 +1,1950 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "hash"
+    "sort"
+    "sync"
+    "bytes"
+    "errors"
+    "io/fs"
+    "strings"
+    "time"
+    "context"
+    "testing"
+    "reflect"
+    "crypto/md5"
+    "encoding/json"
+    "path/filepath"
+    "testing/fstest"
+)
+
+func TestMain(m *testing.M) {
+    //Test binary run as dupefinder (runMain)
+    if os.Getenv("DUPEFINDER_TEST_MAIN") != "" {
+        main()
+    }
+
+    //No listing or verbose output in tests
+    verboseIO = io.Discard
+    outputIO = io.Discard
+    os.Exit(m.Run())
+}
+
+func writeTestFiles(t testing.TB, dir string, files map[string]string) {
+    //Create files (path relative to dir -> content)
+    t.Helper()
+    for name, content := range files {
+        path := filepath.Join(dir, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func runScan(t testing.TB, scan *Scan) {
+    t.Helper()
+    var wg sync.WaitGroup
+    wg.Add(1)
+    scan.Scan(&wg)
+    wg.Wait()
+}
+
+func scanTestDir(t testing.TB, paths ...string) *Scan {
+    //New scan of given paths with default options
+    t.Helper()
+    scan := NewScan()
+    scan.Paths = paths
+    runScan(t, scan)
+    return scan
+}
+
+func groupPaths(duplicates map[string]FileList) [][]string {
+    //Duplicate groups as sorted lists of paths, sorted by first path
+    var groups [][]string
+    for _, files := range duplicates {
+        var paths []string
+        for _, file := range files {
+            paths = append(paths, file.Path)
+        }
+        sort.Strings(paths)
+        groups = append(groups, paths)
+    }
+    sort.Slice(groups, func(i, j int) bool {
+        return groups[i][0] < groups[j][0]
+    })
+    return groups
+}
+
+func TestExportDuplicatesMap(t *testing.T) {
+    tests := []struct {
+        name string
+        files map[string]string
+        links map[string]string //link -> existing file
+        sameNameOnly bool
+        metadata bool
+        groups int
+        exported int //files in compact map
+    }{
+        {"two groups", map[string]string{
+            "a": "same", "sub/b": "same", "c": "other", "d": "other", "unique": "unique",
+        }, nil, false, false, 2, 4},
+        {"no duplicates", map[string]string{
+            "a": "one", "b": "two",
+        }, nil, false, false, 0, 0},
+        {"empty files", map[string]string{
+            "a": "", "b": "", "c": "x", "d": "x",
+        }, nil, false, false, 1, 4},
+        {"hardlinks", map[string]string{
+            "a": "same", "unique": "unique",
+        }, map[string]string{"a2": "a"}, false, false, 0, 2},
+        {"filtered groups", map[string]string{
+            "a": "same", "sub/b": "same", "c": "other", "sub/c": "other",
+        }, nil, true, false, 1, 4},
+        {"metadata", map[string]string{
+            "a": "same", "sub/b": "same", "unique": "unique",
+        }, nil, false, true, 1, 2},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            searchPath := filepath.Join(dir, "files")
+            writeTestFiles(t, searchPath, test.files)
+            for link, file := range test.links {
+                if err := os.Link(filepath.Join(searchPath, file), filepath.Join(searchPath, link)); err != nil {
+                    t.Skip(err)
+                }
+            }
+            newScan := func() *Scan {
+                scan := NewScan()
+                scan.Paths = []string{searchPath}
+                scan.SameNameOnly = test.sameNameOnly
+                scan.ExportMetadata = test.metadata
+                return scan
+            }
+            scan := newScan()
+            runScan(t, scan)
+            if groups := len(scan.DuplicatesMap()); groups != test.groups {
+                t.Fatalf("Found %d duplicate groups, expected %d", groups, test.groups)
+            }
+
+            fullMap := filepath.Join(dir, "full.json")
+            compactMap := filepath.Join(dir, "compact.json")
+            if err := scan.ExportMap(fullMap); err != nil {
+                t.Fatal(err)
+            }
+            if err := scan.ExportDuplicatesMap(compactMap); err != nil {
+                t.Fatal(err)
+            }
+
+            //Scan again with either map imported, result must be the same
+            rescan := func(mapFile string) *Scan {
+                scan := newScan()
+                if err := scan.ImportMap(mapFile); err != nil {
+                    t.Fatal(err)
+                }
+                runScan(t, scan)
+                return scan
+            }
+            fullScan := rescan(fullMap)
+            compactScan := rescan(compactMap)
+            expected := groupPaths(scan.DuplicatesMap())
+            if got := groupPaths(fullScan.DuplicatesMap()); !reflect.DeepEqual(got, expected) {
+                t.Errorf("Full map import: %v, expected %v", got, expected)
+            }
+            if got := groupPaths(compactScan.DuplicatesMap()); !reflect.DeepEqual(got, expected) {
+                t.Errorf("Compact map import: %v, expected %v", got, expected)
+            }
+
+            //Compact map has no unique files, written like the full map
+            imported := NewScan()
+            if err := imported.ImportMap(compactMap); err != nil {
+                t.Fatal(err)
+            }
+            if len(imported.Files) != test.exported {
+                t.Errorf("Compact map contains %d files, expected %d", len(imported.Files), test.exported)
+            }
+            data, err := os.ReadFile(compactMap)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if hasMetadata := bytes.Contains(data, []byte(`"metadata"`)); hasMetadata != test.metadata {
+                t.Errorf("Metadata in compact map: %t, expected %t", hasMetadata, test.metadata)
+            }
+            if temp, _ := filepath.Glob(filepath.Join(dir, ".dupefinder-*")); len(temp) > 0 {
+                t.Errorf("Temporary files left: %v", temp)
+            }
+        })
+    }
+}
+
+func TestSymlinkDuplicates(t *testing.T) {
+    tests := []struct {
+        name string
+        relative bool
+        removeKept bool //symlink can't be resolved, duplicates are restored
+    }{
+        {"absolute", false, false},
+        {"relative", true, false},
+        {"absolute, kept file missing", false, true},
+        {"relative, kept file missing", true, true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{
+                "a": "same", "sub/b": "same", "sub/deeper/c": "same", "unique": "unique",
+            })
+            scan := scanTestDir(t, dir)
+            scan.SymlinkRelative = test.relative
+            var kept *File
+            for _, files := range scan.DuplicatesMap() {
+                kept = scan.keptFile(files)
+            }
+            if test.removeKept {
+                if err := os.Remove(kept.Path); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            report := scan.SymlinkDuplicates(func(file *File) string { return file.Path })
+            expectedLinked, expectedFailed := 2, 0
+            if test.removeKept {
+                expectedLinked, expectedFailed = 0, 2
+            }
+            if report.Linked != expectedLinked || report.Failed != expectedFailed {
+                t.Errorf("%d linked, %d failed, expected %d linked, %d failed",
+                    report.Linked, report.Failed, expectedLinked, expectedFailed)
+            }
+            for _, name := range []string{"a", "sub/b", "sub/deeper/c"} {
+                path := filepath.Join(dir, name)
+                if path == kept.Path {
+                    continue
+                }
+                fi, err := os.Lstat(path)
+                if err != nil {
+                    t.Fatalf("File missing: %s", path)
+                }
+                if test.removeKept {
+                    //Original file restored
+                    content, err := os.ReadFile(path)
+                    if !fi.Mode().IsRegular() || err != nil || string(content) != "same" {
+                        t.Errorf("File not restored: %s", path)
+                    }
+                    continue
+                }
+                target, err := os.Readlink(path)
+                if err != nil {
+                    t.Fatalf("Not a symlink: %s", path)
+                }
+                if filepath.IsAbs(target) == test.relative {
+                    t.Errorf("Symlink target %s, relative: %t", target, test.relative)
+                }
+                if err := verifySymlink(path, target, kept.Path); err != nil {
+                    t.Error(err)
+                }
+            }
+
+            //No backup files left behind
+            filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+                if err == nil && strings.HasPrefix(d.Name(), "DUPE") {
+                    t.Errorf("Backup file left: %s", path)
+                }
+                return err
+            })
+        })
+    }
+}
+
+func TestVerifySymlink(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"kept": "same", "other": "other"})
+    kept := filepath.Join(dir, "kept")
+    tests := []struct {
+        name string
+        target string //symlink target
+        expected string //expected target
+        valid bool
+    }{
+        {"absolute", kept, kept, true},
+        {"relative", "kept", "kept", true},
+        {"other target", "other", "kept", false},
+        {"other file", filepath.Join(dir, "other"), filepath.Join(dir, "other"), false},
+        {"missing target", "missing", "missing", false},
+    }
+    for i, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            link := filepath.Join(dir, fmt.Sprintf("link%d", i))
+            if err := os.Symlink(test.target, link); err != nil {
+                t.Fatal(err)
+            }
+            err := verifySymlink(link, test.expected, kept)
+            if (err == nil) != test.valid {
+                t.Errorf("Error %v, expected valid: %t", err, test.valid)
+            }
+        })
+    }
+}
+
+type modifyingHash struct {
+    hash.Hash
+    modify func() //called before the first write
+}
+
+func (h *modifyingHash) Write(p []byte) (int, error) {
+    if h.modify != nil {
+        h.modify()
+        h.modify = nil
+    }
+    return h.Hash.Write(p)
+}
+
+func TestFileModifiedDuringScan(t *testing.T) {
+    //Hash function changes the file while it's being hashed
+    var modify func()
+    RegisterHash("test-modify", func() hash.Hash {
+        return &modifyingHash{md5.New(), modify}
+    })
+    t.Cleanup(func() { delete(HashRegistry, "test-modify") })
+
+    tests := []struct {
+        name string
+        content string //written while hashing
+        times int //number of times the file is changed
+        retry bool
+        mmap bool
+        modified bool //file flagged as modified
+        err error //expected scan error
+    }{
+        {"appended", "same content, appended", 1, false, false, true, ErrModifiedDuringScan},
+        {"truncated", "same", 1, false, false, true, ErrModifiedDuringScan},
+        {"appended, retry", "same content, appended", 1, true, false, false, nil},
+        {"appended twice, retry", "same content, appended", 2, true, false, true, ErrModifiedDuringScan},
+        {"appended, mmap", "same content, appended", 1, false, true, true, ErrModifiedDuringScan},
+        {"truncated, mmap", "", 1, false, true, false, ErrHashPanic},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            path := filepath.Join(dir, "a")
+            writeTestFiles(t, dir, map[string]string{"a": "same content"})
+            changes := test.times
+            modify = func() {
+                if changes == 0 {
+                    return
+                }
+                changes--
+                content := test.content + strings.Repeat("!", changes) //different size each time
+                if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+                    t.Error(err)
+                }
+            }
+
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.HashAlgorithm = "test-modify"
+            scan.WorkerCount = 1
+            scan.RetryModifiedFiles = test.retry
+            scan.MmapThreshold = 0
+            if test.mmap {
+                scan.MmapThreshold = 1
+            }
+            runScan(t, scan)
+
+            if file, found := scan.Files[path]; found && file.ModifiedDuringScan != test.modified {
+                t.Errorf("File modified: %t, expected %t", file.ModifiedDuringScan, test.modified)
+            }
+            var err error
+            for _, scanError := range scan.ScanErrors {
+                err = scanError.Err
+            }
+            if !errors.Is(err, test.err) || (err == nil) != (test.err == nil) {
+                t.Errorf("Scan error %v, expected %v", err, test.err)
+            }
+        })
+    }
+}
+
+func TestFastMode(t *testing.T) {
+    //Samples are taken at the beginning, middle and end (64 KiB each)
+    size := 1024 * 1024
+    content := func(change int) string {
+        data := bytes.Repeat([]byte("0123456789abcdef"), size / 16)
+        if change >= 0 {
+            data[change] = 'x'
+        }
+        return string(data)
+    }
+    tests := []struct {
+        name string
+        other string //content of second file, first file is unchanged
+        groups int
+    }{
+        {"identical", content(-1), 1},
+        {"different beginning", content(0), 0},
+        {"different middle", content(size / 2), 0},
+        {"different between samples", content(size / 4), 0},
+        {"different end", content(size - 1), 0},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{"a": content(-1), "b": test.other})
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.FastMode = true
+            runScan(t, scan)
+            if groups := len(scan.DuplicatesMap()); groups != test.groups {
+                t.Errorf("%d duplicate groups, expected %d", groups, test.groups)
+            }
+        })
+    }
+}
+
+func TestExportHashFile(t *testing.T) {
+    tests := []struct {
+        name string
+        fastMode bool
+        valid bool
+    }{
+        {"full hashes", false, true},
+        {"fast mode", true, false}, //unique files have no full hash
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            searchPath := filepath.Join(dir, "files")
+            writeTestFiles(t, searchPath, map[string]string{"a": "same", "b": "same", "c": "other"})
+            scan := NewScan()
+            scan.Paths = []string{searchPath}
+            scan.FastMode = test.fastMode
+            runScan(t, scan)
+
+            //Existing hash file is only replaced by a complete one
+            sumFile := filepath.Join(dir, "MD5SUMS")
+            writeTestFiles(t, dir, map[string]string{"MD5SUMS": "old\n"})
+            err := scan.ExportMD5(sumFile)
+            if (err == nil) != test.valid {
+                t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+            }
+            data, err := os.ReadFile(sumFile)
+            if err != nil {
+                t.Fatal(err)
+            }
+            lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+            if !test.valid {
+                if string(data) != "old\n" {
+                    t.Errorf("Hash file replaced: %q", data)
+                }
+            } else if len(lines) != 3 {
+                t.Errorf("%d lines in hash file, expected 3", len(lines))
+            }
+            matches, _ := filepath.Glob(filepath.Join(dir, ".dupefinder-*"))
+            if len(matches) > 0 {
+                t.Errorf("Temporary files left: %v", matches)
+            }
+        })
+    }
+}
+
+func TestActionsPartialHash(t *testing.T) {
+    //Files over the size limit only get a partial hash, they might differ in between
+    filePath := func(file *File) string { return file.Path }
+    actions := []struct {
+        name string
+        run func(scan *Scan)
+    }{
+        {"delete", func(scan *Scan) { scan.DeleteDuplicates(filePath) }},
+        {"link", func(scan *Scan) { scan.LinkDuplicates(filePath) }},
+        {"symlink", func(scan *Scan) { scan.SymlinkDuplicates(filePath) }},
+        {"rename", func(scan *Scan) { scan.RenameDuplicates(".dup", false, filePath) }},
+    }
+    for _, action := range actions {
+        t.Run(action.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{
+                "a": "begin 1 end", "b": "begin 2 end", //partial hashes match
+                "c": "full", "d": "full", //below the limit
+            })
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.HashSizeLimit = 5
+            runScan(t, scan)
+            if groups := len(scan.DuplicatesMap()); groups != 2 {
+                t.Fatalf("%d duplicate groups, expected 2", groups)
+            }
+            if groups := scan.PartialHashGroups(); groups != 1 {
+                t.Errorf("%d groups with partial hashes, expected 1", groups)
+            }
+
+            action.run(scan)
+            for _, name := range []string{"a", "b"} {
+                fi, err := os.Lstat(filepath.Join(dir, name))
+                if err != nil || !fi.Mode().IsRegular() {
+                    t.Errorf("File with partial hash changed: %s", name)
+                }
+            }
+
+            //Other group is processed (one file replaced, linked to the other one)
+            c, errC := os.Lstat(filepath.Join(dir, "c"))
+            d, errD := os.Lstat(filepath.Join(dir, "d"))
+            unchanged := errC == nil && errD == nil && c.Mode().IsRegular() && d.Mode().IsRegular() &&
+                !os.SameFile(c, d)
+            if unchanged {
+                t.Errorf("Fully hashed files unchanged")
+            }
+        })
+    }
+
+    //Partial hashes can't be verified by sum tools, not exported
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "begin 1 end", "c": "full"})
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.HashSizeLimit = 5
+    runScan(t, scan)
+    sumFile := filepath.Join(t.TempDir(), "MD5SUMS")
+    if err := scan.ExportMD5(sumFile); err != nil {
+        t.Fatal(err)
+    }
+    data, err := os.ReadFile(sumFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(string(data), filepath.Join(dir, "a")) || !strings.Contains(string(data), filepath.Join(dir, "c")) {
+        t.Errorf("Unexpected hash file:\n%s", data)
+    }
+}
+
+func TestFixImportedPaths(t *testing.T) {
+    tests := []struct {
+        name string
+        files FileList
+        expected []string //paths after rebase, nil if rebase fails
+    }{
+        {"relative paths", FileList{
+            &File{Path: "/old/a", RelativePath: "a"},
+            &File{Path: "/old/sub/b", RelativePath: "sub/b"},
+        }, []string{"/new/a", "/new/sub/b"}},
+        {"archive entry kept", FileList{
+            &File{Path: "/old/a", RelativePath: "a"},
+            &File{Path: "/old/x.zip!/a", RelativePath: "x.zip!/a", ArchivePath: "/old/x.zip"},
+        }, []string{"/new/a", "/old/x.zip!/a"}},
+        {"same relative path", FileList{
+            &File{Path: "/old/a", RelativePath: "a"},
+            &File{Path: "/other/a", RelativePath: "a"},
+        }, nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            scan := NewScan()
+            for _, file := range test.files {
+                scan.Files[file.Path] = file
+            }
+            _, err := scan.FixImportedPaths("/new")
+            if (err == nil) != (test.expected != nil) {
+                t.Fatalf("Error %v, expected paths %v", err, test.expected)
+            }
+            if err != nil {
+                //Nothing changed
+                for _, file := range test.files {
+                    if scan.Files[file.Path] != file || !strings.HasPrefix(file.Path, "/o") {
+                        t.Errorf("File changed: %s", file.Path)
+                    }
+                }
+                return
+            }
+            if keys := scan.Files.Keys(); !reflect.DeepEqual(keys, test.expected) {
+                t.Errorf("Paths %v, expected %v", keys, test.expected)
+            }
+        })
+    }
+}
+
+func TestGroupSizeMismatch(t *testing.T) {
+    //Files with the same hash but different sizes are reported once
+    scan := NewScan()
+    a := &File{Path: "a", Size: 4, MD5: "hash"}
+    b := &File{Path: "b", Size: 4, MD5: "hash"}
+    c := &File{Path: "c", Size: 5, MD5: "hash"}
+    scan.Files = FileMap{"a": a, "b": b, "c": c}
+    scan.BuildHashFilesMap()
+    if len(scan.ScanErrors) != 1 {
+        t.Fatalf("%d scan errors after building hash map, expected 1", len(scan.ScanErrors))
+    }
+
+    //Listing doesn't report it again
+    for i := 0; i < 3; i++ {
+        scan.BuildHashFilesMap()
+        scan.DuplicatesMap()
+        scan.DuplicatesSize()
+    }
+    if len(scan.ScanErrors) != 1 {
+        t.Fatalf("%d scan errors, expected 1", len(scan.ScanErrors))
+    }
+    scanError := scan.ScanErrors[0]
+    if scanError.Path != "c" || !errors.Is(scanError.Err, ErrSizeMismatch) {
+        t.Errorf("Unexpected scan error %s: %s", scanError.Path, scanError.Err)
+    }
+}
+
+type faultyHash struct {
+    hash.Hash
+    fault func() error //called before each write, may panic
+}
+
+func (h *faultyHash) Write(p []byte) (int, error) {
+    if err := h.fault(); err != nil {
+        return 0, err
+    }
+    return h.Hash.Write(p)
+}
+
+func TestHashFailure(t *testing.T) {
+    //Hash fails after the first scan, imported entries must not be kept
+    var fault func() error
+    RegisterHash("test-fail", func() hash.Hash {
+        return &faultyHash{md5.New(), func() error { return fault() }}
+    })
+    t.Cleanup(func() { delete(HashRegistry, "test-fail") })
+
+    readError := func() error { return errors.New("Read error") }
+    panicking := func() error { panic("Hash panic") }
+    tests := []struct {
+        name string
+        fault func() error
+        rescan bool //b changed since first scan, a unchanged
+        failed int
+    }{
+        {"read error", readError, false, 2},
+        {"read error, changed file", readError, true, 1},
+        {"panic", panicking, false, 2},
+        {"panic, changed file", panicking, true, 1},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same"})
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.HashAlgorithm = "test-fail"
+            if test.rescan {
+                fault = func() error { return nil }
+                runScan(t, scan)
+                writeTestFiles(t, dir, map[string]string{"b": "changed"})
+                scan.Stats = ScanStats{}
+            }
+
+            fault = test.fault
+            runScan(t, scan)
+            path := filepath.Join(dir, "b")
+            if _, found := scan.Files[path]; found {
+                t.Errorf("File with hash error still in map")
+            }
+            var errorFile bool
+            for _, file := range scan.ErrorFiles {
+                if file.Path == path && file.ScanError != "" {
+                    errorFile = true
+                }
+            }
+            if !errorFile || len(scan.ErrorFiles) != test.failed {
+                t.Errorf("%d error files, expected %d including %s", len(scan.ErrorFiles), test.failed, path)
+            }
+            if len(scan.DuplicatesMap()) != 0 {
+                t.Errorf("Duplicates found: %v", groupPaths(scan.DuplicatesMap()))
+            }
+            if scan.Stats.Failed != test.failed {
+                t.Errorf("%d files failed, expected %d", scan.Stats.Failed, test.failed)
+            }
+        })
+    }
+}
+
+func TestSkipLockedFiles(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "same"})
+    locked := filepath.Join(dir, "b")
+    lockFile(t, locked)
+
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.SkipLockedFiles = true
+    runScan(t, scan)
+
+    //Locked file reported, other files still grouped
+    if len(scan.ErrorFiles) != 1 || scan.ErrorFiles[0].Path != locked {
+        t.Fatalf("Error files %v, expected %s", scan.ErrorFiles, locked)
+    }
+    if !strings.Contains(scan.ErrorFiles[0].ScanError, ErrFileLocked.Error()) {
+        t.Errorf("Scan error %q, expected %q", scan.ErrorFiles[0].ScanError, ErrFileLocked)
+    }
+    if _, found := scan.Files[locked]; found {
+        t.Errorf("Locked file still in map")
+    }
+    if groups := groupPaths(scan.DuplicatesMap()); len(groups) != 1 || len(groups[0]) != 2 {
+        t.Errorf("Groups %v, expected a and c", groups)
+    }
+}
+
+type panickingHash struct {
+    hash.Hash
+}
+func (h *panickingHash) Write(p []byte) (int, error) {
+    if bytes.Contains(p, []byte("panic")) {
+        panic("Hash panic")
+    }
+    return h.Hash.Write(p)
+}
+
+func TestHashPanic(t *testing.T) {
+    //Registered hash panics on one file, scan goes on with the others
+    RegisterHash("test-panic", func() hash.Hash { return &panickingHash{md5.New()} })
+    t.Cleanup(func() { delete(HashRegistry, "test-panic") })
+
+    dir := t.TempDir()
+    files := map[string]string{"panic": "panic"}
+    for i := 0; i < 20; i++ {
+        files[fmt.Sprintf("%02d", i)] = fmt.Sprintf("content %d", i % 2)
+    }
+    writeTestFiles(t, dir, files)
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.HashAlgorithm = "test-panic"
+    runScan(t, scan)
+
+    path := filepath.Join(dir, "panic")
+    if len(scan.ErrorFiles) != 1 || scan.ErrorFiles[0].Path != path {
+        t.Fatalf("Error files %v, expected %s", scan.ErrorFiles, path)
+    }
+    if !strings.Contains(scan.ErrorFiles[0].ScanError, ErrHashPanic.Error()) {
+        t.Errorf("Scan error %q, expected %q", scan.ErrorFiles[0].ScanError, ErrHashPanic)
+    }
+    if len(scan.Files) != 20 {
+        t.Errorf("%d files hashed, expected 20", len(scan.Files))
+    }
+    if groups := groupPaths(scan.DuplicatesMap()); len(groups) != 2 || len(groups[0]) != 10 || len(groups[1]) != 10 {
+        t.Errorf("Groups %v, expected 2 groups of 10", groups)
+    }
+}
+
+func TestDedupeByInode(t *testing.T) {
+    //Hardlinks found at the same time, inode hashed once
+    var hashed int
+    var mutex sync.Mutex
+    RegisterHash("test-count", func() hash.Hash {
+        return &faultyHash{md5.New(), func() error {
+            mutex.Lock()
+            hashed++
+            mutex.Unlock()
+            time.Sleep(10 * time.Millisecond) //other paths found meanwhile
+            return nil
+        }}
+    })
+    t.Cleanup(func() { delete(HashRegistry, "test-count") })
+
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"00": "same"})
+    for i := 1; i < 10; i++ {
+        if err := os.Link(filepath.Join(dir, "00"), filepath.Join(dir, fmt.Sprintf("%02d", i))); err != nil {
+            t.Skip(err)
+        }
+    }
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.HashAlgorithm = "test-count"
+    scan.WorkerCount = 10
+    runScan(t, scan)
+
+    if hashed != 1 {
+        t.Errorf("Hashed %d times, expected once", hashed)
+    }
+    if len(scan.Files) != 10 {
+        t.Fatalf("%d files, expected 10", len(scan.Files))
+    }
+    value := scan.Files[filepath.Join(dir, "00")].HashOf("test-count")
+    for path, file := range scan.Files {
+        if file.HashOf("test-count") != value || value == "" {
+            t.Errorf("Hash of %s: %q, expected %q", path, file.HashOf("test-count"), value)
+        }
+    }
+}
+
+func TestKeepLast(t *testing.T) {
+    //Newest file first (-sort-time), reversed: oldest first
+    tests := []struct {
+        reversed bool
+        keepLast bool
+        kept string
+    }{
+        {false, false, "new"},
+        {false, true, "old"},
+        {true, false, "old"},
+        {true, true, "new"},
+    }
+    for _, test := range tests {
+        t.Run(fmt.Sprintf("reversed %t, keep last %t", test.reversed, test.keepLast), func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{"old": "same", "middle": "same", "new": "same"})
+            for i, name := range []string{"old", "middle", "new"} {
+                mtime := time.Unix(int64(1000 * (i + 1)), 0)
+                if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.SortOrder = SortByTime
+            scan.SortReversed = test.reversed
+            scan.KeepLast = test.keepLast
+            runScan(t, scan)
+
+            kept := filepath.Join(dir, test.kept)
+            for _, files := range scan.AdditionalFilesMap() {
+                if len(files) != 2 {
+                    t.Errorf("%d additional files, expected 2", len(files))
+                }
+                for _, file := range files {
+                    if file.Path == kept {
+                        t.Errorf("Kept file listed as additional: %s", file.Path)
+                    }
+                }
+            }
+            if report := scan.DeleteDuplicates(func(file *File) string { return file.Path }); report.Deleted != 2 {
+                t.Fatalf("%d files deleted, expected 2", report.Deleted)
+            }
+            entries, err := os.ReadDir(dir)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(entries) != 1 || entries[0].Name() != test.kept {
+                t.Errorf("Files left: %v, expected %s", entries, test.kept)
+            }
+        })
+    }
+}
+
+func TestActionReports(t *testing.T) {
+    //Freed and saved space of files that were actually replaced
+    files := map[string]string{
+        "a1": "same", "a2": "same", "a3": "same", //2 x 4 B
+        "b1": "other!", "b2": "other!", //6 B
+        "unique": "unique",
+    }
+    tests := []struct {
+        name string
+        fail string //file that can't be deleted or replaced
+        count int
+        size int64
+        failed int
+    }{
+        {"all", "", 3, 14, 0},
+        {"one failed", "b", 2, 8, 1},
+    }
+    for _, test := range tests {
+        //Failing file is replaced by a missing path
+        filePath := func(file *File) string {
+            if test.fail != "" && strings.HasPrefix(file.Name, test.fail) {
+                return file.Path + ".missing"
+            }
+            return file.Path
+        }
+        t.Run("delete " + test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, files)
+            scan := scanTestDir(t, dir)
+            report := scan.DeleteDuplicates(filePath)
+            expected := DeleteReport{Freed: test.size, Deleted: test.count, Failed: test.failed}
+            if report != expected {
+                t.Errorf("Report %+v, expected %+v", report, expected)
+            }
+        })
+        t.Run("link " + test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, files)
+            scan := scanTestDir(t, dir)
+            report := scan.LinkDuplicates(filePath)
+            expected := LinkReport{Saved: test.size, Linked: test.count, Failed: test.failed}
+            if report != expected {
+                t.Errorf("Report %+v, expected %+v", report, expected)
+            }
+        })
+    }
+}
+
+func TestCleanAfterChdir(t *testing.T) {
+    //Map with relative paths, imported in another working directory
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "work/files/a": "same", "work/files/b": "same", "work/files/gone": "gone",
+    })
+    if err := os.MkdirAll(filepath.Join(dir, "elsewhere"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    mapFile := filepath.Join(dir, "map.json")
+    t.Chdir(filepath.Join(dir, "work"))
+    scan := scanTestDir(t, "files")
+    if err := scan.ExportMap(mapFile); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Remove(filepath.Join(dir, "work", "files", "gone")); err != nil {
+        t.Fatal(err)
+    }
+
+    t.Chdir(filepath.Join(dir, "elsewhere"))
+    imported := NewScan()
+    if err := imported.ImportMap(mapFile); err != nil {
+        t.Fatal(err)
+    }
+    removed := imported.Clean()
+    if len(removed) != 1 || removed[0].Name != "gone" {
+        t.Errorf("Removed %v, expected only the deleted file", removed)
+    }
+    if len(imported.Files) != 2 || len(imported.DuplicatesMap()) != 1 {
+        t.Errorf("%d files left in %d groups, expected 2 in 1 group", len(imported.Files), len(imported.DuplicatesMap()))
+    }
+    if imported.Stats.Removed != 1 {
+        t.Errorf("%d files counted as removed, expected 1", imported.Stats.Removed)
+    }
+}
+
+func TestMapMetadata(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "other"})
+    for _, withMetadata := range []bool{true, false} {
+        t.Run(fmt.Sprintf("metadata %t", withMetadata), func(t *testing.T) {
+            scan := scanTestDir(t, dir)
+            scan.ExportMetadata = withMetadata
+            before := time.Now().Add(-time.Second)
+            var buf bytes.Buffer
+            if err := scan.ExportMapTo(&buf); err != nil {
+                t.Fatal(err)
+            }
+
+            imported := NewScan()
+            if err := imported.ImportMapFrom(&buf); err != nil {
+                t.Fatal(err)
+            }
+            if len(imported.Files) != 3 {
+                t.Errorf("%d files imported, expected 3", len(imported.Files))
+            }
+            metadata := imported.ImportedMetadata
+            if !withMetadata {
+                if metadata != nil {
+                    t.Errorf("Unexpected metadata: %+v", metadata)
+                }
+                return
+            }
+            if metadata == nil {
+                t.Fatalf("Metadata missing")
+            }
+            hostname, _ := os.Hostname()
+            if metadata.Hostname != hostname || metadata.DupefinderVersion != Version || metadata.TotalFiles != 3 {
+                t.Errorf("Unexpected metadata: %+v", metadata)
+            }
+            if !reflect.DeepEqual(metadata.ScanPaths, []string{dir}) {
+                t.Errorf("Scan paths %v, expected %v", metadata.ScanPaths, []string{dir})
+            }
+            if metadata.CreatedAt.Before(before) || metadata.CreatedAt.After(time.Now()) {
+                t.Errorf("Created at %s, expected now", metadata.CreatedAt)
+            }
+        })
+    }
+}
+
+func TestVerifyGroupSizes(t *testing.T) {
+    //Same hash, different sizes (collision), split if VerifyGroupSizes is set
+    for _, verify := range []bool{false, true} {
+        t.Run(fmt.Sprintf("verify %t", verify), func(t *testing.T) {
+            scan := NewScan()
+            scan.VerifyGroupSizes = verify
+            scan.Files = FileMap{
+                "a": &File{Path: "a", Size: 4, MD5: "hash"},
+                "b": &File{Path: "b", Size: 4, MD5: "hash"},
+                "c": &File{Path: "c", Size: 5, MD5: "hash"},
+                "d": &File{Path: "d", Size: 5, MD5: "hash"},
+            }
+            hashMap := scan.BuildHashFilesMap()
+            if !verify {
+                if len(hashMap) != 1 {
+                    t.Errorf("%d groups, expected 1", len(hashMap))
+                }
+                return
+            }
+            var groups [][]string
+            for _, files := range hashMap {
+                var paths []string
+                for _, file := range files.Files {
+                    paths = append(paths, file.Path)
+                }
+                groups = append(groups, paths)
+            }
+            sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+            expected := [][]string{{"a", "b"}, {"c", "d"}}
+            if !reflect.DeepEqual(groups, expected) {
+                t.Errorf("Groups %v, expected %v", groups, expected)
+            }
+            if len(scan.DuplicatesMap()) != 2 {
+                t.Errorf("%d duplicate groups, expected 2", len(scan.DuplicatesMap()))
+            }
+        })
+    }
+
+    //Cleaned list and warning
+    files := FileList{&File{Path: "a", Size: 4}, &File{Path: "c", Size: 5}, &File{Path: "b", Size: 4}}
+    cleaned, warnings := verifyGroupConsistency("hash", files)
+    if len(cleaned) != 2 || cleaned[0].Path != "a" || cleaned[1].Path != "b" {
+        t.Errorf("Cleaned list %v, expected a and b", cleaned)
+    }
+    if len(warnings) != 1 || !strings.Contains(warnings[0], "c (5 B)") {
+        t.Errorf("Warnings %v, expected one for c", warnings)
+    }
+}
+
+func TestIncrementalScanStats(t *testing.T) {
+    tests := []struct {
+        name string
+        change func(t *testing.T, dir string) //after first scan
+        unchanged, new, updated, removed int
+    }{
+        {"unchanged", func(t *testing.T, dir string) {}, 2, 0, 0, 0},
+        {"new file", func(t *testing.T, dir string) {
+            writeTestFiles(t, dir, map[string]string{"c": "new"})
+        }, 2, 1, 0, 0},
+        {"updated file", func(t *testing.T, dir string) {
+            writeTestFiles(t, dir, map[string]string{"b": "changed content"})
+        }, 1, 0, 1, 0},
+        {"removed file", func(t *testing.T, dir string) {
+            if err := os.Remove(filepath.Join(dir, "b")); err != nil {
+                t.Fatal(err)
+            }
+        }, 1, 0, 0, 1},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{"a": "a", "b": "b"})
+            var buf bytes.Buffer
+            if err := scanTestDir(t, dir).ExportMapTo(&buf); err != nil {
+                t.Fatal(err)
+            }
+            test.change(t, dir)
+
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            if err := scan.ImportMapFrom(&buf); err != nil {
+                t.Fatal(err)
+            }
+            scan.Clean()
+            runScan(t, scan)
+            stats := scan.Stats
+            if stats.Unchanged != test.unchanged || stats.New != test.new ||
+                stats.Updated != test.updated || stats.Removed != test.removed {
+                t.Errorf("Unchanged %d, new %d, updated %d, removed %d, expected %d, %d, %d, %d",
+                    stats.Unchanged, stats.New, stats.Updated, stats.Removed,
+                    test.unchanged, test.new, test.updated, test.removed)
+            }
+        })
+    }
+}
+
+func TestMaxFiles(t *testing.T) {
+    dir := t.TempDir()
+    files := make(map[string]string)
+    for i := 0; i < 50; i++ {
+        files[fmt.Sprintf("%02d/file", i % 5) + fmt.Sprint(i)] = "same"
+    }
+    writeTestFiles(t, dir, files)
+    for _, parallelWalk := range []bool{false, true} {
+        scan := NewScan()
+        scan.Paths = []string{dir}
+        scan.MaxFiles = 10
+        scan.ParallelWalk = parallelWalk
+        runScan(t, scan)
+        if len(scan.Files) != 10 {
+            t.Errorf("Parallel walk %t: %d files scanned, expected 10", parallelWalk, len(scan.Files))
+        }
+    }
+}
+
+func TestProgressMonotonic(t *testing.T) {
+    //Counters never go back, even with several workers
+    dir := t.TempDir()
+    files := make(map[string]string)
+    for i := 0; i < 200; i++ {
+        files[fmt.Sprintf("%d/%d", i % 10, i)] = strings.Repeat("x", i % 20 + 1)
+    }
+    writeTestFiles(t, dir, files)
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.WorkerCount = 4
+    var wg sync.WaitGroup
+    wg.Add(1)
+    scan.Scan(&wg)
+    var values []ScanProgress
+    for p := range scan.Progress {
+        values = append(values, p)
+    }
+    wg.Wait()
+
+    if len(values) == 0 {
+        t.Fatal("No progress received")
+    }
+    for i := 1; i < len(values); i++ {
+        prev, p := values[i - 1], values[i]
+        if p.ScannedCount < prev.ScannedCount || p.TotalCount < prev.TotalCount ||
+            p.ScannedBytes < prev.ScannedBytes || p.TotalBytes < prev.TotalBytes ||
+            p.BytesHashed < prev.BytesHashed {
+            t.Errorf("Progress %d went back: %+v after %+v", i, p, prev)
+        }
+    }
+    if last := values[len(values) - 1]; last.ScannedCount != len(files) {
+        t.Errorf("Scanned %d files, expected %d", last.ScannedCount, len(files))
+    }
+}
+
+func TestReset(t *testing.T) {
+    //Second scan of another tree, nothing left from the first one, options kept
+    dir := t.TempDir()
+    first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+    writeTestFiles(t, first, map[string]string{"a": "same", "b": "same", "c": "unique"})
+    writeTestFiles(t, second, map[string]string{"x": "other", "y": "other"})
+    scan := NewScan()
+    scan.Paths = []string{first}
+    scan.WorkerCount = 3
+    runScan(t, scan)
+    if len(scan.DuplicatesMap()) != 1 {
+        t.Fatalf("First scan: %d duplicate groups, expected 1", len(scan.DuplicatesMap()))
+    }
+
+    scan.Reset()
+    if scan.WorkerCount != 3 || len(scan.Paths) != 1 {
+        t.Errorf("Options not kept: %d workers, paths %v", scan.WorkerCount, scan.Paths)
+    }
+    scan.Paths = []string{second}
+    runScan(t, scan)
+
+    for path := range scan.Files {
+        if !strings.HasPrefix(path, second) {
+            t.Errorf("File from first scan: %s", path)
+        }
+    }
+    if len(scan.Files) != 2 {
+        t.Errorf("%d files, expected 2", len(scan.Files))
+    }
+    expected := [][]string{{filepath.Join(second, "x"), filepath.Join(second, "y")}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+    expectedStats := ScanStats{New: 2, Found: 2, Hashed: 2, BytesHashed: 10}
+    if scan.Stats != expectedStats {
+        t.Errorf("Stats %+v, expected %+v", scan.Stats, expectedStats)
+    }
+}
+
+func TestImportMapOverride(t *testing.T) {
+    //Same path in several maps, last import wins
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "first.json": `[
+            {"path": "a", "full_path": "/a", "name": "a", "size": 3, "md5": "abc"},
+            {"path": "b", "full_path": "/b", "name": "b", "size": 3, "md5": "abc"}
+        ]`,
+        "second.json": `[
+            {"path": "b", "full_path": "/b", "name": "b", "size": 4, "md5": "def"},
+            {"path": "c", "full_path": "/c", "name": "c", "size": 4, "md5": "def"}
+        ]`,
+    })
+    scan := NewScan()
+    for _, name := range []string{"first.json", "second.json"} {
+        if err := scan.ImportMap(filepath.Join(dir, name)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if len(scan.Files) != 3 {
+        t.Errorf("%d files, expected 3", len(scan.Files))
+    }
+    if file := scan.Files["b"]; file == nil || file.MD5 != "def" || file.Size != 4 {
+        t.Errorf("File b %+v, expected version from second map", file)
+    }
+    expected := [][]string{{"b", "c"}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+}
+
+func TestScanFS(t *testing.T) {
+    //Virtual filesystem, no files on disk
+    scan := NewScan()
+    scan.FS = fstest.MapFS{
+        "a": {Data: []byte("same")},
+        "sub/b": {Data: []byte("same")},
+        "sub/c": {Data: []byte("other")},
+        "d": {Data: []byte("diff")}, //same size, different content
+    }
+    runScan(t, scan)
+    if len(scan.Files) != 4 {
+        t.Errorf("%d files, expected 4", len(scan.Files))
+    }
+    expected := [][]string{{"a", "sub/b"}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+    if file := scan.Files["a"]; file == nil || file.MD5 != fmt.Sprintf("%x", md5.Sum([]byte("same"))) {
+        t.Errorf("File a %+v, expected MD5 of content", file)
+    }
+}
+
+func TestAccessErrors(t *testing.T) {
+    //Unreadable directory and file reported, rest of the tree scanned
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "locked/c": "same", "unreadable": "other"})
+    locked, unreadable := filepath.Join(dir, "locked"), filepath.Join(dir, "unreadable")
+    if err := os.Chmod(locked, 0); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chmod(locked, 0755) })
+    if err := os.Chmod(unreadable, 0); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.ReadDir(locked); err == nil {
+        t.Skip("Permissions not enforced (root or unsupported)")
+    }
+
+    scan := scanTestDir(t, dir)
+    paths := make(map[string]bool)
+    for _, accessError := range scan.AccessErrors {
+        if !errors.Is(accessError.Err, fs.ErrPermission) {
+            t.Errorf("Error %v for %s, expected %v", accessError.Err, accessError.Path, fs.ErrPermission)
+        }
+        paths[accessError.Path] = true
+    }
+    expected := map[string]bool{locked: true, unreadable: true}
+    if !reflect.DeepEqual(paths, expected) {
+        t.Errorf("Access errors %v, expected %v", paths, expected)
+    }
+    expectedGroups := [][]string{{filepath.Join(dir, "a"), filepath.Join(dir, "b")}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expectedGroups) {
+        t.Errorf("Groups %v, expected %v", groups, expectedGroups)
+    }
+}
+
+func TestFingerprint(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "a", "b": "b", "sub/c": "c"})
+    scan := scanTestDir(t, dir)
+    fingerprint := scan.Fingerprint()
+
+    //Same files inserted in another order
+    paths := scan.Files.Keys()
+    reordered := NewScan()
+    for i := len(paths) - 1; i >= 0; i-- {
+        reordered.Files[paths[i]] = scan.Files[paths[i]]
+    }
+    if fp := reordered.Fingerprint(); fp != fingerprint {
+        t.Errorf("Fingerprint %s after reordering, expected %s", fp, fingerprint)
+    }
+    if fp := scanTestDir(t, dir).Fingerprint(); fp != fingerprint {
+        t.Errorf("Fingerprint %s after rescan, expected %s", fp, fingerprint)
+    }
+
+    //File added
+    writeTestFiles(t, dir, map[string]string{"d": "a"})
+    if fp := scanTestDir(t, dir).Fingerprint(); fp == fingerprint {
+        t.Errorf("Fingerprint unchanged after adding a file: %s", fp)
+    }
+}
+
+func TestSameNameOnly(t *testing.T) {
+    //Groups kept only if all files have the same name
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "1/photo.jpg": "photo", "2/photo.jpg": "photo", //same name
+        "1/image.jpg": "image", "2/renamed.jpg": "image", //different names
+        "1/doc.txt": "doc", "2/doc.txt": "doc", "3/copy.txt": "doc", //not all the same
+    })
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.SameNameOnly = true
+    runScan(t, scan)
+    expected := [][]string{{filepath.Join(dir, "1", "photo.jpg"), filepath.Join(dir, "2", "photo.jpg")}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+}
+
+func TestDifferentNameOnly(t *testing.T) {
+    //Groups kept if at least two names differ
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "1/photo.jpg": "photo", "2/photo.jpg": "photo", //same name
+        "1/image.jpg": "image", "2/renamed.jpg": "image", //different names
+        "1/doc.txt": "doc", "2/doc.txt": "doc", "3/copy.txt": "doc", //not all the same
+    })
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.DifferentNameOnly = true
+    runScan(t, scan)
+    expected := [][]string{
+        {filepath.Join(dir, "1", "doc.txt"), filepath.Join(dir, "2", "doc.txt"), filepath.Join(dir, "3", "copy.txt")},
+        {filepath.Join(dir, "1", "image.jpg"), filepath.Join(dir, "2", "renamed.jpg")},
+    }
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+}
+
+func TestMapRoundTrip(t *testing.T) {
+    //Exported map imported in each format, same files and groups
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "sub/b": "same", "c": "other", "empty": ""})
+    scan := scanTestDir(t, dir)
+    var exported bytes.Buffer
+    if err := scan.ExportMapTo(&exported); err != nil {
+        t.Fatal(err)
+    }
+    dict := make(map[string]*File)
+    var ndjson bytes.Buffer
+    encoder := json.NewEncoder(&ndjson)
+    for _, path := range scan.Files.Keys() {
+        dict[path] = scan.Files[path]
+        if err := encoder.Encode(scan.Files[path]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    dictData, err := json.Marshal(dict)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        data []byte
+    }{
+        {"array", exported.Bytes()},
+        {"dict", dictData},
+        {"one per line", ndjson.Bytes()},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            imported := NewScan()
+            if err := imported.ImportMapFrom(bytes.NewReader(test.data)); err != nil {
+                t.Fatal(err)
+            }
+            if len(imported.Files) != len(scan.Files) {
+                t.Fatalf("%d files imported, expected %d", len(imported.Files), len(scan.Files))
+            }
+            for path, file := range scan.Files {
+                expected, _ := json.Marshal(file)
+                actual, _ := json.Marshal(imported.Files[path])
+                if !bytes.Equal(actual, expected) {
+                    t.Errorf("File %s imported as %s, expected %s", path, actual, expected)
+                }
+            }
+            if groups, expected := groupPaths(imported.DuplicatesMap()), groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+                t.Errorf("Groups %v, expected %v", groups, expected)
+            }
+        })
+    }
+}
+
+func TestValidateMap(t *testing.T) {
+    //All problems reported, sorted by key, valid entries not reported
+    scan := NewScan()
+    scan.Files = FileMap{
+        "ok": {Path: "ok", FullPath: "/ok", Name: "ok"},
+        "moved": {Path: "sub/moved", FullPath: "/sub/moved", Name: "moved"},
+        "nofull": {Path: "nofull", Name: "nofull"},
+        "both": {Path: "other", Name: "both"},
+        "nil": nil,
+    }
+    expected := []string{
+        "both: key does not match path other",
+        "both: full path missing",
+        "moved: key does not match path sub/moved",
+        "nil: no file object",
+        "nofull: full path missing",
+    }
+    if problems := scan.ValidateMap(); !reflect.DeepEqual(problems, expected) {
+        t.Errorf("Problems %q, expected %q", problems, expected)
+    }
+
+    //Scanned map is consistent
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "a", "sub/b": "b"})
+    if problems := scanTestDir(t, dir).ValidateMap(); len(problems) != 0 {
+        t.Errorf("Problems %q, expected none", problems)
+    }
+}
+
+func TestKeepLongestPath(t *testing.T) {
+    //Longest path kept, alphabetical order for paths of the same length, overrides KeepLast
+    tests := []struct {
+        name string
+        files []string
+        kept string
+    }{
+        {"deepest", []string{"beach/sunset.jpg", "photos/2023/spain/beach/sunset.jpg", "sunset.jpg"},
+            "photos/2023/spain/beach/sunset.jpg"},
+        {"same length", []string{"b/x", "a/x", "c/x"}, "a/x"},
+        {"long name", []string{"a/b/c/x", "long_name_x"}, "long_name_x"},
+    }
+    for _, test := range tests {
+        for _, keepLast := range []bool{false, true} {
+            t.Run(fmt.Sprintf("%s, keep last %t", test.name, keepLast), func(t *testing.T) {
+                dir := t.TempDir()
+                files := make(map[string]string)
+                for _, name := range test.files {
+                    files[name] = "same"
+                }
+                writeTestFiles(t, dir, files)
+                scan := NewScan()
+                scan.Paths = []string{dir}
+                scan.KeepLongestPath = true
+                scan.KeepLast = keepLast
+                runScan(t, scan)
+
+                kept := filepath.Join(dir, filepath.FromSlash(test.kept))
+                for _, group := range scan.DuplicateGroups() {
+                    if file := scan.keptFile(group.Files); file.Path != kept {
+                        t.Errorf("Kept %s, expected %s", file.Path, kept)
+                    }
+                }
+                for _, files := range scan.AdditionalFilesMap() {
+                    if len(files) != len(test.files) - 1 {
+                        t.Errorf("%d additional files, expected %d", len(files), len(test.files) - 1)
+                    }
+                    for _, file := range files {
+                        if file.Path == kept {
+                            t.Errorf("Kept file listed as additional: %s", file.Path)
+                        }
+                    }
+                }
+            })
+        }
+    }
+}
+
+func TestZeroMtime(t *testing.T) {
+    //Warning for files with mtime 0, always hashed again
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"zero": "zero", "normal": "normal"})
+    zero := filepath.Join(dir, "zero")
+    if err := os.Chtimes(zero, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+        t.Fatal(err)
+    }
+    for _, warn := range []bool{false, true} {
+        t.Run(fmt.Sprintf("warn %t", warn), func(t *testing.T) {
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.WarnZeroMtime = warn
+            runScan(t, scan)
+            var warned []string
+            for _, scanError := range scan.ScanErrors {
+                if errors.Is(scanError.Err, ErrZeroMtime) {
+                    warned = append(warned, scanError.Path)
+                }
+            }
+            var expected []string
+            if warn {
+                expected = []string{zero}
+            }
+            if !reflect.DeepEqual(warned, expected) {
+                t.Errorf("Warnings for %v, expected %v", warned, expected)
+            }
+
+            //Rescan with map, only the file with mtime 0 is hashed
+            var buf bytes.Buffer
+            if err := scan.ExportMapTo(&buf); err != nil {
+                t.Fatal(err)
+            }
+            rescan := NewScan()
+            rescan.Paths = []string{dir}
+            if err := rescan.ImportMapFrom(&buf); err != nil {
+                t.Fatal(err)
+            }
+            runScan(t, rescan)
+            if rescan.Stats.Hashed != 1 || rescan.Stats.Unchanged != 1 {
+                t.Errorf("Hashed %d, unchanged %d, expected 1, 1", rescan.Stats.Hashed, rescan.Stats.Unchanged)
+            }
+        })
+    }
+}
+
+func TestUniqueFiles(t *testing.T) {
+    //Unique and duplicate files are disjoint, together all non-empty files
+    dir := t.TempDir()
+    files := map[string]string{"empty1": "", "empty2": ""}
+    for i := 0; i < 30; i++ {
+        files[fmt.Sprintf("%d/%d", i % 4, i)] = fmt.Sprint(i % 24) //first 6 repeated
+    }
+    writeTestFiles(t, dir, files)
+    scan := scanTestDir(t, dir)
+
+    unique := make(map[string]bool)
+    for _, file := range scan.UniqueFiles() {
+        unique[file.Path] = true
+    }
+    duplicates := make(map[string]bool)
+    for _, files := range scan.DuplicatesMap() {
+        for _, file := range files {
+            if unique[file.Path] {
+                t.Errorf("File both unique and duplicate: %s", file.Path)
+            }
+            duplicates[file.Path] = true
+        }
+    }
+    if len(unique) != 18 || len(duplicates) != 12 {
+        t.Errorf("%d unique files, %d duplicates, expected 18, 12", len(unique), len(duplicates))
+    }
+    for path, file := range scan.Files {
+        if file.Size > 0 && !unique[path] && !duplicates[path] {
+            t.Errorf("File neither unique nor duplicate: %s", path)
+        }
+    }
+}
+
+func TestRequireHash(t *testing.T) {
+    //Map with one unhashed file: error if hashes are required, file not grouped otherwise
+    mapData := `[
+        {"path": "a", "full_path": "/a", "name": "a", "size": 3, "md5": "abc"},
+        {"path": "b", "full_path": "/b", "name": "b", "size": 3, "md5": "abc"},
+        {"path": "c", "full_path": "/c", "name": "c", "size": 3}
+    ]`
+    for _, requireHash := range []bool{false, true} {
+        t.Run(fmt.Sprintf("require hash %t", requireHash), func(t *testing.T) {
+            scan := NewScan()
+            scan.RequireHash = requireHash
+            err := scan.ImportMapFrom(strings.NewReader(mapData))
+            if requireHash {
+                if err == nil || !strings.Contains(err.Error(), "Hash missing for c") {
+                    t.Errorf("Error %v, expected hash missing for c", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if missing := scan.ValidateHashes(); !reflect.DeepEqual(missing, []string{"c"}) {
+                t.Errorf("Files without hash %v, expected [c]", missing)
+            }
+            expected := [][]string{{"a", "b"}}
+            if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+                t.Errorf("Groups %v, expected %v", groups, expected)
+            }
+        })
+    }
+}
+
+func TestScanTimeout(t *testing.T) {
+    //Slow hashing stopped by timeout, files hashed so far are kept
+    RegisterHash("test-slow", func() hash.Hash {
+        return &faultyHash{md5.New(), func() error {
+            time.Sleep(5 * time.Millisecond)
+            return nil
+        }}
+    })
+    t.Cleanup(func() { delete(HashRegistry, "test-slow") })
+    dir := t.TempDir()
+    files := make(map[string]string)
+    for i := 0; i < 100; i++ {
+        files[fmt.Sprint(i)] = fmt.Sprintf("%04d", i)
+    }
+    writeTestFiles(t, dir, files)
+
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.HashAlgorithm = "test-slow"
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+    defer cancel()
+    var wg sync.WaitGroup
+    wg.Add(1)
+    start := time.Now()
+    scan.ScanContext(ctx, &wg)
+    wg.Wait()
+    if elapsed := time.Since(start); elapsed > 250 * time.Millisecond {
+        t.Errorf("Scan took %s, expected to stop after timeout", elapsed)
+    }
+    if scan.Stats.BytesHashed == 0 || scan.Stats.Hashed == 0 || scan.Stats.Hashed >= len(files) {
+        t.Errorf("%d files hashed (%d B), expected some of %d", scan.Stats.Hashed, scan.Stats.BytesHashed, len(files))
+    }
+    for path, file := range scan.Files {
+        if !file.IsHashed() {
+            t.Errorf("File without hash in results: %s", path)
+        }
+    }
+}
+
+func TestSameDirOnly(t *testing.T) {
+    //Copies in the same directory only, copies in other directories left out
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "photos/IMG_001.jpg": "img", "photos/IMG_001_copy.jpg": "img", "backup/IMG_001.jpg": "img",
+        "docs/x.txt": "doc", "backup/x.txt": "doc",
+    })
+    tests := []struct {
+        sameDirOnly bool
+        expected [][]string
+    }{
+        {false, [][]string{
+            {filepath.Join(dir, "backup", "IMG_001.jpg"), filepath.Join(dir, "photos", "IMG_001.jpg"), filepath.Join(dir, "photos", "IMG_001_copy.jpg")},
+            {filepath.Join(dir, "backup", "x.txt"), filepath.Join(dir, "docs", "x.txt")},
+        }},
+        {true, [][]string{
+            {filepath.Join(dir, "photos", "IMG_001.jpg"), filepath.Join(dir, "photos", "IMG_001_copy.jpg")},
+        }},
+    }
+    for _, test := range tests {
+        t.Run(fmt.Sprintf("same dir only %t", test.sameDirOnly), func(t *testing.T) {
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.SameDirOnly = test.sameDirOnly
+            runScan(t, scan)
+            if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, test.expected) {
+                t.Errorf("Groups %v, expected %v", groups, test.expected)
+            }
+        })
+    }
+}
+
+func TestRemoveFromMapBatch(t *testing.T) {
+    //Removed files not in duplicates, hash map rebuilt once, missing paths reported
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "same", "d": "other", "e": "other"})
+    scan := scanTestDir(t, dir)
+    var verbose bytes.Buffer
+    verboseIO = &verbose
+    t.Cleanup(func() { verboseIO = io.Discard })
+
+    missing := filepath.Join(dir, "missing")
+    err := scan.RemoveFromMapBatch([]string{filepath.Join(dir, "a"), filepath.Join(dir, "d"), missing})
+    if err == nil || !strings.Contains(err.Error(), missing) {
+        t.Errorf("Error %v, expected %s not in map", err, missing)
+    }
+    if builds := strings.Count(verbose.String(), "Building hash map"); builds != 1 {
+        t.Errorf("Hash map built %d times, expected once", builds)
+    }
+    expected := [][]string{{filepath.Join(dir, "b"), filepath.Join(dir, "c")}}
+    if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+    if len(scan.Files) != 3 {
+        t.Errorf("%d files in map, expected 3", len(scan.Files))
+    }
+
+    //Single file
+    if err := scan.RemoveFromMap(filepath.Join(dir, "b")); err != nil {
+        t.Fatal(err)
+    }
+    if groups := scan.DuplicatesMap(); len(groups) != 0 {
+        t.Errorf("Groups %v, expected none", groupPaths(groups))
+    }
+}
+
+func TestCopiesRange(t *testing.T) {
+    //Groups of 2, 3 and 5 files
+    dir := t.TempDir()
+    files := make(map[string]string)
+    for _, count := range []int{2, 3, 5} {
+        for i := 0; i < count; i++ {
+            files[fmt.Sprintf("%d/%d", count, i)] = fmt.Sprint(count)
+        }
+    }
+    writeTestFiles(t, dir, files)
+    tests := []struct {
+        minCopies int
+        maxCopies int
+        expected []int //files per group
+    }{
+        {0, 0, []int{2, 3, 5}},
+        {2, 0, []int{2, 3, 5}},
+        {3, 0, []int{3, 5}},
+        {4, 0, []int{5}},
+        {6, 0, nil},
+        {0, 3, []int{2, 3}},
+        {0, 2, []int{2}},
+        {3, 3, []int{3}},
+        {3, 5, []int{3, 5}},
+        {4, 4, nil},
+    }
+    for _, test := range tests {
+        t.Run(fmt.Sprintf("min %d, max %d", test.minCopies, test.maxCopies), func(t *testing.T) {
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.MinCopies = test.minCopies
+            scan.MaxCopies = test.maxCopies
+            runScan(t, scan)
+            var counts []int
+            for _, files := range scan.DuplicatesMap() {
+                counts = append(counts, len(files))
+            }
+            sort.Ints(counts)
+            if !reflect.DeepEqual(counts, test.expected) {
+                t.Errorf("Groups with %v files, expected %v", counts, test.expected)
+            }
+        })
+    }
+}
+
+func TestLargestUniqueFiles(t *testing.T) {
+    //Duplicates left out even if larger, largest unique files first
+    dir := t.TempDir()
+    files := map[string]string{"dup1": strings.Repeat("d", 100), "dup2": strings.Repeat("d", 100)}
+    for i := 1; i <= 15; i++ {
+        files[fmt.Sprintf("unique%02d", i)] = strings.Repeat("u", i)
+    }
+    writeTestFiles(t, dir, files)
+    scan := scanTestDir(t, dir)
+    tests := []struct {
+        n int
+        expected []int64 //sizes
+    }{
+        {3, []int64{15, 14, 13}},
+        {0, []int64{15, 14, 13, 12, 11, 10, 9, 8, 7, 6}}, //10 by default
+        {20, []int64{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+    }
+    for _, test := range tests {
+        t.Run(fmt.Sprint(test.n), func(t *testing.T) {
+            var sizes []int64
+            for _, file := range scan.LargestUniqueFiles(test.n) {
+                if strings.HasPrefix(file.Name, "dup") {
+                    t.Errorf("Duplicate listed: %s", file.Path)
+                }
+                sizes = append(sizes, file.Size)
+            }
+            if !reflect.DeepEqual(sizes, test.expected) {
+                t.Errorf("Sizes %v, expected %v", sizes, test.expected)
+            }
+        })
+    }
+}
+
+func TestFileGrowingDuringScan(t *testing.T) {
+    //File appended to while it's hashed: warning, final size stored, hashed again next time
+    var modify func()
+    RegisterHash("test-grow", func() hash.Hash {
+        return &modifyingHash{md5.New(), modify}
+    })
+    t.Cleanup(func() { delete(HashRegistry, "test-grow") })
+    dir := t.TempDir()
+    path := filepath.Join(dir, "a")
+    writeTestFiles(t, dir, map[string]string{"a": "same content"})
+    modify = func() {
+        f, err := os.OpenFile(path, os.O_APPEND | os.O_WRONLY, 0)
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        defer f.Close()
+        if _, err := f.WriteString(", appended"); err != nil {
+            t.Error(err)
+        }
+    }
+
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.HashAlgorithm = "test-grow"
+    runScan(t, scan)
+    modify = nil
+    if len(scan.ScanErrors) != 1 || !errors.Is(scan.ScanErrors[0].Err, ErrModifiedDuringScan) {
+        t.Errorf("Scan errors %v, expected %v", scan.ScanErrors, ErrModifiedDuringScan)
+    }
+    file := scan.Files[path]
+    if file == nil || !file.ModifiedDuringScan || file.Size != int64(len("same content, appended")) {
+        t.Fatalf("File %+v, expected modified with final size", file)
+    }
+    if len(scan.HashFilesMap) != 0 {
+        t.Errorf("Modified file in hash map: %v", scan.HashFilesMap)
+    }
+
+    //Rescan with map, file not taken as unchanged
+    var buf bytes.Buffer
+    if err := scan.ExportMapTo(&buf); err != nil {
+        t.Fatal(err)
+    }
+    writeTestFiles(t, dir, map[string]string{"copy": "same content, appended"})
+    rescan := NewScan()
+    rescan.Paths = []string{dir}
+    rescan.HashAlgorithm = "test-grow"
+    if err := rescan.ImportMapFrom(&buf); err != nil {
+        t.Fatal(err)
+    }
+    runScan(t, rescan)
+    if rescan.Stats.Hashed != 2 || rescan.Stats.Unchanged != 0 {
+        t.Errorf("Hashed %d, unchanged %d, expected 2, 0", rescan.Stats.Hashed, rescan.Stats.Unchanged)
+    }
+    expected := [][]string{{path, filepath.Join(dir, "copy")}}
+    if groups := groupPaths(rescan.DuplicatesMap()); !reflect.DeepEqual(groups, expected) {
+        t.Errorf("Groups %v, expected %v", groups, expected)
+    }
+}
+
+func TestLinkFullPaths(t *testing.T) {
+    //Scanned with relative paths, linked after changing the working directory
+    for _, fullPaths := range []bool{false, true} {
+        t.Run(fmt.Sprintf("full paths %t", fullPaths), func(t *testing.T) {
+            dir := t.TempDir()
+            searchPath := filepath.Join(dir, "files")
+            writeTestFiles(t, searchPath, map[string]string{"a": "same", "b": "same"})
+            t.Chdir(searchPath)
+            scan := NewScan()
+            scan.Paths = []string{"."}
+            scan.LinkFullPaths = fullPaths
+            runScan(t, scan)
+            if _, found := scan.Files["a"]; !found {
+                t.Fatalf("Files %v, expected relative paths", scan.Files.Keys())
+            }
+
+            t.Chdir(dir)
+            report := scan.LinkDuplicates(func(file *File) string { return file.Path })
+            fiA, errA := os.Stat(filepath.Join(searchPath, "a"))
+            fiB, errB := os.Stat(filepath.Join(searchPath, "b"))
+            if errA != nil || errB != nil {
+                t.Fatalf("File missing: %v, %v", errA, errB)
+            }
+            if linked := os.SameFile(fiA, fiB); linked != fullPaths || (report.Linked == 1) != fullPaths {
+                t.Errorf("Linked %t (%d files), expected %t", linked, report.Linked, fullPaths)
+            }
+        })
+    }
+}
+
+func TestRenameDuplicates(t *testing.T) {
+    //Renamed files leave the duplicates map, numbered suffix if taken, nothing renamed in dry run
+    for _, dryRun := range []bool{false, true} {
+        t.Run(fmt.Sprintf("dry run %t", dryRun), func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{
+                "a": "same", "b": "same", "c": "same", "d": "other", "e": "other", "b.dup": "taken",
+            })
+            scan := scanTestDir(t, dir)
+            before := groupPaths(scan.DuplicatesMap())
+            if err := scan.RenameDuplicates(".dup", dryRun, func(file *File) string { return file.Path }); err != nil {
+                t.Fatal(err)
+            }
+
+            var names []string
+            entries, err := os.ReadDir(dir)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, entry := range entries {
+                names = append(names, entry.Name())
+            }
+            expectedNames := []string{"a", "b.dup", "b.dup2", "c.dup", "d", "e.dup"}
+            var expectedGroups [][]string
+            if dryRun {
+                expectedNames = []string{"a", "b", "b.dup", "c", "d", "e"}
+                expectedGroups = before
+            }
+            if !reflect.DeepEqual(names, expectedNames) {
+                t.Errorf("Files %v, expected %v", names, expectedNames)
+            }
+            if groups := groupPaths(scan.DuplicatesMap()); !reflect.DeepEqual(groups, expectedGroups) {
+                t.Errorf("Groups %v, expected %v", groups, expectedGroups)
+            }
+        })
+    }
+}
+
+func TestExcludeAge(t *testing.T) {
+    //Files modified within the exclusion window are not scanned
+    day := 24 * time.Hour
+    tests := []struct {
+        name string
+        excludeNewerThan time.Duration
+        excludeOlderThan time.Duration
+        expected []string
+    }{
+        {"no filter", 0, 0, []string{"month", "new", "old", "week"}},
+        {"exclude newer", 7 * day, 0, []string{"month", "old"}},
+        {"exclude older", 0, 365 * day, []string{"month", "new", "week"}},
+        {"exclude both", 7 * day, 365 * day, []string{"month"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            ages := map[string]time.Duration{"new": 0, "week": 3 * day, "month": 30 * day, "old": 400 * day}
+            for name, age := range ages {
+                writeTestFiles(t, dir, map[string]string{name: "same"})
+                mtime := time.Now().Add(-age)
+                if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.ExcludeNewerThan = test.excludeNewerThan
+            scan.ExcludeOlderThan = test.excludeOlderThan
+            runScan(t, scan)
+
+            var names []string
+            for path := range scan.Files {
+                names = append(names, filepath.Base(path))
+            }
+            sort.Strings(names)
+            if !reflect.DeepEqual(names, test.expected) {
+                t.Errorf("Files %v, expected %v", names, test.expected)
+            }
+        })
+    }
+}
+
+//Hash failing for files containing the given content, others hashed normally
+type contentFaultHash struct {
+    hash.Hash
+    content string
+}
+
+func (h *contentFaultHash) Write(p []byte) (int, error) {
+    if strings.Contains(string(p), h.content) {
+        return 0, errors.New("Read error")
+    }
+    return h.Hash.Write(p)
+}
+
+func TestScanStatsSum(t *testing.T) {
+    //Every file found is either hashed, taken from the imported map or failed
+    RegisterHash("test-content-fault", func() hash.Hash { return &contentFaultHash{md5.New(), "bad"} })
+    t.Cleanup(func() { delete(HashRegistry, "test-content-fault") })
+
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "c"})
+    first := NewScan()
+    first.Paths = []string{dir}
+    first.HashAlgorithm = "test-content-fault"
+    runScan(t, first)
+    var buf bytes.Buffer
+    if err := first.ExportMapTo(&buf); err != nil {
+        t.Fatal(err)
+    }
+    writeTestFiles(t, dir, map[string]string{"d": "new", "e": "new", "bad1": "bad", "bad2": "bad content"})
+
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.HashAlgorithm = "test-content-fault"
+    if err := scan.ImportMapFrom(&buf); err != nil {
+        t.Fatal(err)
+    }
+    runScan(t, scan)
+    stats := scan.Stats
+    if stats.Found != 7 || stats.Hashed != 2 || stats.Cached != 3 || stats.Failed != 2 {
+        t.Errorf("Found %d, hashed %d, cached %d, failed %d, expected 7, 2, 3, 2",
+            stats.Found, stats.Hashed, stats.Cached, stats.Failed)
+    }
+    if sum := stats.Hashed + stats.Cached + stats.Failed; sum != stats.Found {
+        t.Errorf("Hashed + cached + failed %d, expected %d", sum, stats.Found)
+    }
+
+    //Summary shows all four counts
+    summary := scan.Summary()
+    for _, line := range []string{"Files found:\t\t7", "Files hashed:\t\t2", "Files from cache:\t3", "Files skipped (error):\t2"} {
+        if !strings.Contains(summary, line) {
+            t.Errorf("Summary missing %q:\n%s", line, summary)
+        }
+    }
+}
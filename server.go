@@ -0,0 +1,197 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "sync"
+    "net"
+    "context"
+    "strings"
+    "net/http"
+    "encoding/json"
+    "crypto/subtle"
+)
+
+type Server struct {
+    Scan *Scan
+    Auth string //user:pass, no authentication if empty
+    AllowDelete bool //files may be deleted (DELETE /file)
+    filePath func(*File) string
+    mutex sync.Mutex
+}
+
+func NewServer(scan *Scan, filePath func(*File) string) *Server {
+    server := &Server{Scan: scan, filePath: filePath}
+
+    return server
+}
+
+func (server *Server) Handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/duplicates", server.handleDuplicates)
+    mux.HandleFunc("/files", server.handleFiles)
+    mux.HandleFunc("/stats", server.handleStats)
+    mux.HandleFunc("/file", server.handleFile)
+
+    return server.checkAuth(mux)
+}
+
+func (server *Server) ListenAndServe(ctx context.Context, addr string) error {
+    httpServer := &http.Server{Addr: addr, Handler: server.Handler()}
+
+    //Stop server when context is cancelled (interrupt)
+    go func() {
+        <-ctx.Done()
+        httpServer.Shutdown(context.Background())
+    }()
+
+    err := httpServer.ListenAndServe()
+    if err == http.ErrServerClosed {
+        return nil
+    }
+    return err
+}
+
+func (server *Server) checkAuth(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if server.Auth != "" {
+            //Basic auth, constant time comparison
+            user, pass, ok := r.BasicAuth()
+            if !ok || subtle.ConstantTimeCompare([]byte(user + ":" + pass), []byte(server.Auth)) != 1 {
+                w.Header().Set("WWW-Authenticate", `Basic realm="dupefinder"`)
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func (server *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "    ")
+    if err := encoder.Encode(v); err != nil {
+        fmt.Fprintf(verboseIO, "Error writing response: %s\n", err)
+    }
+}
+
+func (server *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    server.mutex.Lock()
+    defer server.mutex.Unlock()
+
+    server.writeJSON(w, server.Scan.DuplicatesMap())
+}
+
+func (server *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    server.mutex.Lock()
+    defer server.mutex.Unlock()
+
+    server.writeJSON(w, server.Scan.Files.Values())
+}
+
+func (server *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    server.mutex.Lock()
+    defer server.mutex.Unlock()
+
+    scan := server.Scan
+    stats := scan.SummaryStats()
+    server.writeJSON(w, stats)
+}
+
+func (server *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodDelete {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !server.AllowDelete {
+        http.Error(w, "Deleting files is disabled", http.StatusForbidden)
+        return
+    }
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        http.Error(w, "Path parameter missing", http.StatusBadRequest)
+        return
+    }
+    server.mutex.Lock()
+    defer server.mutex.Unlock()
+
+    //Find duplicate group of file
+    var file *File
+    var group FileList
+    for _, files := range server.Scan.DuplicatesMap() {
+        for _, f := range files {
+            if f.Path == path || f.FullPath == path {
+                file, group = f, files
+            }
+        }
+    }
+    if file == nil {
+        http.Error(w, "File not found in any duplicate group", http.StatusNotFound)
+        return
+    }
+
+    //Files compared by partial hash might not be identical
+    if partialHashGroup(group) {
+        http.Error(w, "File has a partial hash, not deleting it", http.StatusConflict)
+        return
+    }
+
+    //Never delete the last copy, another file in the group must still exist
+    var otherExists bool
+    for _, f := range group {
+        if f != file && f.Exists() {
+            otherExists = true
+            break
+        }
+    }
+    if !otherExists {
+        http.Error(w, "No other copy of file found, not deleting it", http.StatusConflict)
+        return
+    }
+
+    //Delete file and remove it from map
+    if err := os.Remove(server.filePath(file)); err != nil {
+        http.Error(w, fmt.Sprintf("Error deleting file: %s", err), http.StatusInternalServerError)
+        return
+    }
+    fmt.Fprintf(verboseIO, "Deleted %s\n", server.filePath(file))
+    server.Scan.RemoveFromMap(file.Path)
+
+    server.writeJSON(w, map[string]string{"deleted": file.Path})
+}
+
+func serverAddr(addr string, auth string) (string, error) {
+    //Local only if no host is given (like :8080)
+    //Other hosts can only connect with credentials
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return "", err
+    }
+    if host == "" {
+        return net.JoinHostPort("127.0.0.1", port), nil
+    }
+    ip := net.ParseIP(host)
+    loopback := host == "localhost" || (ip != nil && ip.IsLoopback())
+    if !loopback && auth == "" {
+        return "", fmt.Errorf("Credentials (-serve-auth) required to serve on %s", addr)
+    }
+    return addr, nil
+}
+
+func validServerAuth(auth string) bool {
+    //Expected format is user:pass
+    return auth == "" || strings.Contains(auth, ":")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "os"
+    "testing"
+    "net/url"
+    "net/http"
+    "path/filepath"
+    "encoding/json"
+    "net/http/httptest"
+)
+
+func TestServerAddr(t *testing.T) {
+    tests := []struct {
+        addr string
+        auth string
+        expected string
+        valid bool
+    }{
+        {":8080", "", "127.0.0.1:8080", true},
+        {":8080", "user:pass", "127.0.0.1:8080", true},
+        {"127.0.0.1:8080", "", "127.0.0.1:8080", true},
+        {"localhost:8080", "", "localhost:8080", true},
+        {"[::1]:8080", "", "[::1]:8080", true},
+        {"0.0.0.0:8080", "", "", false},
+        {"0.0.0.0:8080", "user:pass", "0.0.0.0:8080", true},
+        {"192.168.1.2:8080", "", "", false},
+        {"8080", "", "", false},
+    }
+    for _, test := range tests {
+        t.Run(test.addr, func(t *testing.T) {
+            addr, err := serverAddr(test.addr, test.auth)
+            if (err == nil) != test.valid {
+                t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+            }
+            if addr != test.expected {
+                t.Errorf("Address %s, expected %s", addr, test.expected)
+            }
+        })
+    }
+}
+
+func TestServerEndpoints(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a": "same", "b": "same", "unique": "unique",
+    })
+    scan := scanTestDir(t, dir)
+    server := NewServer(scan, func(file *File) string { return file.Path })
+    server.Auth = "user:pass"
+    ts := httptest.NewServer(server.Handler())
+    defer ts.Close()
+
+    request := func(method string, path string, auth bool) *http.Response {
+        req, err := http.NewRequest(method, ts.URL + path, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if auth {
+            req.SetBasicAuth("user", "pass")
+        }
+        res, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { res.Body.Close() })
+        return res
+    }
+
+    tests := []struct {
+        name string
+        method string
+        path string
+        auth bool
+        status int
+    }{
+        {"no credentials", "GET", "/duplicates", false, http.StatusUnauthorized},
+        {"duplicates", "GET", "/duplicates", true, http.StatusOK},
+        {"files", "GET", "/files", true, http.StatusOK},
+        {"stats", "GET", "/stats", true, http.StatusOK},
+        {"post", "POST", "/duplicates", true, http.StatusMethodNotAllowed},
+        {"get file", "GET", "/file", true, http.StatusMethodNotAllowed},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            res := request(test.method, test.path, test.auth)
+            if res.StatusCode != test.status {
+                t.Errorf("Status %d, expected %d", res.StatusCode, test.status)
+            }
+        })
+    }
+
+    //Response structure
+    var duplicates map[string][]File
+    if err := json.NewDecoder(request("GET", "/duplicates", true).Body).Decode(&duplicates); err != nil {
+        t.Fatal(err)
+    }
+    if len(duplicates) != 1 {
+        t.Fatalf("%d duplicate groups, expected 1", len(duplicates))
+    }
+    for hash, files := range duplicates {
+        if len(files) != 2 || files[0].Path == "" || hash != files[0].HashValue() {
+            t.Errorf("Unexpected group %s: %v", hash, files)
+        }
+    }
+    var files []File
+    if err := json.NewDecoder(request("GET", "/files", true).Body).Decode(&files); err != nil {
+        t.Fatal(err)
+    }
+    if len(files) != 3 {
+        t.Errorf("%d files, expected 3", len(files))
+    }
+    var stats SummaryStats
+    if err := json.NewDecoder(request("GET", "/stats", true).Body).Decode(&stats); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestServerDeleteFile(t *testing.T) {
+    deletePath := func(path string) string {
+        return "/file?path=" + url.QueryEscape(path)
+    }
+    tests := []struct {
+        name string
+        allowDelete bool
+        hashSizeLimit int64
+        missing string //removed after scan
+        delete []string //files deleted one after another
+        status []int
+        remaining []string
+    }{
+        {"disabled by default", false, 0, "",
+            []string{"a"}, []int{http.StatusForbidden}, []string{"a", "b"}},
+        {"duplicate", true, 0, "",
+            []string{"a"}, []int{http.StatusOK}, []string{"b"}},
+        {"last copy", true, 0, "",
+            []string{"a", "b"}, []int{http.StatusOK, http.StatusNotFound}, []string{"b"}},
+        {"unique file", true, 0, "",
+            []string{"unique"}, []int{http.StatusNotFound}, []string{"a", "b", "unique"}},
+        {"missing path", true, 0, "",
+            []string{""}, []int{http.StatusBadRequest}, []string{"a", "b"}},
+        {"partial hash", true, 4, "",
+            []string{"a"}, []int{http.StatusConflict}, []string{"a", "b"}},
+        {"other copy missing", true, 0, "b",
+            []string{"a"}, []int{http.StatusConflict}, []string{"a"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeTestFiles(t, dir, map[string]string{
+                "a": "same content", "b": "same content", "unique": "unique",
+            })
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.HashSizeLimit = test.hashSizeLimit
+            runScan(t, scan)
+            if test.missing != "" {
+                os.Remove(filepath.Join(dir, test.missing))
+            }
+            server := NewServer(scan, func(file *File) string { return file.Path })
+            server.AllowDelete = test.allowDelete
+            ts := httptest.NewServer(server.Handler())
+            defer ts.Close()
+
+            for i, name := range test.delete {
+                path := ""
+                if name != "" {
+                    path = filepath.Join(dir, name)
+                }
+                req, err := http.NewRequest("DELETE", ts.URL + deletePath(path), nil)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                res, err := http.DefaultClient.Do(req)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                res.Body.Close()
+                if res.StatusCode != test.status[i] {
+                    t.Errorf("Deleting %s: status %d, expected %d", name, res.StatusCode, test.status[i])
+                }
+            }
+            for _, name := range test.remaining {
+                if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+                    t.Errorf("File deleted: %s", name)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+func fileInode(fi os.FileInfo) uint64 {
+    if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+        return uint64(stat.Ino)
+    }
+    return 0
+}
+
+func fileDevice(fi os.FileInfo) (uint64, bool) {
+    if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+        return uint64(stat.Dev), true
+    }
+    return 0, false
+}
+
+func fileOwner(fi os.FileInfo) (uint32, uint32, bool) {
+    if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+        return uint32(stat.Uid), uint32(stat.Gid), true
+    }
+    return 0, 0, false
+}
+
+func networkDriveCheck(path string) bool {
+    return false
+}
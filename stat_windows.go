@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+    "os"
+    "strings"
+    "syscall"
+    "unsafe"
+    "path/filepath"
+)
+
+const driveRemote = 4 //DRIVE_REMOTE
+
+var procGetDriveTypeW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDriveTypeW")
+
+func fileInode(fi os.FileInfo) uint64 {
+    //File index not available from os.FileInfo
+    return 0
+}
+
+func fileDevice(fi os.FileInfo) (uint64, bool) {
+    //Device not available from os.FileInfo
+    return 0, false
+}
+
+func fileOwner(fi os.FileInfo) (uint32, uint32, bool) {
+    //No Unix ownership on Windows
+    return 0, 0, false
+}
+
+func networkDriveCheck(path string) bool {
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return false
+    }
+
+    //UNC path (\\server\share)
+    volume := filepath.VolumeName(abs)
+    if strings.HasPrefix(volume, `\\`) {
+        return true
+    }
+
+    //Mapped network drive
+    root, err := syscall.UTF16PtrFromString(volume + `\`)
+    if err != nil {
+        return false
+    }
+    driveType, _, _ := procGetDriveTypeW.Call(uintptr(unsafe.Pointer(root)))
+    return driveType == driveRemote
+}
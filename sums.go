@@ -0,0 +1,98 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "bufio"
+    "strings"
+    "path/filepath"
+)
+
+//Path escaped by md5sum and others: \\ is a backslash, \n a newline (\r a carriage return)
+var sumPathUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+
+func (scan *Scan) SeedFromSumFile(sumFile string) error {
+    //Use hashes from existing sum file (like MD5SUMS), files won't be hashed again
+    //Seeded files are matched by full path during the scan, they aren't added to the map
+    //Only files that haven't been modified since the sum file was written
+    //Hashes must be of the scan's hash algorithm (md5sum, sha1sum, sha256sum, b2sum, b3sum)
+    fmt.Fprintf(verboseIO, "Reading hashes from sum file: %s\n", sumFile)
+    f, err := os.Open(sumFile)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    sumInfo, err := f.Stat()
+    if err != nil {
+        return err
+    }
+
+    //Hash length (hex) of hash algorithm
+    algorithm := scan.hashAlgorithm()
+    factory, found := HashRegistry[algorithm]
+    if !found {
+        return fmt.Errorf("Unsupported hash algorithm: %s", algorithm)
+    }
+    hashLength := factory().Size() * 2
+
+    //Lines like: HASH  PATH or HASH *PATH (binary mode)
+    //Paths are relative to the directory of the sum file
+    //Line starts with a backslash if the path is escaped (contains backslash or newline)
+    var seeded int
+    baseDir := filepath.Dir(sumFile)
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+    for scanner.Scan() {
+        line := strings.TrimSuffix(scanner.Text(), "\r")
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        escaped := strings.HasPrefix(line, "\\")
+        if escaped {
+            line = line[1:]
+        }
+        hash, path, found := strings.Cut(line, " ")
+        if !found || len(path) < 2 {
+            return fmt.Errorf("Invalid line in sum file: %s", line)
+        }
+        path = path[1:] //" " or "*"
+        if escaped {
+            path = sumPathUnescaper.Replace(path)
+        }
+        if len(hash) != hashLength {
+            return fmt.Errorf("Hash in sum file doesn't match hash algorithm %s: %s", algorithm, path)
+        }
+        if !filepath.IsAbs(path) {
+            path = filepath.Join(baseDir, path)
+        }
+
+        //Skip missing files and files modified after sum file
+        fi, err := os.Stat(path)
+        if err != nil || !fi.Mode().IsRegular() || fi.ModTime().After(sumInfo.ModTime()) {
+            continue
+        }
+        fullPath, err := filepath.Abs(path)
+        if err != nil {
+            continue
+        }
+        seededFile := &File{
+            Path: path,
+            FullPath: fullPath,
+            Name: fi.Name(),
+            Size: fi.Size(),
+            ModificationTime: fi.ModTime().Unix(),
+        }
+        seededFile.setHash(algorithm, strings.ToLower(hash))
+        if scan.seededFiles == nil {
+            scan.seededFiles = make(map[string]*File)
+        }
+        scan.seededFiles[fullPath] = seededFile
+        seeded++
+    }
+    if err := scanner.Err(); err != nil {
+        return err
+    }
+    fmt.Fprintf(verboseIO, "Hashes taken from sum file: %d\n", seeded)
+
+    return nil
+}
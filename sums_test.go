@@ -0,0 +1,85 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "runtime"
+    "strings"
+    "testing"
+    "crypto/sha256"
+    "path/filepath"
+)
+
+func TestSeedFromSumFile(t *testing.T) {
+    //Half of the files in sum file, only the other half is hashed
+    dir := t.TempDir()
+    searchPath := filepath.Join(dir, "files")
+    files := make(map[string]string)
+    var seededNames []string
+    for i := 0; i < 10; i++ {
+        name := fmt.Sprintf("f%d", i)
+        files[name] = fmt.Sprintf("content %d", i)
+        if i % 2 == 0 {
+            seededNames = append(seededNames, name)
+        }
+    }
+    if runtime.GOOS != "windows" {
+        //Escaped in sum file
+        files["back\\slash"], files["new\nline"] = "backslash", "newline"
+        seededNames = append(seededNames, "back\\slash", "new\nline")
+    }
+    writeTestFiles(t, searchPath, files)
+    past := time.Now().Add(-time.Hour)
+    for name := range files {
+        if err := os.Chtimes(filepath.Join(searchPath, name), past, past); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    //Sum file written after the files, paths relative to its directory
+    //Hash of f0 replaced, the hash from the sum file must be used
+    fake := strings.Repeat("0", 64)
+    var sums strings.Builder
+    for _, name := range seededNames {
+        hash := fmt.Sprintf("%x", sha256.Sum256([]byte(files[name])))
+        if name == "f0" {
+            hash = fake
+        }
+        path := "files/" + name
+        if strings.ContainsAny(name, "\\\n") {
+            path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+            sums.WriteString("\\")
+        }
+        fmt.Fprintf(&sums, "%s  %s\n", hash, path)
+    }
+    sumFile := filepath.Join(dir, "SHA256SUMS")
+    if err := os.WriteFile(sumFile, []byte(sums.String()), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    scan := NewScan()
+    scan.Paths = []string{searchPath}
+    scan.HashAlgorithm = "sha256"
+    if err := scan.SeedFromSumFile(sumFile); err != nil {
+        t.Fatal(err)
+    }
+    runScan(t, scan)
+    if hashed := len(files) - len(seededNames); scan.Stats.Hashed != hashed {
+        t.Errorf("%d files hashed, expected %d", scan.Stats.Hashed, hashed)
+    }
+    for _, name := range seededNames {
+        file := scan.Files[filepath.Join(searchPath, name)]
+        if file == nil {
+            t.Errorf("File missing: %q", name)
+            continue
+        }
+        expected := fmt.Sprintf("%x", sha256.Sum256([]byte(files[name])))
+        if name == "f0" {
+            expected = fake
+        }
+        if file.SHA256 != expected {
+            t.Errorf("Hash of %q: %s, expected %s", name, file.SHA256, expected)
+        }
+    }
+}
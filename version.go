@@ -0,0 +1,42 @@
+package main
+
+import (
+    "fmt"
+    "runtime/debug"
+)
+
+//Version and build time, set at build time
+//(-ldflags "-X main.Version=v1.2.3 -X main.BuildTime=2024-01-01")
+var Version = "dev"
+var BuildTime = ""
+
+func versionInfo() string {
+    version := Version
+    commit := "unknown"
+    buildTime := BuildTime
+
+    //Fill in missing information from module and VCS build info
+    if info, ok := debug.ReadBuildInfo(); ok {
+        if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+            version = info.Main.Version
+        }
+        for _, setting := range info.Settings {
+            switch setting.Key {
+            case "vcs.revision":
+                commit = setting.Value
+                if len(commit) > 7 {
+                    commit = commit[:7]
+                }
+            case "vcs.time":
+                if buildTime == "" {
+                    buildTime = setting.Value
+                }
+            }
+        }
+    }
+    if buildTime == "" {
+        buildTime = "unknown"
+    }
+
+    return fmt.Sprintf("dupefinder %s (commit: %s, built: %s)", version, commit, buildTime)
+}
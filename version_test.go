@@ -0,0 +1,22 @@
+package main
+
+import (
+    "regexp"
+    "testing"
+)
+
+func TestVersionInfo(t *testing.T) {
+    //No empty fields, also with values set at build time
+    format := regexp.MustCompile(`^dupefinder \S+ \(commit: \S+, built: \S+\)$`)
+    if info := versionInfo(); !format.MatchString(info) {
+        t.Errorf("Version info %q", info)
+    }
+
+    version, buildTime := Version, BuildTime
+    t.Cleanup(func() { Version, BuildTime = version, buildTime })
+    Version, BuildTime = "v1.2.3", "2024-01-01"
+    info := versionInfo()
+    if !format.MatchString(info) || !regexp.MustCompile(`^dupefinder v1\.2\.3 .*built: 2024-01-01\)$`).MatchString(info) {
+        t.Errorf("Version info %q, expected v1.2.3 built 2024-01-01", info)
+    }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "os"
+    "sync"
+    "context"
+    "io/fs"
+    "path/filepath"
+
+    "golang.org/x/sync/errgroup"
+)
+
+func parallelWalkDir(ctx context.Context, root string, parallelism int, fn fs.WalkDirFunc) error {
+    //Like filepath.WalkDir, but directories are read concurrently
+    //Callback calls are serialized, a directory is always visited before its contents
+    var mutex sync.Mutex
+    call := func(path string, d fs.DirEntry, err error) error {
+        mutex.Lock()
+        defer mutex.Unlock()
+        return fn(path, d, err)
+    }
+
+    fi, err := os.Lstat(root)
+    if err != nil {
+        return call(root, nil, err)
+    }
+
+    //Limit number of directories read at the same time
+    //Directory is read in current goroutine if limit is reached
+    g, ctx := errgroup.WithContext(ctx)
+    g.SetLimit(parallelism)
+    var walkDir func(path string, d fs.DirEntry) error
+    walkDir = func(path string, d fs.DirEntry) error {
+        if err := call(path, d, nil); err != nil {
+            if err == filepath.SkipDir {
+                return nil
+            }
+            return err
+        }
+        if !d.IsDir() {
+            return nil
+        }
+
+        //Read directory, entries read before an error are still walked
+        entries, err := os.ReadDir(path)
+        if err != nil {
+            if err := call(path, d, err); err != nil && err != filepath.SkipDir {
+                return err
+            }
+        }
+        for _, entry := range entries {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            entryPath := filepath.Join(path, entry.Name())
+            if entry.IsDir() {
+                entry := entry
+                walkSubdir := func() error {
+                    return walkDir(entryPath, entry)
+                }
+                if g.TryGo(walkSubdir) {
+                    continue
+                }
+                if err := walkSubdir(); err != nil {
+                    return err
+                }
+                continue
+            }
+            if err := walkDir(entryPath, entry); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    g.Go(func() error {
+        return walkDir(root, fs.FileInfoToDirEntry(fi))
+    })
+
+    return g.Wait()
+}
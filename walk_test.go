@@ -0,0 +1,201 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "sort"
+    "io/fs"
+    "context"
+    "sync"
+    "testing"
+    "reflect"
+    "path/filepath"
+
+    "go.uber.org/goleak"
+)
+
+func TestParallelWalkDir(t *testing.T) {
+    //Same paths as filepath.WalkDir, parent directory visited first
+    root := t.TempDir()
+    writeTestFiles(t, root, map[string]string{
+        "a": "", "sub/b": "", "sub/deeper/c": "", "other/d": "", "other/skipped/e": "",
+    })
+    walk := func(walkDir func(fn fs.WalkDirFunc) error) []string {
+        var paths []string
+        visited := make(map[string]bool)
+        err := walkDir(func(path string, d fs.DirEntry, err error) error {
+            if err != nil {
+                return err
+            }
+            if path != root && !visited[filepath.Dir(path)] {
+                t.Errorf("Visited before its directory: %s", path)
+            }
+            visited[path] = true
+            if d.IsDir() && d.Name() == "skipped" {
+                return filepath.SkipDir
+            }
+            paths = append(paths, path)
+            return nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        sort.Strings(paths)
+        return paths
+    }
+    expected := walk(func(fn fs.WalkDirFunc) error { return filepath.WalkDir(root, fn) })
+    for _, parallelism := range []int{1, 4} {
+        got := walk(func(fn fs.WalkDirFunc) error {
+            return parallelWalkDir(context.Background(), root, parallelism, fn)
+        })
+        if !reflect.DeepEqual(got, expected) {
+            t.Errorf("Parallelism %d: %v, expected %v", parallelism, got, expected)
+        }
+    }
+}
+
+func TestScanCancelNoLeak(t *testing.T) {
+    //Scan cancelled while walking a large tree, no goroutine is left behind
+    defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+    root := t.TempDir()
+    files := make(map[string]string)
+    for i := 0; i < 200; i++ {
+        for j := 0; j < 25; j++ {
+            files[fmt.Sprintf("%03d/%02d", i, j)] = fmt.Sprintf("content %d", j)
+        }
+    }
+    writeTestFiles(t, root, files)
+
+    for _, parallelWalk := range []bool{false, true} {
+        scan := NewScan()
+        scan.Paths = []string{root}
+        scan.ParallelWalk = parallelWalk
+        scan.WorkerCount = 4
+        ctx, cancel := context.WithCancel(context.Background())
+        var wg sync.WaitGroup
+        wg.Add(1)
+        scan.ScanContext(ctx, &wg)
+        <-scan.Progress //walk started
+        cancel()
+        wg.Wait()
+        if len(scan.Files) == len(files) {
+            t.Errorf("Parallel walk %t: all files scanned, cancelled too late", parallelWalk)
+        }
+    }
+}
+
+func writeBenchTree(b *testing.B, dirs int, filesPerDir int) string {
+    //Directories with empty files, two levels deep
+    b.Helper()
+    root := b.TempDir()
+    for i := 0; i < dirs; i++ {
+        dir := filepath.Join(root, fmt.Sprintf("%02d", i % 10), fmt.Sprintf("%04d", i))
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            b.Fatal(err)
+        }
+        for j := 0; j < filesPerDir; j++ {
+            if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%04d", j)), nil, 0644); err != nil {
+                b.Fatal(err)
+            }
+        }
+    }
+    return root
+}
+
+func BenchmarkWalk(b *testing.B) {
+    //filepath.Walk calls Lstat for each entry, WalkDir uses the directory entry type
+    //Syscalls can be counted with strace -c -f on the test binary
+    root := writeBenchTree(b, 100, 100)
+    b.Run("Walk", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            var count int
+            filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+                if err == nil && fi.Mode().IsRegular() {
+                    count++
+                }
+                return err
+            })
+        }
+    })
+    b.Run("WalkDir", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            var count int
+            filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+                if err == nil && d.Type().IsRegular() {
+                    count++
+                }
+                return err
+            })
+        }
+    })
+
+    //Concurrent directory reads (-parallel-walk), callback calls are serialized
+    for _, parallelism := range []int{1, 4, 16} {
+        b.Run(fmt.Sprintf("ParallelWalkDir/%d", parallelism), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                var count int
+                parallelWalkDir(context.Background(), root, parallelism, func(path string, d fs.DirEntry, err error) error {
+                    if err == nil && d.Type().IsRegular() {
+                        count++
+                    }
+                    return err
+                })
+            }
+        })
+    }
+}
+
+func TestExcludeDirNames(t *testing.T) {
+    //Excluded at any depth, nothing below is scanned
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{
+        "a/file.txt": "same",
+        "a/node_modules/file.txt": "same",
+        "a/node_modules/b/node_modules/file.txt": "same",
+        "c/node_modules_old/file.txt": "same",
+    })
+    scan := NewScan()
+    scan.Paths = []string{dir}
+    scan.ExcludeDirNames = []string{"node_modules"}
+    runScan(t, scan)
+    var paths []string
+    for path := range scan.Files {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    expected := []string{filepath.Join(dir, "a", "file.txt"), filepath.Join(dir, "c", "node_modules_old", "file.txt")}
+    if !reflect.DeepEqual(paths, expected) {
+        t.Errorf("Files %v, expected %v", paths, expected)
+    }
+}
+
+func TestMaxDepth(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFiles(t, dir, map[string]string{"a": "same", "b": "same", "sub/c": "same", "sub/deep/d": "same"})
+    tests := []struct {
+        name string
+        maxDepth int
+        expected []string
+    }{
+        {"no recurse", 1, []string{"a", "b"}},
+        {"one level", 2, []string{"a", "b", "sub/c"}},
+        {"no limit", 0, []string{"a", "b", "sub/c", "sub/deep/d"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            scan := NewScan()
+            scan.Paths = []string{dir}
+            scan.MaxDepth = test.maxDepth
+            runScan(t, scan)
+            var paths []string
+            for path := range scan.Files {
+                rel, _ := filepath.Rel(dir, path)
+                paths = append(paths, filepath.ToSlash(rel))
+            }
+            sort.Strings(paths)
+            if !reflect.DeepEqual(paths, test.expected) {
+                t.Errorf("Files %v, expected %v", paths, test.expected)
+            }
+        })
+    }
+}